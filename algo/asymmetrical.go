@@ -0,0 +1,139 @@
+package algo
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+const (
+	// RSAKeySize is the size in bits of the rsa keys generated by kripto
+	RSAKeySize = 2048
+)
+
+// ErrBadPEM is returned when the key is not pem encoded
+var ErrBadPEM = errors.New("asymmetrical: key must be pem encoded")
+
+type (
+	// Asymmetrical represents a collection of key pair algorithms
+	// An asymmetrical algorithm is the one that uses a private key to sign and a public key to verify
+	Asymmetrical struct {
+	}
+)
+
+// NewAsymmetrical returns a reference to the type and access to its funcionalities
+func NewAsymmetrical() *Asymmetrical {
+	return &Asymmetrical{}
+}
+
+// GenerateRSA creates a new rsa key pair returning both keys pem encoded
+func (a *Asymmetrical) GenerateRSA(bits int) ([]byte, []byte, error) {
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	public, err := encodePublic(&key.PublicKey)
+	return private, public, err
+}
+
+// GenerateECDSA creates a new P-256 ecdsa key pair returning both keys pem encoded
+func (a *Asymmetrical) GenerateECDSA() ([]byte, []byte, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	})
+
+	public, err := encodePublic(&key.PublicKey)
+	return private, public, err
+}
+
+// GenerateEd25519 creates a new ed25519 key pair returning both keys pem encoded
+func (a *Asymmetrical) GenerateEd25519() ([]byte, []byte, error) {
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	})
+
+	public, err := encodePublic(pub)
+	return private, public, err
+}
+
+// ParsePrivateKey decodes a pem encoded rsa, ecdsa or ed25519 private key
+func (a *Asymmetrical) ParsePrivateKey(data []byte) (interface{}, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrBadPEM
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+// ParsePublicKey decodes a pem encoded rsa, ecdsa or ed25519 public key
+func (a *Asymmetrical) ParsePublicKey(data []byte) (interface{}, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrBadPEM
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func encodePublic(key interface{}) ([]byte, error) {
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}), nil
+}
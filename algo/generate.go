@@ -0,0 +1,282 @@
+package algo
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/sethvargo/go-diceware/diceware"
+)
+
+const (
+	// ClassLower holds the lower case letters
+	ClassLower = "lower"
+	// ClassUpper holds the upper case letters
+	ClassUpper = "upper"
+	// ClassDigit holds the decimal digits
+	ClassDigit = "digit"
+	// ClassSymbol holds the printable ascii symbols
+	ClassSymbol = "symbol"
+
+	// EncodingBase64 encodes random bytes as standard base64
+	EncodingBase64 = "base64"
+	// EncodingHex encodes random bytes as lower case hex
+	EncodingHex = "hex"
+
+	// MaxGenerateLength is the longest password, in characters, or random data, in bytes, generated
+	MaxGenerateLength = 1024
+	// MaxPassphraseWords is the longest passphrase, in words, generated
+	MaxPassphraseWords = 64
+	// PassphraseSeparator joins the words of a passphrase when no other separator is asked for
+	PassphraseSeparator = "-"
+)
+
+var (
+	// ErrBadLength is returned when the length is not positive, above MaxGenerateLength or too short for the required classes
+	ErrBadLength = errors.New("generate: bad length")
+	// ErrUnknownClass is returned when asking for an unknown character class
+	ErrUnknownClass = errors.New("generate: unknown character class")
+	// ErrUnknownEncoding is returned when asking for an unknown encoding
+	ErrUnknownEncoding = errors.New("generate: unknown encoding")
+	// ErrEmptyCharset is returned when the exclusions or the charset leave a required class, or every class, without characters
+	ErrEmptyCharset = errors.New("generate: no characters left to pick from")
+	// ErrBadWords is returned when the number of words is out of range or the word list holds less than two words
+	ErrBadWords = errors.New("generate: bad number of words or word list")
+
+	classes = map[string]string{
+		ClassLower:  "abcdefghijklmnopqrstuvwxyz",
+		ClassUpper:  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		ClassDigit:  "0123456789",
+		ClassSymbol: "!#$%&()*+,-./:;<=>?@[]^_{|}~",
+	}
+
+	defaultClasses = []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol}
+)
+
+type (
+	// PasswordRules tells how passwords are generated
+	// Every class in Classes shows up at least once, the rest is picked out of the Charset or, when empty, out of the classes
+	// No classes and no charset means all of the classes, characters found in Exclude are never picked
+	PasswordRules struct {
+		Length  int
+		Charset string
+		Classes []string
+		Exclude string
+	}
+
+	// PassphraseRules tells how passphrases are generated, the EFF large word list is used when no WordList is given
+	PassphraseRules struct {
+		Words     int
+		WordList  []string
+		Separator string
+	}
+)
+
+// GeneratePassword returns a random password following the rules, every character is read from crypto/rand
+func GeneratePassword(rules PasswordRules) (string, error) {
+
+	required := rules.Classes
+	if len(required) == 0 && rules.Charset == "" {
+		required = defaultClasses
+	}
+
+	if rules.Length < 1 || rules.Length < len(required) || rules.Length > MaxGenerateLength {
+		return "", ErrBadLength
+	}
+
+	pool := ""
+	if rules.Charset != "" {
+		pool = allowed(rules.Charset, rules)
+	}
+
+	password := make([]byte, 0, rules.Length)
+
+	// one character of each required class first, the rest out of the pool
+	for _, class := range required {
+
+		chars, ok := classes[class]
+		if !ok {
+			return "", ErrUnknownClass
+		}
+
+		chars = allowed(chars, rules)
+		if chars == "" {
+			return "", ErrEmptyCharset
+		}
+
+		c, err := pick(chars)
+		if err != nil {
+			return "", err
+		}
+
+		password = append(password, c)
+
+		if rules.Charset == "" && !strings.Contains(pool, chars) {
+			pool += chars
+		}
+	}
+
+	if pool == "" {
+		return "", ErrEmptyCharset
+	}
+
+	for len(password) < rules.Length {
+
+		c, err := pick(pool)
+		if err != nil {
+			return "", err
+		}
+
+		password = append(password, c)
+	}
+
+	err := shuffle(password)
+	if err != nil {
+		return "", err
+	}
+
+	return string(password), nil
+}
+
+// GeneratePassphrase returns random words joined by the separator, every word is picked through crypto/rand
+func GeneratePassphrase(rules PassphraseRules) (string, error) {
+
+	if rules.Words < 1 || rules.Words > MaxPassphraseWords || (rules.WordList != nil && len(rules.WordList) < 2) {
+		return "", ErrBadWords
+	}
+
+	separator := rules.Separator
+	if separator == "" {
+		separator = PassphraseSeparator
+	}
+
+	if rules.WordList == nil {
+
+		words, err := diceware.Generate(rules.Words)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.Join(words, separator), nil
+	}
+
+	words := make([]string, rules.Words)
+
+	for i := range words {
+
+		j, err := randomIndex(len(rules.WordList))
+		if err != nil {
+			return "", err
+		}
+
+		words[i] = rules.WordList[j]
+	}
+
+	return strings.Join(words, separator), nil
+}
+
+// GenerateBytes returns n random bytes encoded as base64, the default, or hex
+func GenerateBytes(n int, encoding string) (string, error) {
+
+	if n < 1 || n > MaxGenerateLength {
+		return "", ErrBadLength
+	}
+
+	if encoding != "" && encoding != EncodingBase64 && encoding != EncodingHex {
+		return "", ErrUnknownEncoding
+	}
+
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	if encoding == EncodingHex {
+		return hex.EncodeToString(b), nil
+	}
+
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// GenerateUUID returns a random, version 4, uuid as RFC 4122 defines it
+func GenerateUUID() (string, error) {
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// allowed keeps the characters of the set found in the charset of the rules, when there is one, and not excluded
+func allowed(set string, rules PasswordRules) string {
+
+	kept := []byte{}
+
+	for i := 0; i < len(set); i++ {
+
+		c := set[i]
+
+		if strings.IndexByte(rules.Exclude, c) >= 0 || strings.IndexByte(string(kept), c) >= 0 {
+			continue
+		}
+
+		if rules.Charset != "" && strings.IndexByte(rules.Charset, c) < 0 {
+			continue
+		}
+
+		kept = append(kept, c)
+	}
+
+	return string(kept)
+}
+
+// pick returns a character of the set chosen uniformly
+func pick(set string) (byte, error) {
+
+	i, err := randomIndex(len(set))
+	if err != nil {
+		return 0, err
+	}
+
+	return set[i], nil
+}
+
+// shuffle reorders the bytes in place, Fisher-Yates over crypto/rand
+func shuffle(b []byte) error {
+
+	for i := len(b) - 1; i > 0; i-- {
+
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return err
+		}
+
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return nil
+}
+
+// randomIndex returns a uniform random number within [0, n)
+func randomIndex(n int) (int, error) {
+
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(i.Int64()), nil
+}
@@ -0,0 +1,19 @@
+package algo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RandomHex returns n random bytes read from crypto/rand encoded as hex
+func RandomHex(n int) (string, error) {
+
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,242 @@
+package algo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // #nosec RFC 6238 authenticator apps default to HMAC-SHA1
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPSecretSize is the size in bytes of the generated totp secrets, as RFC 4226 recommends
+	TOTPSecretSize = 20
+	// TOTPDigits is the default number of digits of a code
+	TOTPDigits = 6
+	// TOTPPeriod is the default time step of a code
+	TOTPPeriod = 30 * time.Second
+	// TOTPSHA1 is the default hmac algorithm understood by every authenticator app
+	TOTPSHA1 = "SHA1"
+	// TOTPSHA256 is the hmac-sha256 algorithm
+	TOTPSHA256 = "SHA256"
+	// TOTPSHA512 is the hmac-sha512 algorithm
+	TOTPSHA512 = "SHA512"
+	// TOTPMinDigits is the fewest digits of a code, as RFC 4226 asks for
+	TOTPMinDigits = 6
+	// TOTPMaxDigits is the most digits of a code
+	TOTPMaxDigits = 8
+)
+
+var (
+	// ErrBadTOTPSecret is returned when the secret is not base32 encoded
+	ErrBadTOTPSecret = errors.New("totp: bad secret")
+	// ErrBadTOTPAlgorithm is returned when asking for an unknown hmac algorithm
+	ErrBadTOTPAlgorithm = errors.New("totp: unsupported algorithm")
+	// ErrBadTOTPURI is returned when the uri is not an otpauth totp uri
+	ErrBadTOTPURI = errors.New("totp: bad otpauth uri")
+	// ErrBadTOTPParameters is returned when the digits are not within 6 and 8 or the period is under a second
+	ErrBadTOTPParameters = errors.New("totp: 6 to 8 digits and a period of at least 1s required")
+
+	secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+type (
+	// TOTP represents a time based one time password generator as RFC 6238 defines it
+	// The Secret is base32 encoded, the way authenticator apps expect it
+	TOTP struct {
+		Secret    string
+		Digits    int
+		Period    time.Duration
+		Algorithm string
+	}
+)
+
+// NewTOTP returns a TOTP of the secret using the defaults of authenticator apps, 6 digits every 30s with HMAC-SHA1
+func NewTOTP(secret string) *TOTP {
+	return &TOTP{
+		Secret:    secret,
+		Digits:    TOTPDigits,
+		Period:    TOTPPeriod,
+		Algorithm: TOTPSHA1,
+	}
+}
+
+// GenerateTOTPSecret returns a new random base32 encoded secret of size bytes
+func GenerateTOTPSecret(size int) (string, error) {
+
+	b := make([]byte, size)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return secretEncoding.EncodeToString(b), nil
+}
+
+// Step returns the time step the instant belongs to
+func (t *TOTP) Step(at time.Time) int64 {
+	return at.Unix() / int64(t.Period/time.Second)
+}
+
+// Code returns the code of the time step the instant belongs to
+func (t *TOTP) Code(at time.Time) (string, error) {
+	return t.code(t.Step(at))
+}
+
+// Validate checks the code against the time step of the instant and skew steps around it
+// The matching step is returned so callers may refuse a code already used
+func (t *TOTP) Validate(code string, at time.Time, skew int) (int64, bool, error) {
+
+	step := t.Step(at)
+
+	for i := -skew; i <= skew; i++ {
+
+		expected, err := t.code(step + int64(i))
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + int64(i), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// URI returns the otpauth uri authenticator apps enroll from, usually shown as a qr code
+func (t *TOTP) URI(issuer, account string) string {
+
+	query := url.Values{}
+	query.Set("secret", t.Secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", t.Algorithm)
+	query.Set("digits", fmt.Sprintf("%d", t.Digits))
+	query.Set("period", fmt.Sprintf("%d", int64(t.Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ParseTOTPURI reads the otpauth uri authenticator apps enroll from returning the TOTP along with its issuer and account
+// Parameters left out take the defaults of authenticator apps, the issuer parameter wins over the one of the label
+func ParseTOTPURI(uri string) (*TOTP, string, string, error) {
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "otpauth" || u.Host != "totp" {
+		return nil, "", "", ErrBadTOTPURI
+	}
+
+	issuer, account := "", strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(account, ":"); i >= 0 {
+		issuer, account = account[:i], strings.TrimSpace(account[i+1:])
+	}
+
+	query := u.Query()
+	if value := query.Get("issuer"); value != "" {
+		issuer = value
+	}
+
+	t := NewTOTP(strings.ToUpper(strings.Replace(query.Get("secret"), " ", "", -1)))
+
+	if value := query.Get("algorithm"); value != "" {
+		t.Algorithm = strings.ToUpper(value)
+	}
+
+	if value := query.Get("digits"); value != "" {
+		t.Digits, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, "", "", ErrBadTOTPURI
+		}
+	}
+
+	if value := query.Get("period"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, "", "", ErrBadTOTPURI
+		}
+		t.Period = time.Duration(seconds) * time.Second
+	}
+
+	err = t.Check()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return t, issuer, account, nil
+}
+
+// Check reports whether the secret decodes, the algorithm is known and the digits and period are within range
+func (t *TOTP) Check() error {
+
+	if t.Secret == "" {
+		return ErrBadTOTPSecret
+	}
+
+	if t.Digits < TOTPMinDigits || t.Digits > TOTPMaxDigits || t.Period < time.Second {
+		return ErrBadTOTPParameters
+	}
+
+	_, err := t.code(0)
+	return err
+}
+
+// code computes the HOTP value of the counter as RFC 4226 defines it
+func (t *TOTP) code(counter int64) (string, error) {
+
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(t.Secret, "=")))
+	if err != nil {
+		return "", ErrBadTOTPSecret
+	}
+
+	h, err := totpHash(t.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(h, key)
+	_, err = mac.Write(msg)
+	if err != nil {
+		return "", err
+	}
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < t.Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", t.Digits, value%mod), nil
+}
+
+func totpHash(algorithm string) (func() hash.Hash, error) {
+
+	switch strings.ToUpper(algorithm) {
+	case TOTPSHA1, "":
+		return sha1.New, nil
+	case TOTPSHA256:
+		return sha256.New, nil
+	case TOTPSHA512:
+		return sha512.New, nil
+	default:
+		return nil, ErrBadTOTPAlgorithm
+	}
+}
@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/NeowayLabs/logger"
+	"github.com/ffhenkes/kripto/fs"
+)
+
+var logA = logger.Namespace("kripto.audit")
+
+const (
+	dataAudit = "/data/audit"
+	auditName = "audit"
+)
+
+var mutex sync.Mutex
+
+type (
+	// Entry represents a single audited operation
+	// It never carries secret values, only who did what and to whom
+	Entry struct {
+		Time    time.Time `json:"time"`
+		Actor   string    `json:"actor"`
+		Action  string    `json:"action"`
+		Target  string    `json:"target"`
+		Success bool      `json:"success"`
+		Error   string    `json:"error,omitempty"`
+	}
+)
+
+// Record logs the operation and appends it to the audit trail kept into the audit directory
+func Record(actor, action, target string, err error) {
+
+	e := Entry{
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Success: err == nil,
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	je, jerr := json.Marshal(e)
+	if jerr != nil {
+		logA.Error("Json parser return with errors: %v", jerr)
+		return
+	}
+
+	logA.Info("%s", je)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	sys := fs.NewFileSystem(dataAudit)
+	if ferr := sys.AppendLog(auditName, je); ferr != nil {
+		logA.Error("Audit trail not persisted: %v", ferr)
+	}
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	kindAPIKey   = "apikey"
+	apiKeyPrefix = "krk_"
+	apiKeyIDSize = 8
+	apiKeySize   = 32
+)
+
+var (
+	// APIKeyTTL is how long api keys live when no ttl is asked for
+	APIKeyTTL = 90 * 24 * time.Hour
+	// APIKeyMaxTTL is the longest ttl an api key may ask for
+	APIKeyMaxTTL = 365 * 24 * time.Hour
+
+	// ErrAPIKeyNotFound is returned when the requested api key does not exist
+	ErrAPIKeyNotFound = errors.New("apikey: not found")
+	// ErrInvalidAPIKey is returned on any api key failure, without telling which part failed
+	ErrInvalidAPIKey = errors.New("apikey: non valid key")
+	// ErrBadAPIKey is returned when the api key carries a bad ttl or cidrs, or no policy at all
+	ErrBadAPIKey = errors.New("apikey: bad ttl, cidr or policies")
+	// ErrPolicyNotHeld is returned when the api key asks for a policy its owner does not hold
+	ErrPolicyNotHeld = errors.New("apikey: policy not held by the owner")
+)
+
+// NewAPIKey creates an api key for the principal carrying a subset of its policies
+// The key itself is returned once and only its hash is kept
+func NewAPIKey(principal *model.Principal, req *model.APIKeyRequest, phrase string) (*model.APIKeyResponse, error) {
+
+	ttl := APIKeyTTL
+	if req.TTL != "" {
+
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, ErrBadAPIKey
+		}
+
+		ttl = d
+	}
+
+	if ttl <= 0 || ttl > APIKeyMaxTTL || len(req.Policies) == 0 {
+		return nil, ErrBadAPIKey
+	}
+
+	err := validateAppRole(0, 0, 0, req.BoundCIDRs)
+	if err != nil {
+		return nil, ErrBadAPIKey
+	}
+
+	held, err := heldPolicies(principal, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range req.Policies {
+		if !principal.Admin && !contains(held, name) {
+			return nil, ErrPolicyNotHeld
+		}
+	}
+
+	id, err := algo.RandomHex(apiKeyIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := algo.RandomHex(apiKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	// the id is part of the key so it is found without scanning every key
+	key := apiKeyPrefix + id + "_" + value
+	now := time.Now()
+
+	k := &model.APIKey{
+		ID:         id,
+		Hash:       hashSecretID(key),
+		Name:       req.Name,
+		Owner:      principal.Username,
+		Policies:   req.Policies,
+		BoundCIDRs: req.BoundCIDRs,
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(ttl).Unix(),
+	}
+
+	data, err := encryptJSON(k, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err = sys.MakeEntry(kindAPIKey, k.ID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.APIKeyResponse{ID: k.ID, Key: key, ExpiresAt: k.ExpiresAt}, nil
+}
+
+// ValidateAPIKey checks the api key presented from the remote ip and returns its record
+func ValidateAPIKey(key, remoteIP, phrase string) (*model.APIKey, error) {
+
+	key = strings.TrimSpace(key)
+
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	k, err := ReadAPIKey(parts[0], phrase)
+	if err == ErrAPIKeyNotFound || err == fs.ErrBadInput {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashSecretID(key))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if k.ExpiresAt < time.Now().Unix() {
+		logL.Warn("APIKey: %s of %s expired!", k.ID, k.Owner)
+		return nil, ErrInvalidAPIKey
+	}
+
+	if !boundTo(k.BoundCIDRs, remoteIP) {
+		logL.Warn("APIKey: %s of %s used from %s out of the bound cidrs!", k.ID, k.Owner, remoteIP)
+		return nil, ErrInvalidAPIKey
+	}
+
+	return k, nil
+}
+
+// APIKeyPrincipal returns the principal of the api key, holding the key policies its owner still holds
+func APIKeyPrincipal(k *model.APIKey, owner *model.Principal, phrase string) (*model.Principal, error) {
+
+	held, err := heldPolicies(owner, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []string{}
+	for _, name := range k.Policies {
+		if owner.Admin || contains(held, name) {
+			policies = append(policies, name)
+		}
+	}
+
+	return &model.Principal{
+		Username:  owner.Username,
+		Roles:     []string{},
+		Policies:  policies,
+		Method:    model.MethodAPIKey,
+		ExpiresAt: time.Unix(k.ExpiresAt, 0),
+	}, nil
+}
+
+// ReadAPIKey retrieves the api key record and decrypts it
+func ReadAPIKey(id, phrase string) (*model.APIKey, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadEntry(kindAPIKey, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	k := &model.APIKey{}
+	err = decryptJSON(data, phrase, k)
+	return k, err
+}
+
+// RemoveAPIKey drops the api key, it is refused from then on
+func RemoveAPIKey(id string) error {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err := sys.DeleteEntry(kindAPIKey, id)
+	if os.IsNotExist(err) {
+		return ErrAPIKeyNotFound
+	}
+
+	return err
+}
+
+// ListAPIKeys reads every api key of the owner, every api key at all when the owner is empty
+func ListAPIKeys(owner, phrase string) ([]*model.APIKey, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	ids, err := sys.ListEntries(kindAPIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*model.APIKey{}
+	for _, id := range ids {
+
+		k, err := ReadAPIKey(id, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if owner == "" || k.Owner == owner {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
+
+// RemoveAPIKeys drops every api key of the owner, used when the owner is removed
+func RemoveAPIKeys(owner, phrase string) error {
+
+	keys, err := ListAPIKeys(owner, phrase)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+
+		err = RemoveAPIKey(k.ID)
+		if err != nil && err != ErrAPIKeyNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PruneAPIKeys drops every expired api key and returns how many were dropped
+func PruneAPIKeys(phrase string) (int, error) {
+
+	keys, err := ListAPIKeys("", phrase)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	pruned := 0
+
+	for _, k := range keys {
+
+		if k.ExpiresAt > now {
+			continue
+		}
+
+		err = RemoveAPIKey(k.ID)
+		if err != nil && err != ErrAPIKeyNotFound {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// heldPolicies returns the name of every policy the principal holds directly or through its roles
+func heldPolicies(principal *model.Principal, phrase string) ([]string, error) {
+
+	policies, err := EffectivePolicies(principal, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	dataAppRole    = "/data/approle"
+	kindAppRole    = "approle"
+	kindSecretID   = "secretid"
+	roleIDSize     = 16
+	secretIDSize   = 32
+	secretIDPrefix = "krs_"
+	appRolePrefix  = "approle-"
+)
+
+var (
+	// ErrAppRoleNotFound is returned when the requested app role does not exist
+	ErrAppRoleNotFound = errors.New("approle: not found")
+	// ErrInvalidSecretID is returned on any app role login failure, without telling which part failed
+	ErrInvalidSecretID = errors.New("approle: non valid role id or secret id")
+	// ErrBadAppRole is returned when the app role carries bad durations, uses or cidrs
+	ErrBadAppRole = errors.New("approle: bad ttl, uses or cidr")
+)
+
+// secretIDs serializes secret id logins so a limited use secret id is never used more than allowed
+var secretIDs sync.Mutex
+
+// AppRolePrincipal returns the principal name of the tokens issued through the app role
+func AppRolePrincipal(name string) string {
+	return appRolePrefix + name
+}
+
+// WriteAppRole validates and encrypts the app role storing it, a role id is generated when missing
+func WriteAppRole(role *model.AppRole, phrase string) error {
+
+	err := validateAppRole(role.TokenTTL, role.SecretIDTTL, role.SecretIDUses, role.BoundCIDRs)
+	if err != nil {
+		return err
+	}
+
+	if role.RoleID == "" {
+		role.RoleID, err = algo.RandomHex(roleIDSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := encryptJSON(role, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAppRole)
+	return sys.MakeEntry(kindAppRole, role.Name, data)
+}
+
+// ReadAppRole retrieves the app role and decrypts it
+func ReadAppRole(name, phrase string) (*model.AppRole, error) {
+
+	sys := fs.NewFileSystem(dataAppRole)
+
+	data, err := sys.ReadEntry(kindAppRole, name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrAppRoleNotFound
+		}
+		return nil, err
+	}
+
+	role := &model.AppRole{}
+	err = decryptJSON(data, phrase, role)
+	return role, err
+}
+
+// RemoveAppRole drops the app role along with every secret id issued for it
+func RemoveAppRole(name, phrase string) error {
+
+	sys := fs.NewFileSystem(dataAppRole)
+
+	err := sys.DeleteEntry(kindAppRole, name)
+	if os.IsNotExist(err) {
+		return ErrAppRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	ids, err := sys.ListEntries(kindSecretID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+
+		secret, err := readSecretID(id, phrase)
+		if err != nil {
+			return err
+		}
+
+		if secret == nil || secret.Role != name {
+			continue
+		}
+
+		err = sys.DeleteEntry(kindSecretID, id)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListAppRoles reads and decrypts every app role
+func ListAppRoles(phrase string) ([]*model.AppRole, error) {
+
+	sys := fs.NewFileSystem(dataAppRole)
+
+	names, err := sys.ListEntries(kindAppRole)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []*model.AppRole{}
+	for _, name := range names {
+
+		role, err := ReadAppRole(name, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// NewSecretID issues a secret id for the app role, the cidrs may only narrow down the role bindings
+// The secret id itself is returned once and only its hash is kept
+func NewSecretID(name string, cidrs []string, phrase string) (*model.SecretIDResponse, error) {
+
+	role, err := ReadAppRole(name, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateAppRole(0, 0, 0, cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := algo.RandomHex(secretIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	secretID := secretIDPrefix + value
+
+	secret := &model.SecretID{
+		ID:         hashSecretID(secretID),
+		Role:       role.Name,
+		Uses:       role.SecretIDUses,
+		BoundCIDRs: cidrs,
+	}
+
+	if role.SecretIDTTL > 0 {
+		secret.ExpiresAt = time.Now().Add(role.SecretIDTTL).Unix()
+	}
+
+	err = writeSecretID(secret, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SecretIDResponse{
+		SecretID:  secretID,
+		ExpiresAt: secret.ExpiresAt,
+		Uses:      secret.Uses,
+	}, nil
+}
+
+// LoginAppRole trades a role id and secret id, presented from the remote ip, for a token carrying the role policies
+func LoginAppRole(login *model.AppRoleLogin, remoteIP string, phrase string) (*model.TokenResponse, error) {
+
+	secretIDs.Lock()
+	defer secretIDs.Unlock()
+
+	role, err := findAppRole(login.RoleID, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	id := hashSecretID(login.SecretID)
+
+	secret, err := readSecretID(id, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil || secret.Role != role.Name {
+		return nil, ErrInvalidSecretID
+	}
+
+	sys := fs.NewFileSystem(dataAppRole)
+
+	if secret.ExpiresAt > 0 && secret.ExpiresAt < time.Now().Unix() {
+		err = sys.DeleteEntry(kindSecretID, id)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, ErrInvalidSecretID
+	}
+
+	if !boundTo(role.BoundCIDRs, remoteIP) || !boundTo(secret.BoundCIDRs, remoteIP) {
+		logL.Warn("AppRole: %s Login from %s out of the bound cidrs!", role.Name, remoteIP)
+		return nil, ErrInvalidSecretID
+	}
+
+	switch secret.Uses {
+	case 0:
+	case 1:
+		err = sys.DeleteEntry(kindSecretID, id)
+	default:
+		secret.Uses--
+		err = writeSecretID(secret, phrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := AccessTokenTTL
+	if role.TokenTTL > 0 {
+		ttl = role.TokenTTL
+	}
+
+	jwta := NewMachineJwtAuth(&model.Credentials{
+		Username:       AppRolePrincipal(role.Name),
+		TokenExpiresIn: ttl,
+	}, model.MethodAppRole, role.Policies)
+
+	tokenString, claims, err := jwta.IssueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		Token:     tokenString,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// PruneSecretIDs drops every expired secret id and returns how many were dropped
+func PruneSecretIDs(phrase string) (int, error) {
+
+	sys := fs.NewFileSystem(dataAppRole)
+	now := time.Now().Unix()
+	pruned := 0
+
+	ids, err := sys.ListEntries(kindSecretID)
+	if err != nil {
+		return pruned, err
+	}
+
+	for _, id := range ids {
+
+		secret, err := readSecretID(id, phrase)
+		if err != nil {
+			return pruned, err
+		}
+
+		if secret != nil && (secret.ExpiresAt == 0 || secret.ExpiresAt > now) {
+			continue
+		}
+
+		err = sys.DeleteEntry(kindSecretID, id)
+		if err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// findAppRole looks up the app role owning the role id
+func findAppRole(roleID, phrase string) (*model.AppRole, error) {
+
+	if roleID == "" {
+		return nil, ErrInvalidSecretID
+	}
+
+	roles, err := ListAppRoles(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if subtle.ConstantTimeCompare([]byte(role.RoleID), []byte(roleID)) == 1 {
+			return role, nil
+		}
+	}
+
+	return nil, ErrInvalidSecretID
+}
+
+// validateAppRole checks the durations and uses are not negative, the token ttl fits the revocation retention and cidrs parse
+func validateAppRole(tokenTTL, secretIDTTL time.Duration, uses int, cidrs []string) error {
+
+	if tokenTTL < 0 || tokenTTL > defaultTokenLifetime || secretIDTTL < 0 || uses < 0 {
+		return ErrBadAppRole
+	}
+
+	for _, cidr := range cidrs {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return ErrBadAppRole
+		}
+	}
+
+	return nil
+}
+
+// boundTo tells whether the ip belongs to any of the cidrs, no cidrs means no binding at all
+func boundTo(cidrs []string, remoteIP string) bool {
+
+	if len(cidrs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hashSecretID(secretID string) string {
+	return fmt.Sprintf("%x", algo.MakeSimpleHash(secretID))
+}
+
+func writeSecretID(secret *model.SecretID, phrase string) error {
+
+	data, err := encryptJSON(secret, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAppRole)
+	return sys.MakeEntry(kindSecretID, secret.ID, data)
+}
+
+// readSecretID returns the secret id record or nil when there is none
+func readSecretID(id, phrase string) (*model.SecretID, error) {
+
+	sys := fs.NewFileSystem(dataAppRole)
+
+	data, err := sys.ReadEntry(kindSecretID, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	secret := &model.SecretID{}
+	err = decryptJSON(data, phrase, secret)
+	return secret, err
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	kindCertRole = "certrole"
+	certPrefix   = "cert-"
+)
+
+var (
+	// ErrCertRoleNotFound is returned when the requested cert role does not exist
+	ErrCertRoleNotFound = errors.New("cert: role not found")
+	// ErrCertNotMapped is returned when no cert role matches the client certificate
+	ErrCertNotMapped = errors.New("cert: certificate not mapped to any role")
+	// ErrBadCertRole is returned when the cert role has no pattern or a bad token ttl
+	ErrBadCertRole = errors.New("cert: role needs a common name or dns name pattern and a ttl up to 24h")
+	// ErrBadClientCA is returned when the client CA bundle holds no certificate
+	ErrBadClientCA = errors.New("cert: no certificate found in the client CA bundle")
+)
+
+// CertPrincipal returns the principal name of the clients authenticated through the cert role
+func CertPrincipal(name string) string {
+	return certPrefix + name
+}
+
+// ClientTLSConfig returns the tls config verifying client certificates against the pem encoded CA bundle
+// Clients without a certificate are still served unless required
+func ClientTLSConfig(bundle []byte, require bool) (*tls.Config, error) {
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, ErrBadClientCA
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if require {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: clientAuth,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// WriteCertRole validates and encrypts the cert role storing it into the authdb
+func WriteCertRole(role *model.CertRole, phrase string) error {
+
+	if len(role.CommonNames) == 0 && len(role.DNSNames) == 0 {
+		return ErrBadCertRole
+	}
+
+	if role.TokenTTL < 0 || role.TokenTTL > defaultTokenLifetime {
+		return ErrBadCertRole
+	}
+
+	data, err := encryptJSON(role, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+	return sys.MakeEntry(kindCertRole, role.Name, data)
+}
+
+// ReadCertRole retrieves the cert role from the authdb and decrypts it
+func ReadCertRole(name, phrase string) (*model.CertRole, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadEntry(kindCertRole, name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCertRoleNotFound
+		}
+		return nil, err
+	}
+
+	role := &model.CertRole{}
+	err = decryptJSON(data, phrase, role)
+	return role, err
+}
+
+// RemoveCertRole drops the cert role from the authdb
+func RemoveCertRole(name string) error {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err := sys.DeleteEntry(kindCertRole, name)
+	if os.IsNotExist(err) {
+		return ErrCertRoleNotFound
+	}
+
+	return err
+}
+
+// ListCertRoles reads and decrypts every cert role sorted by name
+func ListCertRoles(phrase string) ([]*model.CertRole, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	names, err := sys.ListEntries(kindCertRole)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	roles := []*model.CertRole{}
+	for _, name := range names {
+
+		role, err := ReadCertRole(name, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// MatchCertRole returns the first cert role, by name, matching the subject common name or dns names of the certificate
+// The certificate must have been verified against the client CA already
+func MatchCertRole(cert *x509.Certificate, phrase string) (*model.CertRole, error) {
+
+	roles, err := ListCertRoles(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+
+		for _, pattern := range role.CommonNames {
+			if cert.Subject.CommonName != "" && MatchPattern(pattern, cert.Subject.CommonName) {
+				return role, nil
+			}
+		}
+
+		for _, pattern := range role.DNSNames {
+			for _, name := range cert.DNSNames {
+				if MatchPattern(pattern, name) {
+					return role, nil
+				}
+			}
+		}
+	}
+
+	return nil, ErrCertNotMapped
+}
+
+// CertRolePrincipal returns the principal of a client authenticated by the certificate of the cert role
+func CertRolePrincipal(role *model.CertRole) *model.Principal {
+	return &model.Principal{
+		Username: CertPrincipal(role.Name),
+		Policies: role.Policies,
+		Method:   model.MethodCert,
+	}
+}
+
+// LoginCert trades a verified client certificate for a token carrying the policies of the matching cert role
+func LoginCert(cert *x509.Certificate, phrase string) (*model.TokenResponse, error) {
+
+	role, err := MatchCertRole(cert, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := AccessTokenTTL
+	if role.TokenTTL > 0 {
+		ttl = role.TokenTTL
+	}
+
+	jwta := NewMachineJwtAuth(&model.Credentials{
+		Username:       CertPrincipal(role.Name),
+		TokenExpiresIn: ttl,
+	}, model.MethodCert, role.Policies)
+
+	tokenString, claims, err := jwta.IssueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		Token:     tokenString,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ffhenkes/kripto/model"
+)
+
+var (
+	// Issuer is set as the iss of every token and required from every token validated
+	Issuer = "kripto"
+	// Audience is the aud of the tokens accepted by kripto itself
+	Audience = "kripto"
+	// Audiences lists the other services tokens may be minted for
+	Audiences = []string{}
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat against the clock
+	ClockSkew = 30 * time.Second
+
+	// ErrTokenExpired is returned when the token exp is in the past
+	ErrTokenExpired = errors.New("jwt: token expired")
+	// ErrTokenNotYetValid is returned when the token nbf or iat is in the future
+	ErrTokenNotYetValid = errors.New("jwt: token not valid yet")
+	// ErrBadIssuer is returned when the token was not issued by the expected issuer
+	ErrBadIssuer = errors.New("jwt: unexpected issuer")
+	// ErrBadAudience is returned when the token was minted for another audience
+	ErrBadAudience = errors.New("jwt: unexpected audience")
+	// ErrUnknownAudience is returned when minting a token for an audience that is not configured
+	ErrUnknownAudience = errors.New("jwt: unknown audience")
+)
+
+// KnownAudience tells whether tokens may be minted for the audience
+func KnownAudience(audience string) bool {
+	return audience == Audience || contains(Audiences, audience)
+}
+
+// validateClaims checks every standard claim allowing the clock skew on the time based ones
+func validateClaims(claims *model.CustomClaims, audience string) error {
+
+	now := time.Now()
+	skew := int64(ClockSkew / time.Second)
+
+	if claims.ExpiresAt == 0 || now.Unix() > claims.ExpiresAt+skew {
+		return ErrTokenExpired
+	}
+
+	if now.Unix() < claims.NotBefore-skew || now.Unix() < claims.IssuedAt-skew {
+		return ErrTokenNotYetValid
+	}
+
+	if claims.Issuer != Issuer {
+		return ErrBadIssuer
+	}
+
+	if claims.Audience != audience {
+		return ErrBadAudience
+	}
+
+	if claims.Id == "" || claims.Subject == "" || claims.Subject != claims.Username {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
@@ -0,0 +1,728 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NeowayLabs/logger"
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	// registers the postgres driver
+	_ "github.com/lib/pq"
+)
+
+const (
+	dataDatabase        = "/data/database"
+	kindDatabaseConfig  = "dbconfig"
+	kindDatabaseRole    = "dbrole"
+	kindDatabaseLease   = "dblease"
+	databaseLeaseIDSize = 8
+	databaseUserSize    = 4
+	databasePassSize    = 16
+	databaseUserPrefix  = "k-"
+	databaseUserMaxLen  = 63
+
+	// DatabaseLeasePrefix starts the id of every database lease, database/<role>/<id>
+	DatabaseLeasePrefix = "database/"
+)
+
+var logD = logger.Namespace("kripto.database")
+
+var (
+	// DatabaseTTL is how long database users live when neither the request nor the role tell
+	DatabaseTTL = time.Hour
+	// DatabaseMaxTTL is the longest database users live, renewals included, when the role does not tell
+	DatabaseMaxTTL = 24 * time.Hour
+
+	// ErrDatabaseNotFound is returned when the requested database connection does not exist
+	ErrDatabaseNotFound = errors.New("database: connection not found")
+	// ErrDatabaseRoleNotFound is returned when the requested database role does not exist
+	ErrDatabaseRoleNotFound = errors.New("database: role not found")
+	// ErrDatabaseLeaseNotFound is returned when the database lease is unknown or expired
+	ErrDatabaseLeaseNotFound = errors.New("database: lease not found")
+	// ErrBadDatabaseConfig is returned when the connection lacks a supported plugin or the connection url
+	ErrBadDatabaseConfig = errors.New("database: postgresql plugin and connection url required")
+	// ErrBadDatabaseRole is returned when the role lacks its database or creation statements or carries bad ttls
+	ErrBadDatabaseRole = errors.New("database: database, creation statements and valid ttls required")
+	// ErrDatabaseRoleNotAllowed is returned when the connection does not allow the role
+	ErrDatabaseRoleNotAllowed = errors.New("database: role not allowed by the connection")
+	// ErrDatabaseInUse is returned when removing a database connection users are still leased through
+	ErrDatabaseInUse = errors.New("database: connection has active leases")
+	// ErrDatabaseUnreachable is returned when the database refuses the connection or a statement
+	ErrDatabaseUnreachable = errors.New("database: connection or statement failed")
+)
+
+// postgresRenewal moves the expiry kept by the database along with the lease when the role does not tell how
+var postgresRenewal = []string{
+	`ALTER ROLE "{{name}}" VALID UNTIL '{{expiration}}';`,
+}
+
+// postgresRevocation drops the user when the role does not tell how
+var postgresRevocation = []string{
+	`REVOKE ALL PRIVILEGES ON ALL TABLES IN SCHEMA public FROM "{{name}}";`,
+	`DROP ROLE IF EXISTS "{{name}}";`,
+}
+
+// databaseLocks serializes the changes to the leases of each connection so a user is never dropped twice
+// nor handed out through a connection being removed, a slow database only holds back its own leases
+var (
+	databaseLocks   = map[string]*sync.Mutex{}
+	databaseLocksMu sync.Mutex
+)
+
+type (
+	// Database runs the statements creating and dropping users against a database
+	Database interface {
+		Execute(statements []string, values map[string]string) error
+		Close() error
+	}
+
+	// postgres is the Database backed by a PostgreSQL server
+	postgres struct {
+		db *sql.DB
+	}
+)
+
+// NewDatabase connects to the database of the configuration, tests swap it for an in-process stand-in
+var NewDatabase = func(config *model.DatabaseConfig) (Database, error) {
+
+	db, err := sql.Open("postgres", connectionURL(config))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &postgres{db: db}, nil
+}
+
+// Execute runs every statement within a single transaction after expanding its placeholders
+func (p *postgres) Execute(statements []string, values map[string]string) error {
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+
+		_, err = tx.Exec(expand(statement, values))
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close releases the connections to the database
+func (p *postgres) Close() error {
+	return p.db.Close()
+}
+
+// WriteDatabaseConfig validates the connection and encrypts it storing it, an empty password keeps the one already stored
+// The connection is opened once so a typo shows up right away instead of on the first read
+func WriteDatabaseConfig(config *model.DatabaseConfig, phrase string) error {
+
+	if config.Plugin != model.PluginPostgreSQL || config.ConnectionURL == "" {
+		return ErrBadDatabaseConfig
+	}
+
+	if config.Password == "" {
+		current, err := ReadDatabaseConfig(config.Name, phrase)
+		if err != nil && err != ErrDatabaseNotFound {
+			return err
+		}
+		if current != nil {
+			config.Password = current.Password
+		}
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	_ = db.Close()
+
+	data, err := encryptJSON(config, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataDatabase)
+	return sys.MakeEntry(kindDatabaseConfig, config.Name, data)
+}
+
+// ReadDatabaseConfig retrieves the database connection and decrypts it
+func ReadDatabaseConfig(name, phrase string) (*model.DatabaseConfig, error) {
+
+	config := &model.DatabaseConfig{}
+	err := readDatabaseEntry(kindDatabaseConfig, name, phrase, config, ErrDatabaseNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// RemoveDatabaseConfig drops the database connection, refused while users handed out through it are still leased
+// since those could never be dropped afterwards
+func RemoveDatabaseConfig(name, phrase string) error {
+
+	defer lockDatabase(name)()
+
+	sys := fs.NewFileSystem(dataDatabase)
+
+	ids, err := sys.ListEntries(kindDatabaseLease)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+
+		lease := &model.DatabaseLease{}
+		err = readDatabaseEntry(kindDatabaseLease, id, phrase, lease, ErrDatabaseLeaseNotFound)
+		if err == ErrDatabaseLeaseNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if lease.Database == name {
+			return ErrDatabaseInUse
+		}
+	}
+
+	err = sys.DeleteEntry(kindDatabaseConfig, name)
+	if os.IsNotExist(err) {
+		return ErrDatabaseNotFound
+	}
+
+	return err
+}
+
+// WriteDatabaseRole validates and encrypts the database role storing it
+func WriteDatabaseRole(role *model.DatabaseRole, phrase string) error {
+
+	if role.Database == "" || len(role.CreationStatements) == 0 {
+		return ErrBadDatabaseRole
+	}
+
+	ttl, max, err := roleTTLs(role)
+	if err != nil || ttl > max {
+		return ErrBadDatabaseRole
+	}
+
+	_, err = ReadDatabaseConfig(role.Database, phrase)
+	if err == ErrDatabaseNotFound {
+		return ErrBadDatabaseRole
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := encryptJSON(role, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataDatabase)
+	return sys.MakeEntry(kindDatabaseRole, role.Name, data)
+}
+
+// ReadDatabaseRole retrieves the database role and decrypts it
+func ReadDatabaseRole(name, phrase string) (*model.DatabaseRole, error) {
+
+	role := &model.DatabaseRole{}
+	err := readDatabaseEntry(kindDatabaseRole, name, phrase, role, ErrDatabaseRoleNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// RemoveDatabaseRole drops the database role, users already handed out live until their lease ends
+func RemoveDatabaseRole(name string) error {
+
+	sys := fs.NewFileSystem(dataDatabase)
+
+	err := sys.DeleteEntry(kindDatabaseRole, name)
+	if os.IsNotExist(err) {
+		return ErrDatabaseRoleNotFound
+	}
+
+	return err
+}
+
+// IssueDatabaseCredentials creates a new database user through the role and leases it
+// A zero ttl asks for the role default, any ttl is capped by the role max
+func IssueDatabaseCredentials(name string, ttl time.Duration, phrase string) (*model.DatabaseCredentials, error) {
+
+	role, err := ReadDatabaseRole(name, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	// the connection is not removed while the user is being handed out
+	defer lockDatabase(role.Database)()
+
+	config, err := ReadDatabaseConfig(role.Database, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if !contains(config.AllowedRoles, role.Name) && !contains(config.AllowedRoles, "*") {
+		return nil, ErrDatabaseRoleNotAllowed
+	}
+
+	defaultTTL, max, err := roleTTLs(role)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if ttl > max {
+		ttl = max
+	}
+
+	id, err := algo.RandomHex(databaseLeaseIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix, err := algo.RandomHex(databaseUserSize)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := algo.RandomHex(databasePassSize)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	lease := &model.DatabaseLease{
+		ID:                   DatabaseLeasePrefix + role.Name + "/" + id,
+		Role:                 role.Name,
+		Database:             role.Database,
+		Username:             databaseUsername(role.Name, suffix),
+		RenewStatements:      role.RenewStatements,
+		RevocationStatements: role.RevocationStatements,
+		TTL:                  int64(ttl.Seconds()),
+		IssuedAt:             now.Unix(),
+		ExpiresAt:            now.Add(ttl).Unix(),
+		MaxExpiresAt:         now.Add(max).Unix(),
+	}
+
+	err = execute(config, role.CreationStatements, map[string]string{
+		"name":       lease.Username,
+		"password":   password,
+		"expiration": expiration(lease.ExpiresAt),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeDatabaseLease(lease, phrase)
+	if err != nil {
+		// a user nobody keeps track of would never be dropped
+		if rerr := dropDatabaseUser(lease, phrase); rerr != nil {
+			logD.Error("Dropping user %s: %v", lease.Username, rerr)
+		}
+		return nil, err
+	}
+
+	return &model.DatabaseCredentials{
+		Username: lease.Username,
+		Password: password,
+		Lease:    databaseLeaseInfo(lease),
+	}, nil
+}
+
+// RenewDatabaseLease restarts the lease from now, by the increment when set or by its own ttl, never past its max
+// The renew statements move the expiry kept by the database along, the lease is left as it was when they fail
+func RenewDatabaseLease(id string, increment time.Duration, phrase string) (*model.Lease, error) {
+
+	lease, unlock, err := lockDatabaseLease(id, phrase)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	if lease.ExpiresAt <= now.Unix() {
+		return nil, ErrDatabaseLeaseNotFound
+	}
+
+	if increment <= 0 {
+		increment = time.Duration(lease.TTL) * time.Second
+	}
+
+	lease.ExpiresAt = now.Add(increment).Unix()
+	if lease.ExpiresAt > lease.MaxExpiresAt {
+		lease.ExpiresAt = lease.MaxExpiresAt
+	}
+
+	err = renewDatabaseUser(lease, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeDatabaseLease(lease, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return databaseLeaseInfo(lease), nil
+}
+
+// RevokeDatabaseLease drops the database user of the lease at once
+func RevokeDatabaseLease(id, phrase string) error {
+
+	lease, unlock, err := lockDatabaseLease(id, phrase)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return revokeDatabaseLease(lease, phrase)
+}
+
+// RevokeExpiredDatabaseLeases drops the database user of every expired lease and returns how many were dropped
+// A database failing to drop a user or a lease that can not be read does not hold back the others, the last failure is returned
+func RevokeExpiredDatabaseLeases(phrase string) (int, error) {
+
+	sys := fs.NewFileSystem(dataDatabase)
+
+	names, err := sys.ListEntries(kindDatabaseLease)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	revoked := 0
+	var last error
+
+	for _, name := range names {
+
+		lease := &model.DatabaseLease{}
+		err = readDatabaseEntry(kindDatabaseLease, name, phrase, lease, ErrDatabaseLeaseNotFound)
+		if err == ErrDatabaseLeaseNotFound {
+			continue
+		}
+		if err != nil {
+			logD.Error("Reading lease %s: %v", name, err)
+			last = err
+			continue
+		}
+
+		if lease.ExpiresAt > now {
+			continue
+		}
+
+		ok, err := revokeExpiredDatabaseLease(lease.ID, now, phrase)
+		if err != nil {
+			logD.Error("Revoking lease %s: %v", lease.ID, err)
+			last = err
+			continue
+		}
+
+		if ok {
+			revoked++
+		}
+	}
+
+	return revoked, last
+}
+
+// DatabaseLeaseRole returns the role of a database lease id
+func DatabaseLeaseRole(id string) (string, bool) {
+
+	role, _, ok := splitDatabaseLease(id)
+	return role, ok
+}
+
+// revokeExpiredDatabaseLease drops the user of the lease once its connection is locked, as long as the lease is still expired
+func revokeExpiredDatabaseLease(id string, now int64, phrase string) (bool, error) {
+
+	lease, unlock, err := lockDatabaseLease(id, phrase)
+	if err == ErrDatabaseLeaseNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// renewed in the meantime
+	if lease.ExpiresAt > now {
+		return false, nil
+	}
+
+	return true, revokeDatabaseLease(lease, phrase)
+}
+
+// lockDatabase locks the leases of the connection returning the function unlocking them
+func lockDatabase(name string) func() {
+
+	databaseLocksMu.Lock()
+	lock, ok := databaseLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		databaseLocks[name] = lock
+	}
+	databaseLocksMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// lockDatabaseLease locks the connection of the lease and reads the lease again, it may be gone by the time the lock is held
+func lockDatabaseLease(id, phrase string) (*model.DatabaseLease, func(), error) {
+
+	lease, err := readDatabaseLease(id, phrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock := lockDatabase(lease.Database)
+
+	lease, err = readDatabaseLease(id, phrase)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	return lease, unlock, nil
+}
+
+// revokeDatabaseLease drops the user and forgets the lease, the caller holds the lock of its connection
+func revokeDatabaseLease(lease *model.DatabaseLease, phrase string) error {
+
+	err := dropDatabaseUser(lease, phrase)
+	if err != nil {
+		return err
+	}
+
+	_, name, _ := splitDatabaseLease(lease.ID)
+
+	sys := fs.NewFileSystem(dataDatabase)
+	err = sys.DeleteEntry(kindDatabaseLease, name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// renewDatabaseUser runs the renew statements of the lease up to its expiry, the postgres defaults when it has none
+func renewDatabaseUser(lease *model.DatabaseLease, phrase string) error {
+
+	config, err := ReadDatabaseConfig(lease.Database, phrase)
+	if err != nil {
+		return err
+	}
+
+	statements := lease.RenewStatements
+	if len(statements) == 0 {
+		statements = postgresRenewal
+	}
+
+	return execute(config, statements, map[string]string{"name": lease.Username, "expiration": expiration(lease.ExpiresAt)})
+}
+
+// dropDatabaseUser runs the revocation statements of the lease, the postgres defaults when it has none
+func dropDatabaseUser(lease *model.DatabaseLease, phrase string) error {
+
+	config, err := ReadDatabaseConfig(lease.Database, phrase)
+	if err != nil {
+		return err
+	}
+
+	statements := lease.RevocationStatements
+	if len(statements) == 0 {
+		statements = postgresRevocation
+	}
+
+	return execute(config, statements, map[string]string{"name": lease.Username})
+}
+
+// execute connects to the database running the statements
+func execute(config *model.DatabaseConfig, statements []string, values map[string]string) error {
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := db.Close(); cerr != nil {
+			logD.Warn("Closing %s: %v", config.Name, cerr)
+		}
+	}()
+
+	err = db.Execute(statements, values)
+	if err != nil {
+		logD.Error("Database %s: %v", config.Name, err)
+		return ErrDatabaseUnreachable
+	}
+
+	return nil
+}
+
+// openDatabase connects to the database hiding the cause, which may carry the connection url, behind ErrDatabaseUnreachable
+func openDatabase(config *model.DatabaseConfig) (Database, error) {
+
+	db, err := NewDatabase(config)
+	if err != nil {
+		logD.Error("Database %s: %v", config.Name, err)
+		return nil, ErrDatabaseUnreachable
+	}
+
+	return db, nil
+}
+
+func readDatabaseLease(id, phrase string) (*model.DatabaseLease, error) {
+
+	_, name, ok := splitDatabaseLease(id)
+	if !ok {
+		return nil, ErrDatabaseLeaseNotFound
+	}
+
+	lease := &model.DatabaseLease{}
+	err := readDatabaseEntry(kindDatabaseLease, name, phrase, lease, ErrDatabaseLeaseNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	if lease.ID != id {
+		return nil, ErrDatabaseLeaseNotFound
+	}
+
+	return lease, nil
+}
+
+func writeDatabaseLease(lease *model.DatabaseLease, phrase string) error {
+
+	_, name, _ := splitDatabaseLease(lease.ID)
+
+	data, err := encryptJSON(lease, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataDatabase)
+	return sys.MakeEntry(kindDatabaseLease, name, data)
+}
+
+// readDatabaseEntry reads and decrypts the entry into the value, a missing or badly named entry is notFound
+func readDatabaseEntry(kind, name, phrase string, v interface{}, notFound error) error {
+
+	sys := fs.NewFileSystem(dataDatabase)
+
+	data, err := sys.ReadEntry(kind, name)
+	if os.IsNotExist(err) || err == fs.ErrBadInput {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return decryptJSON(data, phrase, v)
+}
+
+// splitDatabaseLease returns the role and the id of a database lease id, database/<role>/<id>
+func splitDatabaseLease(id string) (string, string, bool) {
+
+	if !strings.HasPrefix(id, DatabaseLeasePrefix) {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(id, DatabaseLeasePrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// roleTTLs parses the default and max ttl of the role falling back to DatabaseTTL and DatabaseMaxTTL
+func roleTTLs(role *model.DatabaseRole) (time.Duration, time.Duration, error) {
+
+	ttl, max := DatabaseTTL, DatabaseMaxTTL
+	var err error
+
+	if role.DefaultTTL != "" {
+		ttl, err = time.ParseDuration(role.DefaultTTL)
+		if err != nil || ttl <= 0 {
+			return 0, 0, ErrBadDatabaseRole
+		}
+	}
+
+	if role.MaxTTL != "" {
+		max, err = time.ParseDuration(role.MaxTTL)
+		if err != nil || max <= 0 {
+			return 0, 0, ErrBadDatabaseRole
+		}
+	}
+
+	return ttl, max, nil
+}
+
+func databaseLeaseInfo(lease *model.DatabaseLease) *model.Lease {
+	return &model.Lease{ID: lease.ID, TTL: lease.ExpiresAt - time.Now().Unix(), ExpiresAt: lease.ExpiresAt}
+}
+
+// databaseUsername names the user after its role, within the 63 characters PostgreSQL keeps
+func databaseUsername(role, suffix string) string {
+
+	name := databaseUserPrefix + role + "-" + suffix
+	if len(name) <= databaseUserMaxLen {
+		return name
+	}
+
+	keep := databaseUserMaxLen - len(databaseUserPrefix) - len(suffix) - 1
+	return databaseUserPrefix + role[:keep] + "-" + suffix
+}
+
+// expiration formats the unix time the way PostgreSQL reads a timestamp with time zone
+func expiration(at int64) string {
+	return time.Unix(at, 0).UTC().Format("2006-01-02 15:04:05-07")
+}
+
+// connectionURL fills the credentials of the admin user into the connection url
+func connectionURL(config *model.DatabaseConfig) string {
+
+	return strings.NewReplacer(
+		"{{username}}", url.User(config.Username).String(),
+		"{{password}}", strings.TrimPrefix(url.UserPassword("", config.Password).String(), ":"),
+	).Replace(config.ConnectionURL)
+}
+
+// expand replaces every {{key}} of the statement by its value
+func expand(statement string, values map[string]string) string {
+
+	pairs := []string{}
+	for key, value := range values {
+		pairs = append(pairs, "{{"+key+"}}", value)
+	}
+
+	return strings.NewReplacer(pairs...).Replace(statement)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrEd25519Verification is returned when the EdDSA signature does not match
+var ErrEd25519Verification = errors.New("jwt: ed25519 verification error")
+
+// SigningMethodEd25519 implements the EdDSA signing method over ed25519 keys, which jwt-go lacks
+type SigningMethodEd25519 struct{}
+
+// SigningMethodEdDSA is the registered instance of the EdDSA signing method
+var SigningMethodEdDSA = &SigningMethodEd25519{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// Alg returns the name of the signing method as found in the alg header
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the signature of the signing string with an ed25519.PublicKey
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok || len(pub) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return ErrEd25519Verification
+	}
+
+	return nil
+}
+
+// Sign signs the signing string with an ed25519.PrivateKey
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok || len(private) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
@@ -1,94 +1,185 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/NeowayLabs/logger"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/algo"
 	"github.com/ffhenkes/kripto/model"
 )
 
 var logJ = logger.Namespace("kripto.jwt")
 
+// ErrInvalidToken is returned whenever the token does not pass the validation
+var ErrInvalidToken = errors.New("jwt: non valid token")
+
 const (
-	dataRsa    = "/data/rsa"
-	keyName    = "kripto"
-	signMethod = "RS256"
+	dataRsa     = "/data/rsa"
+	keyName     = "kripto"
+	tokenIDSize = 16
 )
 
 type (
 	// JwtAuth represent the json authorization token type
 	JwtAuth struct {
-		c *model.Credentials
+		c        *model.Credentials
+		method   string
+		roles    []string
+		policies []string
 	}
 )
 
 // NewJwtAuth returns a reference for JwtAuth type and embed Credentials
 func NewJwtAuth(c *model.Credentials) *JwtAuth {
-	return &JwtAuth{c}
+	return &JwtAuth{c: c}
+}
+
+// NewMachineJwtAuth returns a JwtAuth issuing tokens that name their auth method and carry their policies
+func NewMachineJwtAuth(c *model.Credentials, method string, policies []string) *JwtAuth {
+	return &JwtAuth{c: c, method: method, policies: policies}
+}
+
+// NewFederatedJwtAuth returns a JwtAuth issuing tokens that name their auth method and carry their roles
+func NewFederatedJwtAuth(c *model.Credentials, method string, roles []string) *JwtAuth {
+	return &JwtAuth{c: c, method: method, roles: roles}
 }
 
 // GenerateToken uses Credentials to generate a new Jwt (json authorization token)
 func (jwta *JwtAuth) GenerateToken() (string, error) {
 
-	sys := fs.NewFileSystem(dataRsa)
+	tokenString, _, err := jwta.IssueToken()
+	return tokenString, err
+}
+
+// IssueToken uses Credentials to generate a new Jwt returning it along with its claims
+// The token is minted for kripto itself unless the Credentials ask for another known audience
+func (jwta *JwtAuth) IssueToken() (string, *model.CustomClaims, error) {
 
-	privateKey, err := sys.ReadKey(keyName)
-	if err != nil {
-		return "", err
+	audience := jwta.c.Audience
+	if audience == "" {
+		audience = Audience
 	}
 
-	token := jwt.New(jwt.GetSigningMethod(signMethod))
-	token.Claims = &model.CustomClaims{
-		&jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(jwta.c.TokenExpiresIn).Unix(),
-		},
-		jwta.c.Username,
+	if !KnownAudience(audience) {
+		return "", nil, ErrUnknownAudience
+	}
+
+	active, signKey, err := manager.signer()
+	if err == ErrKeyNotFound {
+		_, err = EnsureKey()
+		if err != nil {
+			return "", nil, err
+		}
+		active, signKey, err = manager.signer()
+	}
+	if err != nil {
+		return "", nil, err
 	}
 
-	signKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	jti, err := algo.RandomHex(tokenIDSize)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	now := time.Now()
+
+	claims := &model.CustomClaims{
+		StandardClaims: &jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    Issuer,
+			Audience:  audience,
+			Subject:   jwta.c.Username,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(jwta.c.TokenExpiresIn).Unix(),
+		},
+		Username:     jwta.c.Username,
+		Method:       jwta.method,
+		Roles:        jwta.roles,
+		Policies:     jwta.policies,
+		IssuedAtNano: now.UnixNano(),
 	}
 
+	token := jwt.New(jwt.GetSigningMethod(active.Algorithm))
+	token.Claims = claims
+	token.Header["kid"] = active.ID
+
 	tokenString, err := token.SignedString(signKey)
 	if err != nil {
-		return "", nil
+		return "", nil, err
 	}
 
-	return tokenString, nil
+	return tokenString, claims, nil
+}
+
+// ValidateToken strictly checks the integrity of a token minted for kripto itself and returns its claims
+// Any parse failure, bad signing method or non valid token results in an error
+func ValidateToken(tokenString string) (*model.CustomClaims, error) {
+	return ValidateTokenFor(tokenString, Audience)
 }
 
-// ValidateToken checks the token integrity
-func ValidateToken(authorization string) (bool, error) {
+// ValidateTokenFor strictly checks the integrity of a token minted for the audience and returns its claims
+func ValidateTokenFor(tokenString, audience string) (*model.CustomClaims, error) {
 
-	sys := fs.NewFileSystem(dataRsa)
+	// the standard claims are checked by validateClaims allowing the clock skew
+	parser := &jwt.Parser{SkipClaimsValidation: true}
 
-	pub, err := sys.ReadPublicKey(keyName)
+	claims := &model.CustomClaims{StandardClaims: &jwt.StandardClaims{}}
+	token, err := parser.ParseWithClaims(strings.TrimSpace(tokenString), claims, keyFunc)
 	if err != nil {
-		return false, err
+		logJ.Warn("User: %s Non valid token! %v", claims.Username, err)
+		return nil, err
+	}
+
+	if !token.Valid || claims.Username == "" {
+		logJ.Warn("User: %s Non valid token!", claims.Username)
+		return nil, ErrInvalidToken
 	}
 
-	verifyKey, err := jwt.ParseRSAPublicKeyFromPEM(pub)
+	err = validateClaims(claims, audience)
 	if err != nil {
-		return false, err
+		logJ.Warn("User: %s Non valid token! %v", claims.Username, err)
+		return nil, err
 	}
 
-	tokenString := strings.TrimSpace(authorization)
-	token, err := jwt.ParseWithClaims(tokenString, &model.CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
+	revoked, err := IsRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
 
-	expiresAt := time.Unix(token.Claims.(*model.CustomClaims).StandardClaims.ExpiresAt, 0)
-	username := token.Claims.(*model.CustomClaims).Username
+	if revoked {
+		logJ.Warn("User: %s Revoked token! Id: %s", claims.Username, claims.Id)
+		return nil, ErrTokenRevoked
+	}
 
+	logJ.Info("User: %s Token Expires At: %v", claims.Username, time.Unix(claims.ExpiresAt, 0))
+	return claims, nil
+}
+
+// keyFunc resolves the verifying key from the kid header, tokens without it were signed by the original kripto key
+func keyFunc(token *jwt.Token) (interface{}, error) {
+
+	kid := keyName
+	if v, ok := token.Header["kid"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+		kid = s
+	}
+
+	k, verifyKey, err := manager.verifier(kid)
 	if err != nil {
-		logJ.Warn("User: %s Non valid token! Expires At: %v", username, expiresAt)
-		return token.Valid, err
+		return nil, err
+	}
+
+	if token.Method.Alg() != k.Algorithm {
+		return nil, fmt.Errorf("jwt: unexpected signing method %v", token.Header["alg"])
 	}
 
-	logJ.Info("User: %s Token Expires At: %v", username, expiresAt)
-	return token.Valid, nil
+	return verifyKey, nil
 }
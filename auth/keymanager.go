@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+type (
+	// keyManager keeps the keyring and the parsed keys in memory so tokens are signed and verified without touching the disk
+	keyManager struct {
+		mu        sync.RWMutex
+		loaded    bool
+		active    *model.SigningKey
+		signing   interface{}
+		keys      map[string]*model.SigningKey
+		verifying map[string]interface{}
+	}
+)
+
+var manager = &keyManager{}
+
+// ReloadKeys drops the cached keys so the next token signed or verified loads them again from disk
+// Kserver calls it on SIGHUP and whenever the keys directory changes
+func ReloadKeys() {
+	manager.reset()
+}
+
+// WatchKeys reloads the keys whenever the keys directory changes, checking it on every interval
+// It blocks, so it is meant to run on its own goroutine
+func WatchKeys(interval time.Duration) {
+
+	sys := fs.NewFileSystem(dataRsa)
+	last, _ := sys.LastModified()
+
+	for range time.Tick(interval) {
+
+		modified, err := sys.LastModified()
+		if err != nil || !modified.After(last) {
+			continue
+		}
+
+		last = modified
+		logJ.Info("Keys directory changed! Reloading signing keys")
+		manager.reset()
+	}
+}
+
+// signer returns the active key along with its parsed private key
+func (m *keyManager) signer() (*model.SigningKey, interface{}, error) {
+
+	err := m.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.active == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+
+	return m.active, m.signing, nil
+}
+
+// verifier returns the key with the given id along with its parsed public key
+func (m *keyManager) verifier(kid string) (*model.SigningKey, interface{}, error) {
+
+	err := m.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.keys[kid]
+	if !ok {
+		return nil, nil, ErrKeyNotFound
+	}
+
+	return k, m.verifying[kid], nil
+}
+
+// load reads and parses every key once, until the next reset
+func (m *keyManager) load() error {
+
+	m.mu.RLock()
+	loaded := m.loaded
+	m.mu.RUnlock()
+
+	if loaded {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.loaded {
+		return nil
+	}
+
+	ring, err := ReadKeyring()
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*model.SigningKey{}
+	verifying := map[string]interface{}{}
+
+	var active *model.SigningKey
+	var signing interface{}
+
+	for _, k := range ring {
+
+		pub, err := verifyingKey(k)
+		if err != nil {
+			return err
+		}
+
+		keys[k.ID] = k
+		verifying[k.ID] = pub
+
+		if k.Status != model.KeyActive {
+			continue
+		}
+
+		signing, err = signingKey(k)
+		if err != nil {
+			return err
+		}
+
+		active = k
+	}
+
+	m.active, m.signing, m.keys, m.verifying = active, signing, keys, verifying
+	m.loaded = true
+
+	return nil
+}
+
+func (m *keyManager) reset() {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loaded = false
+	m.active, m.signing, m.keys, m.verifying = nil, nil, nil, nil
+}
@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	kindKeyring = "keyring"
+	kindPrivate = "key"
+	kindPublic  = "pub"
+	keyringName = "keys"
+	keyIDSize   = 8
+
+	// AlgorithmRS256 signs tokens with rsa keys
+	AlgorithmRS256 = "RS256"
+	// AlgorithmES256 signs tokens with P-256 ecdsa keys
+	AlgorithmES256 = "ES256"
+	// AlgorithmEdDSA signs tokens with ed25519 keys
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	// SigningAlgorithm is the algorithm of the keys generated when none is asked for
+	SigningAlgorithm = AlgorithmEdDSA
+
+	// ErrKeyNotFound is returned when the key id is not part of the keyring
+	ErrKeyNotFound = errors.New("keys: not found")
+	// ErrActiveKey is returned when trying to remove the key that signs new tokens
+	ErrActiveKey = errors.New("keys: the active key can not be removed")
+	// ErrUnsupportedAlgorithm is returned when asking for a key of an unknown algorithm
+	ErrUnsupportedAlgorithm = errors.New("keys: unsupported algorithm")
+)
+
+// keyring serializes every change to the keyring
+var keyring sync.Mutex
+
+// ReadKeyring returns the metadata of every signing key
+// Before the first rotation the keyring holds only the original kripto rsa key, if there is one
+func ReadKeyring() ([]*model.SigningKey, error) {
+
+	sys := fs.NewFileSystem(dataRsa)
+
+	data, err := sys.ReadEntry(kindKeyring, keyringName)
+	if os.IsNotExist(err) {
+		return legacyKeyring(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*model.SigningKey{}
+	err = json.Unmarshal(data, &keys)
+	return keys, err
+}
+
+// ActiveKey returns the metadata of the key signing new tokens
+func ActiveKey() (*model.SigningKey, error) {
+
+	keys, err := ReadKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if k.Status == model.KeyActive {
+			return k, nil
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// EnsureKey returns the active key generating one with the default algorithm when there is none yet
+func EnsureKey() (*model.SigningKey, error) {
+
+	active, err := ActiveKey()
+	if err != ErrKeyNotFound {
+		return active, err
+	}
+
+	logJ.Info("No signing key found! Generating a new %s key", SigningAlgorithm)
+	return RotateKey("")
+}
+
+// RotateKey generates a new signing key making it the active one, the former active key starts retiring
+// An empty algorithm generates a key of the default SigningAlgorithm
+func RotateKey(algorithm string) (*model.SigningKey, error) {
+
+	if algorithm == "" {
+		algorithm = SigningAlgorithm
+	}
+
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	keys, err := ReadKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := algo.RandomHex(keyIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	private, public, err := generateKeyPair(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	sys := fs.NewFileSystem(dataRsa)
+
+	err = sys.MakeEntry(kindPrivate, kid, private)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sys.MakeEntry(kindPublic, kid, public)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+
+	for _, k := range keys {
+		if k.Status == model.KeyActive {
+			k.Status = model.KeyRetiring
+			k.RetiredAt = now
+		}
+	}
+
+	active := &model.SigningKey{
+		ID:        kid,
+		Algorithm: algorithm,
+		Status:    model.KeyActive,
+		CreatedAt: now,
+	}
+
+	err = writeKeyring(append(keys, active))
+	if err != nil {
+		return nil, err
+	}
+
+	manager.reset()
+	return active, nil
+}
+
+// RemoveKey drops a retiring key, every token it signed is no longer valid
+func RemoveKey(kid string) error {
+
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	keys, err := ReadKeyring()
+	if err != nil {
+		return err
+	}
+
+	kept := []*model.SigningKey{}
+	found := false
+
+	for _, k := range keys {
+
+		if k.ID != kid {
+			kept = append(kept, k)
+			continue
+		}
+
+		if k.Status == model.KeyActive {
+			return ErrActiveKey
+		}
+
+		found = true
+	}
+
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	err = writeKeyring(kept)
+	if err != nil {
+		return err
+	}
+
+	manager.reset()
+	return dropKeyFiles(kid)
+}
+
+// PruneKeys drops every key retiring for longer than the retention and returns how many were dropped
+func PruneKeys(retention time.Duration) (int, error) {
+
+	keys, err := ReadKeyring()
+	if err != nil {
+		return 0, err
+	}
+
+	limit := time.Now().Add(-retention).Unix()
+	pruned := 0
+
+	for _, k := range keys {
+
+		if k.Status != model.KeyRetiring || k.RetiredAt > limit {
+			continue
+		}
+
+		err = RemoveKey(k.ID)
+		if err != nil {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// JSONWebKeySet returns the public part of every active and retiring key
+func JSONWebKeySet() (*model.JWKS, error) {
+
+	keys, err := ReadKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	set := &model.JWKS{Keys: []model.JWK{}}
+
+	for _, k := range keys {
+
+		pub, err := verifyingKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		jwk := model.JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+
+		switch key := pub.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeSegment(key.N.Bytes())
+			jwk.E = encodeSegment(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = key.Curve.Params().Name
+			jwk.X = encodeSegment(padded(key.X.Bytes(), size))
+			jwk.Y = encodeSegment(padded(key.Y.Bytes(), size))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = encodeSegment(key)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// signingKey reads and parses the private key
+func signingKey(k *model.SigningKey) (interface{}, error) {
+
+	sys := fs.NewFileSystem(dataRsa)
+
+	var private []byte
+	var err error
+
+	if k.ID == keyName {
+		private, err = sys.ReadKey(keyName)
+	} else {
+		private, err = sys.ReadEntry(kindPrivate, k.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return algo.NewAsymmetrical().ParsePrivateKey(private)
+}
+
+// verifyingKey reads and parses the public key
+func verifyingKey(k *model.SigningKey) (interface{}, error) {
+
+	sys := fs.NewFileSystem(dataRsa)
+
+	var public []byte
+	var err error
+
+	if k.ID == keyName {
+		public, err = sys.ReadPublicKey(keyName)
+	} else {
+		public, err = sys.ReadEntry(kindPublic, k.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return algo.NewAsymmetrical().ParsePublicKey(public)
+}
+
+func writeKeyring(keys []*model.SigningKey) error {
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataRsa)
+	return sys.MakeEntry(kindKeyring, keyringName, data)
+}
+
+// dropKeyFiles removes the key pair files, the original kripto key files are left in place
+func dropKeyFiles(kid string) error {
+
+	if kid == keyName {
+		return nil
+	}
+
+	sys := fs.NewFileSystem(dataRsa)
+
+	for _, kind := range []string{kindPrivate, kindPublic} {
+		err := sys.DeleteEntry(kind, kid)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyKeyring holds the original kripto rsa key when its files are still in place
+func legacyKeyring() []*model.SigningKey {
+
+	sys := fs.NewFileSystem(dataRsa)
+
+	_, err := sys.ReadPublicKey(keyName)
+	if err != nil {
+		return []*model.SigningKey{}
+	}
+
+	return []*model.SigningKey{{ID: keyName, Algorithm: AlgorithmRS256, Status: model.KeyActive}}
+}
+
+// generateKeyPair creates a pem encoded key pair for the algorithm
+func generateKeyPair(algorithm string) ([]byte, []byte, error) {
+
+	a := algo.NewAsymmetrical()
+
+	switch algorithm {
+	case AlgorithmRS256:
+		return a.GenerateRSA(algo.RSAKeySize)
+	case AlgorithmES256:
+		return a.GenerateECDSA()
+	case AlgorithmEdDSA:
+		return a.GenerateEd25519()
+	default:
+		return nil, nil, ErrUnsupportedAlgorithm
+	}
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padded left pads the curve coordinate to the curve size as the jwk format requires
+func padded(b []byte, size int) []byte {
+
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	kindLDAP       = "ldap"
+	ldapConfigName = "config"
+	ldapPrefix     = "ldap-"
+
+	defaultUserFilter     = "(uid=%s)"
+	defaultGroupFilter    = "(member=%s)"
+	defaultGroupAttribute = "cn"
+)
+
+var (
+	// ErrLDAPNotConfigured is returned when no directory was configured
+	ErrLDAPNotConfigured = errors.New("ldap: not configured")
+	// ErrBadLDAPConfig is returned when the configuration lacks the url or the user base dn
+	ErrBadLDAPConfig = errors.New("ldap: url and user base dn required")
+	// ErrBadLDAPCA is returned when the directory CA certificate can not be parsed
+	ErrBadLDAPCA = errors.New("ldap: no certificate found in the CA certificate")
+	// ErrDirectoryCredentials is returned when the directory does not know the user or refuses the password
+	ErrDirectoryCredentials = errors.New("ldap: bad credentials")
+)
+
+type (
+	// Directory authenticates users against a user directory returning the groups they belong to
+	Directory interface {
+		Authenticate(username, password string) ([]string, error)
+	}
+
+	// ldapDirectory is the Directory backed by an ldap server
+	ldapDirectory struct {
+		config *model.LDAPConfig
+	}
+)
+
+// NewDirectory returns the Directory for the configuration, tests swap it for an in-process stand-in
+var NewDirectory = func(config *model.LDAPConfig) Directory {
+	return &ldapDirectory{config: config}
+}
+
+// LDAPPrincipal returns the principal name of the users logged in through the directory
+func LDAPPrincipal(username string) string {
+	return ldapPrefix + username
+}
+
+// WriteLDAPConfig validates and encrypts the directory configuration storing it into the authdb
+// An empty bind password keeps the one already stored
+func WriteLDAPConfig(config *model.LDAPConfig, phrase string) error {
+
+	if config.URL == "" || config.UserBaseDN == "" {
+		return ErrBadLDAPConfig
+	}
+
+	if config.UserFilter == "" {
+		config.UserFilter = defaultUserFilter
+	}
+
+	if config.GroupFilter == "" {
+		config.GroupFilter = defaultGroupFilter
+	}
+
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = defaultGroupAttribute
+	}
+
+	if config.BindPassword == "" {
+		current, err := ReadLDAPConfig(phrase)
+		if err != nil && err != ErrLDAPNotConfigured {
+			return err
+		}
+		if current != nil {
+			config.BindPassword = current.BindPassword
+		}
+	}
+
+	data, err := encryptJSON(config, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+	return sys.MakeEntry(kindLDAP, ldapConfigName, data)
+}
+
+// ReadLDAPConfig retrieves the directory configuration from the authdb and decrypts it
+func ReadLDAPConfig(phrase string) (*model.LDAPConfig, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadEntry(kindLDAP, ldapConfigName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrLDAPNotConfigured
+		}
+		return nil, err
+	}
+
+	config := &model.LDAPConfig{}
+	err = decryptJSON(data, phrase, config)
+	return config, err
+}
+
+// LoginLDAP authenticates the user against the directory and issues a token carrying the roles mapped to its groups
+func LoginLDAP(username, password, phrase string) (*model.TokenResponse, error) {
+
+	// an empty password is an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, ErrDirectoryCredentials
+	}
+
+	config, err := ReadLDAPConfig(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := NewDirectory(config).Authenticate(username, password)
+	if err != nil {
+		logL.Warn("LDAP: User: %s Non valid credentials! %v", username, err)
+		return nil, err
+	}
+
+	roles := groupRoles(config.GroupRoles, config.DefaultRoles, groups)
+	if len(roles) == 0 {
+		logL.Warn("LDAP: User: %s has no role mapped!", username)
+		return nil, ErrNoRoles
+	}
+
+	jwta := NewFederatedJwtAuth(&model.Credentials{
+		Username:       LDAPPrincipal(username),
+		TokenExpiresIn: AccessTokenTTL,
+	}, model.MethodLDAP, roles)
+
+	tokenString, claims, err := jwta.IssueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		Token:     tokenString,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// Authenticate searches the user with the service account, binds as the user and collects its groups
+func (d *ldapDirectory) Authenticate(username, password string) ([]string, error) {
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	err = d.serviceBind(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(d.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", "memberOf"}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, ErrDirectoryCredentials
+	}
+
+	user := result.Entries[0]
+
+	err = conn.Bind(user.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrDirectoryCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if d.config.GroupBaseDN == "" {
+		return memberOf(user.GetAttributeValues("memberOf")), nil
+	}
+
+	// groups are searched with the service account again
+	err = d.serviceBind(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err = conn.Search(ldap.NewSearchRequest(
+		d.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(d.config.GroupFilter, ldap.EscapeFilter(user.DN)),
+		[]string{d.config.GroupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.GetAttributeValues(d.config.GroupAttribute)...)
+	}
+
+	return groups, nil
+}
+
+// dial connects to the directory upgrading the connection with StartTLS when configured
+func (d *ldapDirectory) dial() (*ldap.Conn, error) {
+
+	tlsConfig, err := d.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	if d.config.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (d *ldapDirectory) serviceBind(conn *ldap.Conn) error {
+
+	if d.config.BindDN == "" {
+		return nil
+	}
+
+	return conn.Bind(d.config.BindDN, d.config.BindPassword)
+}
+
+// tlsConfig trusts the configured CA certificate on top of the system ones
+func (d *ldapDirectory) tlsConfig() (*tls.Config, error) {
+
+	u, err := url.Parse(d.config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+
+	if d.config.CACert != "" {
+		pool, perr := x509.SystemCertPool()
+		if perr != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM([]byte(d.config.CACert)) {
+			return nil, ErrBadLDAPCA
+		}
+
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// memberOf returns the first rdn value, usually the cn, of every group dn
+func memberOf(dns []string) []string {
+
+	groups := []string{}
+	for _, dn := range dns {
+
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+			continue
+		}
+
+		groups = append(groups, parsed.RDNs[0].Attributes[0].Value)
+	}
+
+	return groups
+}
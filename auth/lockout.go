@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ffhenkes/kripto/model"
+)
+
+var (
+	// LockoutThreshold is the number of failed logins in a row locking the user
+	LockoutThreshold = 5
+	// LockoutDuration is how long a locked user is refused even with the right password
+	LockoutDuration = 15 * time.Minute
+
+	// ErrUserLocked is returned when a locked user tries to login
+	ErrUserLocked = errors.New("user: locked")
+)
+
+// LockedFor returns how long the user is still locked, zero when it is not or has no record
+func LockedFor(username, phrase string) (time.Duration, error) {
+
+	u, err := readUser(username, phrase)
+	if err == ErrUserNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return remaining(u.LockedUntil), nil
+}
+
+// FailLogin counts a failed login of the user locking it once LockoutThreshold is reached
+// It returns how long the user got locked, zero while it is not
+func FailLogin(username, phrase string) (time.Duration, error) {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err == ErrUserNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	u.FailedLogins++
+
+	if u.FailedLogins >= LockoutThreshold {
+		logL.Warn("User: %s locked after %d failed logins!", username, u.FailedLogins)
+		u.FailedLogins = 0
+		u.LockedUntil = time.Now().Add(LockoutDuration).Unix()
+	}
+
+	err = WriteUser(u, phrase)
+	if err != nil {
+		return 0, err
+	}
+
+	return remaining(u.LockedUntil), nil
+}
+
+// SucceedLogin forgets the failed logins of the user
+func SucceedLogin(username, phrase string) error {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return err
+	}
+
+	if u.FailedLogins == 0 {
+		return nil
+	}
+
+	u.FailedLogins = 0
+	return WriteUser(u, phrase)
+}
+
+// UnlockUser lifts the lock of the user and forgets its failed logins
+func UnlockUser(username, phrase string) (*model.User, error) {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	u.FailedLogins = 0
+	u.LockedUntil = 0
+
+	return u, WriteUser(u, phrase)
+}
+
+func remaining(until int64) time.Duration {
+
+	left := time.Until(time.Unix(until, 0))
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
@@ -2,12 +2,8 @@ package auth
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
-	"time"
 
 	"github.com/ffhenkes/kripto/algo"
-	"github.com/ffhenkes/kripto/fs"
 	"github.com/ffhenkes/kripto/model"
 )
 
@@ -29,63 +25,51 @@ func NewLogin(c *model.Credentials) *Login {
 
 // AddCredentials creates a new user file on the disk containing user and password data encrypted using the kripto built in passphrase
 func (l *Login) AddCredentials(phrase string) error {
-
-	passwd := l.HashPassword()
-	userString := fmt.Sprintf("%s@%s@%d", l.Credentials.Username, passwd, l.Credentials.TokenExpiresIn)
-
-	symmetrical := algo.NewSymmetrical()
-	data, err := symmetrical.Encrypt([]byte(userString), phrase)
-	if err != nil {
-		return err
-	}
-
-	sys := fs.NewFileSystem(dataAuthdb)
-	err = sys.MakeAuth(l.Credentials.Username, data)
-	return err
+	return WriteUser(l.User(), phrase)
 }
 
 // CheckCredentials retrieve the user data from file, decrypt it and returns a boolean sign
+// Disabled users are never allowed to login
 func (l *Login) CheckCredentials(phrase string) (bool, error) {
 
-	sys := fs.NewFileSystem(dataAuthdb)
-
-	data, err := sys.ReadAuth(l.Credentials.Username)
+	u, err := ReadUser(l.Credentials.Username, phrase)
 	if err != nil {
 		return false, err
 	}
 
-	var b []byte
-	if len(data) == 0 {
+	if u == nil {
 		return false, nil
 	}
 
-	symmetrical := algo.NewSymmetrical()
-
-	b, err = symmetrical.Decrypt(data, phrase)
-	if err != nil {
-		return false, err
-	}
-
-	output := strings.Split(string(b), "@")
-	username := output[0]
-	passwd := output[1]
-	hashedPasswd := l.HashPassword()
+	l.Credentials.TokenExpiresIn = u.TokenExpiresIn
 
-	t, err := strconv.Atoi(output[2])
-	if err != nil {
-		return false, err
+	if u.Disabled {
+		logL.Warn("User: %s is disabled!", u.Username)
+		return false, nil
 	}
 
-	l.Credentials.TokenExpiresIn = time.Duration(t)
-
-	if username == l.Credentials.Username && passwd == hashedPasswd {
+	if u.Username == l.Credentials.Username && u.Password == l.HashPassword() {
 		return true, nil
 	}
 
 	return false, nil
 }
 
+// User builds a new user record from the Credentials with the password already hashed
+func (l *Login) User() *model.User {
+	return &model.User{
+		Username:       l.Credentials.Username,
+		Password:       l.HashPassword(),
+		TokenExpiresIn: l.Credentials.TokenExpiresIn,
+	}
+}
+
 // HashPassword create a string hash using sha256 built within the MakeSimpleHash algorithm
 func (l *Login) HashPassword() string {
-	return fmt.Sprintf("%x", algo.MakeSimpleHash(l.Credentials.Password))
+	return HashPassword(l.Credentials.Password)
+}
+
+// HashPassword create a string hash using sha256 built within the MakeSimpleHash algorithm
+func HashPassword(password string) string {
+	return fmt.Sprintf("%x", algo.MakeSimpleHash(password))
 }
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	recoveryCodes    = 10
+	recoveryCodeSize = 5
+)
+
+var (
+	// MFAIssuer names kripto in the authenticator apps
+	MFAIssuer = "kripto"
+	// MFASkew is the number of time steps accepted around the current one to allow clock drift
+	MFASkew = 1
+
+	// ErrMFARequired is returned when an enrolled user logs in without a code
+	ErrMFARequired = errors.New("mfa: code required")
+	// ErrInvalidMFACode is returned when the code is neither the current totp code nor an unused recovery code
+	ErrInvalidMFACode = errors.New("mfa: non valid code")
+	// ErrMFAEnabled is returned when enrolling a user whose enrollment is already confirmed
+	ErrMFAEnabled = errors.New("mfa: already enabled")
+	// ErrMFANotEnrolled is returned when confirming or dropping the enrollment of a user without one
+	ErrMFANotEnrolled = errors.New("mfa: not enrolled")
+)
+
+// records serializes every change to user records, so a totp step or a recovery code is never accepted twice
+// and a login never writes back a stale copy of a record an admin just changed or removed
+var records sync.Mutex
+
+// EnrollTOTP generates a new totp secret and recovery codes for the user, replacing any unconfirmed enrollment
+// The codes are only asked for on login once the enrollment is confirmed with ConfirmTOTP
+func EnrollTOTP(username, phrase string) (*model.TOTPEnrollment, error) {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.TOTPEnabled {
+		return nil, ErrMFAEnabled
+	}
+
+	secret, err := algo.GenerateTOTPSecret(algo.TOTPSecretSize)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	hashes := []string{}
+
+	for i := 0; i < recoveryCodes; i++ {
+
+		code, err := algo.RandomHex(recoveryCodeSize)
+		if err != nil {
+			return nil, err
+		}
+
+		code = code[:recoveryCodeSize] + "-" + code[recoveryCodeSize:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashPassword(code))
+	}
+
+	u.TOTPSecret = secret
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = hashes
+
+	err = WriteUser(u, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TOTPEnrollment{
+		Secret:        secret,
+		URI:           algo.NewTOTP(secret).URI(MFAIssuer, username),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// ConfirmTOTP enables the enrollment once the user proves the authenticator app holds the secret
+func ConfirmTOTP(username, code, phrase string) error {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return err
+	}
+
+	if u.TOTPSecret == "" {
+		return ErrMFANotEnrolled
+	}
+
+	if u.TOTPEnabled {
+		return ErrMFAEnabled
+	}
+
+	step, ok, err := algo.NewTOTP(u.TOTPSecret).Validate(code, time.Now(), MFASkew)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	u.TOTPEnabled = true
+	u.TOTPLastStep = step
+
+	return WriteUser(u, phrase)
+}
+
+// DisableTOTP drops the totp secret and the recovery codes of the user
+func DisableTOTP(username, phrase string) error {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return err
+	}
+
+	if u.TOTPSecret == "" {
+		return ErrMFANotEnrolled
+	}
+
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = nil
+
+	return WriteUser(u, phrase)
+}
+
+// VerifyMFA checks the second factor of an enrolled user, either the current totp code or a recovery code
+// A totp code is refused once its time step was used and a recovery code is dropped once used
+func VerifyMFA(username, code, phrase string) error {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return err
+	}
+
+	if !u.TOTPEnabled {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrMFARequired
+	}
+
+	step, ok, err := algo.NewTOTP(u.TOTPSecret).Validate(code, time.Now(), MFASkew)
+	if err != nil {
+		return err
+	}
+
+	if ok && step > u.TOTPLastStep {
+		u.TOTPLastStep = step
+		return WriteUser(u, phrase)
+	}
+
+	if ok {
+		logL.Warn("User: %s reused a totp code!", username)
+		return ErrInvalidMFACode
+	}
+
+	hash := HashPassword(strings.ToLower(code))
+
+	for i, h := range u.RecoveryCodes {
+
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+
+		u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+		logL.Warn("User: %s used a recovery code! %d left", username, len(u.RecoveryCodes))
+
+		return WriteUser(u, phrase)
+	}
+
+	return ErrInvalidMFACode
+}
+
+// readUser reads the user record treating an empty record as a missing one
+func readUser(username, phrase string) (*model.User, error) {
+
+	u, err := ReadUser(username, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	return u, nil
+}
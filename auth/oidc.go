@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	kindOIDC       = "oidc"
+	oidcConfigName = "config"
+	oidcPrefix     = "oidc-"
+	discoveryPath  = "/.well-known/openid-configuration"
+
+	defaultUsernameClaim = "sub"
+	defaultGroupsClaim   = "groups"
+)
+
+var (
+	// OIDCKeysTTL is how long the provider jwks is kept before being fetched again
+	OIDCKeysTTL = time.Hour
+	// OIDCTimeout bounds every request to the identity provider
+	OIDCTimeout = 10 * time.Second
+
+	// ErrOIDCNotConfigured is returned when no identity provider was configured
+	ErrOIDCNotConfigured = errors.New("oidc: not configured")
+	// ErrBadOIDCConfig is returned when the configuration lacks the issuer or the audience
+	ErrBadOIDCConfig = errors.New("oidc: issuer and audience required")
+	// ErrInvalidIDToken is returned whenever the id token does not pass the verification
+	ErrInvalidIDToken = errors.New("oidc: non valid id token")
+	// ErrNoRoles is returned when the id token maps to no kripto role at all
+	ErrNoRoles = errors.New("oidc: no role mapped to the id token")
+
+	// oidcMethods are the signing methods accepted from the identity provider
+	oidcMethods = []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}
+)
+
+type (
+	// remoteKeys caches the parsed jwks of the identity provider
+	remoteKeys struct {
+		mu      sync.Mutex
+		url     string
+		keys    map[string]interface{}
+		fetched time.Time
+	}
+)
+
+var providerKeys = &remoteKeys{}
+
+// OIDCPrincipal returns the principal name of the users logged in through the identity provider
+func OIDCPrincipal(username string) string {
+	return oidcPrefix + username
+}
+
+// WriteOIDCConfig validates and encrypts the identity provider configuration storing it into the authdb
+func WriteOIDCConfig(config *model.OIDCConfig, phrase string) error {
+
+	if config.Issuer == "" || config.Audience == "" {
+		return ErrBadOIDCConfig
+	}
+
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = defaultUsernameClaim
+	}
+
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = defaultGroupsClaim
+	}
+
+	data, err := encryptJSON(config, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err = sys.MakeEntry(kindOIDC, oidcConfigName, data)
+	if err != nil {
+		return err
+	}
+
+	providerKeys.reset()
+	return nil
+}
+
+// ReadOIDCConfig retrieves the identity provider configuration from the authdb and decrypts it
+func ReadOIDCConfig(phrase string) (*model.OIDCConfig, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadEntry(kindOIDC, oidcConfigName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrOIDCNotConfigured
+		}
+		return nil, err
+	}
+
+	config := &model.OIDCConfig{}
+	err = decryptJSON(data, phrase, config)
+	return config, err
+}
+
+// LoginOIDC verifies the id token against the identity provider and issues a token carrying the mapped roles
+func LoginOIDC(idToken, phrase string) (*model.TokenResponse, error) {
+
+	config, err := ReadOIDCConfig(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyIDToken(config, idToken)
+	if err != nil {
+		logL.Warn("OIDC: Non valid id token! %v", err)
+		return nil, ErrInvalidIDToken
+	}
+
+	username, ok := claims[config.UsernameClaim].(string)
+	if !ok || username == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	roles := groupRoles(config.GroupRoles, config.DefaultRoles, claimGroups(claims[config.GroupsClaim]))
+	if len(roles) == 0 {
+		logL.Warn("OIDC: User: %s has no role mapped!", username)
+		return nil, ErrNoRoles
+	}
+
+	jwta := NewFederatedJwtAuth(&model.Credentials{
+		Username:       OIDCPrincipal(username),
+		TokenExpiresIn: AccessTokenTTL,
+	}, model.MethodOIDC, roles)
+
+	tokenString, issued, err := jwta.IssueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		Token:     tokenString,
+		ExpiresAt: issued.ExpiresAt,
+	}, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience and lifetime of the id token returning its claims
+func verifyIDToken(config *model.OIDCConfig, idToken string) (jwt.MapClaims, error) {
+
+	parser := &jwt.Parser{ValidMethods: oidcMethods, SkipClaimsValidation: true}
+
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(strings.TrimSpace(idToken), claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return providerKeys.key(config, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if iss, _ := claims["iss"].(string); iss != config.Issuer {
+		return nil, ErrBadIssuer
+	}
+
+	if !audienceOf(claims["aud"], config.Audience) {
+		return nil, ErrBadAudience
+	}
+
+	now := time.Now().Unix()
+	skew := int64(ClockSkew / time.Second)
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now > int64(exp)+skew {
+		return nil, ErrTokenExpired
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf)-skew {
+		return nil, ErrTokenNotYetValid
+	}
+
+	return claims, nil
+}
+
+// audienceOf tells whether the aud claim, a string or a list, holds the audience
+func audienceOf(aud interface{}, audience string) bool {
+
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// claimGroups returns the groups of the groups claim, either a string or a list
+func claimGroups(groups interface{}) []string {
+
+	names := []string{}
+
+	switch v := groups.(type) {
+	case string:
+		names = append(names, v)
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+
+	return names
+}
+
+// groupRoles returns the default roles along with the roles mapped to every group
+func groupRoles(mapping map[string][]string, defaults, groups []string) []string {
+
+	roles := append([]string{}, defaults...)
+	for _, group := range groups {
+		for _, role := range mapping[group] {
+			if !contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return roles
+}
+
+// key returns the provider key with the given id, fetching the jwks when expired or when the key is unknown
+func (rk *remoteKeys) key(config *model.OIDCConfig, kid string) (interface{}, error) {
+
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+
+	stale := time.Since(rk.fetched) > OIDCKeysTTL
+
+	if k, ok := rk.keys[kid]; ok && !stale {
+		return k, nil
+	}
+
+	// an unknown kid triggers a fetch, at most once per minute
+	if !stale && time.Since(rk.fetched) < time.Minute {
+		return nil, ErrKeyNotFound
+	}
+
+	err := rk.fetch(config)
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := rk.keys[kid]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return k, nil
+}
+
+func (rk *remoteKeys) fetch(config *model.OIDCConfig) error {
+
+	url := config.JWKSURL
+
+	if url == "" && rk.url == "" {
+
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+
+		err := getJSON(strings.TrimSuffix(config.Issuer, "/")+discoveryPath, &discovery)
+		if err != nil {
+			return err
+		}
+
+		rk.url = discovery.JWKSURI
+	}
+
+	if url == "" {
+		url = rk.url
+	}
+
+	set := model.JWKS{}
+
+	err := getJSON(url, &set)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		k, err := publicKeyOf(jwk)
+		if err != nil {
+			logL.Warn("OIDC: Skipping key %s! %v", jwk.Kid, err)
+			continue
+		}
+
+		keys[jwk.Kid] = k
+	}
+
+	rk.keys = keys
+	rk.fetched = time.Now()
+
+	return nil
+}
+
+func (rk *remoteKeys) reset() {
+
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+
+	rk.url = ""
+	rk.keys = nil
+	rk.fetched = time.Time{}
+}
+
+// publicKeyOf decodes the rsa, P-256 or ed25519 public key of the jwk
+func publicKeyOf(jwk model.JWK) (interface{}, error) {
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("oidc: unsupported curve %s", jwk.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || jwk.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("oidc: bad ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("oidc: unsupported key type %s", jwk.Kty)
+}
+
+// getJSON fetches the url decoding its json body into v
+func getJSON(url string, v interface{}) error {
+
+	client := &http.Client{Timeout: OIDCTimeout}
+
+	// the url is the issuer or jwks url configured by admins
+	/* #nosec */
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
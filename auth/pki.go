@@ -0,0 +1,944 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	dataPKI         = "/data/pki"
+	kindCA          = "ca"
+	kindPKIRole     = "pkirole"
+	kindCertificate = "pkicert"
+	kindCRL         = "crl"
+	entryCA         = "active"
+	entryPendingCA  = "pending"
+	serialBits      = 128
+	pemCertificate  = "CERTIFICATE"
+	pemCSR          = "CERTIFICATE REQUEST"
+	pemCRL          = "X509 CRL"
+)
+
+var (
+	// CATTL is how long the CA generated by kripto lives when the request does not tell
+	CATTL = 10 * 365 * 24 * time.Hour
+	// PKITTL is how long leaf certificates live when neither the request nor the role tell
+	PKITTL = 72 * time.Hour
+	// PKIMaxTTL is the longest leaf certificates live when the role does not tell
+	PKIMaxTTL = 30 * 24 * time.Hour
+	// CRLTTL is how long a revocation list is valid, it is signed again once half of it is gone
+	CRLTTL = 72 * time.Hour
+	// CRLURL is the distribution point written into the leaf certificates, none when empty
+	CRLURL = ""
+
+	// ErrCANotFound is returned when kripto holds no CA, or no intermediate waiting to be signed
+	ErrCANotFound = errors.New("pki: ca not found")
+	// ErrCAExists is returned when generating or importing a CA while another one is in place
+	ErrCAExists = errors.New("pki: ca already in place, remove it first")
+	// ErrBadCA is returned when the CA request carries a bad key type, ttl, certificate or private key
+	ErrBadCA = errors.New("pki: ca certificate with its private key, a known key type and a valid ttl required")
+	// ErrPKIRoleNotFound is returned when the requested pki role does not exist
+	ErrPKIRoleNotFound = errors.New("pki: role not found")
+	// ErrBadPKIRole is returned when the role lacks allowed domains or carries a bad key type or ttls
+	ErrBadPKIRole = errors.New("pki: allowed domains, a known key type and valid ttls required")
+	// ErrBadIssueRequest is returned when the request carries no name, a name that is no hostname, a bad ip or a bad ttl
+	ErrBadIssueRequest = errors.New("pki: at least one valid hostname and a valid ttl required")
+	// ErrNameNotAllowed is returned when a name of the request is not allowed by the role
+	ErrNameNotAllowed = errors.New("pki: name not allowed by the role")
+	// ErrCertificateNotFound is returned when no certificate was issued with the serial number
+	ErrCertificateNotFound = errors.New("pki: certificate not found")
+)
+
+// pkiRecords serializes the changes to the CA, the issued certificates and the revocation list
+var pkiRecords sync.Mutex
+
+// GenerateRootCA creates a self signed CA along with its private key, which never leaves kripto
+func GenerateRootCA(req *model.CARequest, phrase string) (*model.CA, error) {
+
+	ttl := CATTL
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, ErrBadCA
+		}
+	}
+
+	if req.CommonName == "" {
+		return nil, ErrBadCA
+	}
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	err := noCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, key, err := newKey(req.KeyType)
+	if err != nil {
+		return nil, ErrBadCA
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject(req),
+		NotBefore:             now.Add(-ClockSkew),
+		NotAfter:              now.Add(ttl),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return installCA(cert, key, nil, phrase)
+}
+
+// GenerateIntermediateCSR creates the private key of an intermediate CA returning the csr to be signed by another CA
+// The key waits until SetSignedIntermediate installs the signed certificate, generating again replaces it
+func GenerateIntermediateCSR(req *model.CARequest, phrase string) (*model.CA, error) {
+
+	if req.CommonName == "" {
+		return nil, ErrBadCA
+	}
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	err := noCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, key, err := newKey(req.KeyType)
+	if err != nil {
+		return nil, ErrBadCA
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject(req)}, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePKIEntry(kindCA, entryPendingCA, &model.CAKey{PrivateKey: string(key)}, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.CA{CSR: string(pem.EncodeToMemory(&pem.Block{Type: pemCSR, Bytes: der}))}, nil
+}
+
+// SetSignedIntermediate installs the signed certificate of the intermediate waiting for it
+// The certificate may be followed by the ones up to the root, they are handed out along with every leaf
+func SetSignedIntermediate(req *model.CARequest, phrase string) (*model.CA, error) {
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	err := noCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &model.CAKey{}
+	err = readPKIEntry(kindCA, entryPendingCA, phrase, pending, ErrCANotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	_, certs, err := parseBundle([]byte(req.Certificate))
+	if err != nil || len(certs) == 0 {
+		return nil, ErrBadCA
+	}
+
+	return installCA(certs[0], []byte(pending.PrivateKey), certs[1:], phrase)
+}
+
+// ImportCA installs a CA generated elsewhere, root or intermediate, out of its certificate and private key
+// The bundle may also carry the certificates up to the root, they are handed out along with every leaf
+func ImportCA(req *model.CARequest, phrase string) (*model.CA, error) {
+
+	key, certs, err := parseBundle([]byte(req.PEMBundle))
+	if err != nil || key == nil || len(certs) == 0 {
+		return nil, ErrBadCA
+	}
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	err = noCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return installCA(certs[0], key, certs[1:], phrase)
+}
+
+// ReadCA returns the certificate of the CA along with the chain up to the root
+func ReadCA(phrase string) (*model.CA, error) {
+
+	record, cert, _, err := readCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return caInfo(record, cert), nil
+}
+
+// RemoveCA drops the CA, the intermediate waiting to be signed, the issued certificates and the revocation list
+// Certificates already handed out keep working wherever the CA is still trusted
+func RemoveCA() error {
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	sys := fs.NewFileSystem(dataPKI)
+
+	found := false
+
+	for _, kind := range []string{kindCA, kindCertificate, kindCRL} {
+
+		names, err := sys.ListEntries(kind)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+
+			err = sys.DeleteEntry(kind, name)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			found = found || kind == kindCA
+		}
+	}
+
+	if !found {
+		return ErrCANotFound
+	}
+
+	return nil
+}
+
+// WritePKIRole validates and encrypts the pki role storing it
+func WritePKIRole(role *model.PKIRole, phrase string) error {
+
+	if len(role.AllowedDomains) == 0 || !knownKeyType(role.KeyType) {
+		return ErrBadPKIRole
+	}
+
+	for _, domain := range role.AllowedDomains {
+		if domain == "" {
+			return ErrBadPKIRole
+		}
+	}
+
+	ttl, max, err := pkiRoleTTLs(role)
+	if err != nil || ttl > max {
+		return ErrBadPKIRole
+	}
+
+	return writePKIEntry(kindPKIRole, role.Name, role, phrase)
+}
+
+// ReadPKIRole retrieves the pki role and decrypts it
+func ReadPKIRole(name, phrase string) (*model.PKIRole, error) {
+
+	role := &model.PKIRole{}
+	err := readPKIEntry(kindPKIRole, name, phrase, role, ErrPKIRoleNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// RemovePKIRole drops the pki role, certificates already issued through it are still revoked by serial number
+func RemovePKIRole(name string) error {
+
+	sys := fs.NewFileSystem(dataPKI)
+
+	err := sys.DeleteEntry(kindPKIRole, name)
+	if os.IsNotExist(err) {
+		return ErrPKIRoleNotFound
+	}
+
+	return err
+}
+
+// IssueCertificate signs a new leaf certificate, along with a new private key, for the names allowed by the role
+// A zero ttl asks for the role default, any ttl is capped by the role max and by the CA itself
+func IssueCertificate(name string, req *model.IssueRequest, phrase string) (*model.IssuedCertificate, error) {
+
+	role, err := ReadPKIRole(name, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	dnsNames, ips, err := issuedNames(role, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultTTL, max, err := pkiRoleTTLs(role)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := defaultTTL
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, ErrBadIssueRequest
+		}
+	}
+	if ttl > max {
+		ttl = max
+	}
+
+	record, caCert, caSigner, err := readCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, key, err := newKey(role.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: req.CommonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    now.Add(-ClockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  extKeyUsage(role),
+	}
+
+	if role.KeyType == model.KeyTypeRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	if CRLURL != "" {
+		template.CRLDistributionPoints = []string{CRLURL}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, signer.Public(), caSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := string(pem.EncodeToMemory(&pem.Block{Type: pemCertificate, Bytes: der}))
+
+	issued := &model.CertificateRecord{
+		SerialNumber: serialHex(serial),
+		Role:         role.Name,
+		Certificate:  certificate,
+		Expiration:   notAfter.Unix(),
+	}
+
+	pkiRecords.Lock()
+	err = writePKIEntry(kindCertificate, issued.SerialNumber, issued, phrase)
+	pkiRecords.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.IssuedCertificate{
+		Certificate:  certificate,
+		PrivateKey:   string(key),
+		IssuingCA:    record.Certificate,
+		CAChain:      append([]string{record.Certificate}, record.CAChain...),
+		SerialNumber: issued.SerialNumber,
+		Expiration:   issued.Expiration,
+	}, nil
+}
+
+// RevokeCertificate marks the certificate with the serial number as revoked signing the revocation list again
+// Revoking twice keeps the first revocation time
+func RevokeCertificate(serial, phrase string) (*model.CertificateRecord, error) {
+
+	n, ok := new(big.Int).SetString(strings.Replace(serial, ":", "", -1), 16)
+	if !ok {
+		return nil, ErrCertificateNotFound
+	}
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	issued := &model.CertificateRecord{}
+	err := readPKIEntry(kindCertificate, serialHex(n), phrase, issued, ErrCertificateNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	if issued.RevokedAt != 0 {
+		return issued, nil
+	}
+
+	issued.RevokedAt = time.Now().Unix()
+
+	err = writePKIEntry(kindCertificate, issued.SerialNumber, issued, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rotateCRL(phrase)
+	return issued, err
+}
+
+// ReadCRL returns the der encoded revocation list, signing a new one once half of the current validity is gone
+func ReadCRL(phrase string) ([]byte, error) {
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	crl := &model.CRL{}
+	err := readPKIEntry(kindCRL, entryCA, phrase, crl, ErrCANotFound)
+	if err != nil && err != ErrCANotFound {
+		return nil, err
+	}
+
+	if err == nil && time.Until(time.Unix(crl.NextUpdate, 0)) > CRLTTL/2 {
+		return crl.DER, nil
+	}
+
+	return rotateCRL(phrase)
+}
+
+// RotateCRL signs a new revocation list at once returning it der encoded
+func RotateCRL(phrase string) ([]byte, error) {
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	return rotateCRL(phrase)
+}
+
+// EncodeCRL returns the der encoded revocation list pem encoded
+func EncodeCRL(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: pemCRL, Bytes: der})
+}
+
+// PruneCertificates drops the records of the expired certificates, they are left out of the revocation list anyway
+func PruneCertificates(phrase string) (int, error) {
+
+	pkiRecords.Lock()
+	defer pkiRecords.Unlock()
+
+	sys := fs.NewFileSystem(dataPKI)
+
+	records, err := certificateRecords(phrase)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	pruned := 0
+
+	for _, issued := range records {
+
+		if issued.Expiration > now {
+			continue
+		}
+
+		err = sys.DeleteEntry(kindCertificate, issued.SerialNumber)
+		if err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// rotateCRL signs the revocation list of the certificates revoked and not yet expired, the caller holds the pkiRecords lock
+func rotateCRL(phrase string) ([]byte, error) {
+
+	_, caCert, caSigner, err := readCA(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := &model.CRL{}
+	err = readPKIEntry(kindCRL, entryCA, phrase, previous, ErrCANotFound)
+	if err != nil && err != ErrCANotFound {
+		return nil, err
+	}
+
+	records, err := certificateRecords(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	revoked := []x509.RevocationListEntry{}
+
+	for _, issued := range records {
+
+		if issued.RevokedAt == 0 || issued.Expiration <= now.Unix() {
+			continue
+		}
+
+		serial, ok := new(big.Int).SetString(issued.SerialNumber, 16)
+		if !ok {
+			continue
+		}
+
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: time.Unix(issued.RevokedAt, 0)})
+	}
+
+	crl := &model.CRL{Number: previous.Number + 1, NextUpdate: now.Add(CRLTTL).Unix()}
+
+	crl.DER, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(crl.Number),
+		ThisUpdate:                now,
+		NextUpdate:                time.Unix(crl.NextUpdate, 0),
+		RevokedCertificateEntries: revoked,
+	}, caCert, caSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePKIEntry(kindCRL, entryCA, crl, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return crl.DER, nil
+}
+
+// installCA validates the certificate against its private key storing them as the CA, the caller holds the pkiRecords lock
+func installCA(cert *x509.Certificate, key []byte, chain []*x509.Certificate, phrase string) (*model.CA, error) {
+
+	signer, err := parseSigner(key)
+	if err != nil {
+		return nil, ErrBadCA
+	}
+
+	public, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(signer.Public()) {
+		return nil, ErrBadCA
+	}
+
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 || time.Now().After(cert.NotAfter) {
+		return nil, ErrBadCA
+	}
+
+	record := &model.CAKey{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: pemCertificate, Bytes: cert.Raw})),
+		PrivateKey:  string(key),
+	}
+
+	for _, c := range chain {
+		record.CAChain = append(record.CAChain, string(pem.EncodeToMemory(&pem.Block{Type: pemCertificate, Bytes: c.Raw})))
+	}
+
+	err = writePKIEntry(kindCA, entryCA, record, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sys := fs.NewFileSystem(dataPKI)
+	err = sys.DeleteEntry(kindCA, entryPendingCA)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, err = rotateCRL(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return caInfo(record, cert), nil
+}
+
+// readCA returns the CA record along with its parsed certificate and private key
+func readCA(phrase string) (*model.CAKey, *x509.Certificate, crypto.Signer, error) {
+
+	record := &model.CAKey{}
+	err := readPKIEntry(kindCA, entryCA, phrase, record, ErrCANotFound)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	_, certs, err := parseBundle([]byte(record.Certificate))
+	if err != nil || len(certs) == 0 {
+		return nil, nil, nil, ErrBadCA
+	}
+
+	signer, err := parseSigner([]byte(record.PrivateKey))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return record, certs[0], signer, nil
+}
+
+// noCA tells whether a CA may be generated or imported
+func noCA(phrase string) error {
+
+	err := readPKIEntry(kindCA, entryCA, phrase, &model.CAKey{}, ErrCANotFound)
+	if err == nil {
+		return ErrCAExists
+	}
+	if err != ErrCANotFound {
+		return err
+	}
+
+	return nil
+}
+
+func caInfo(record *model.CAKey, cert *x509.Certificate) *model.CA {
+	return &model.CA{
+		Certificate:  record.Certificate,
+		CAChain:      record.CAChain,
+		SerialNumber: serialHex(cert.SerialNumber),
+		Expiration:   cert.NotAfter.Unix(),
+	}
+}
+
+// certificateRecords reads every issued certificate record
+func certificateRecords(phrase string) ([]*model.CertificateRecord, error) {
+
+	sys := fs.NewFileSystem(dataPKI)
+
+	names, err := sys.ListEntries(kindCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*model.CertificateRecord{}
+
+	for _, name := range names {
+
+		issued := &model.CertificateRecord{}
+		err = readPKIEntry(kindCertificate, name, phrase, issued, ErrCertificateNotFound)
+		if err == ErrCertificateNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, issued)
+	}
+
+	return records, nil
+}
+
+// issuedNames checks every name of the request against the role returning the dns names and the ip addresses
+func issuedNames(role *model.PKIRole, req *model.IssueRequest) ([]string, []net.IP, error) {
+
+	dnsNames := []string{}
+
+	for _, name := range append([]string{req.CommonName}, req.AltNames...) {
+
+		if name == "" || contains(dnsNames, name) {
+			continue
+		}
+
+		if !validHostname(name) {
+			return nil, nil, ErrBadIssueRequest
+		}
+
+		if strings.HasPrefix(name, "*.") && !role.AllowWildcards {
+			return nil, nil, ErrNameNotAllowed
+		}
+
+		if !allowedName(role, name) {
+			return nil, nil, ErrNameNotAllowed
+		}
+
+		dnsNames = append(dnsNames, name)
+	}
+
+	ips := []net.IP{}
+
+	for _, value := range req.IPSANs {
+
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, nil, ErrBadIssueRequest
+		}
+
+		if !role.AllowIPSANs {
+			return nil, nil, ErrNameNotAllowed
+		}
+
+		ips = append(ips, ip)
+	}
+
+	if len(dnsNames) == 0 && len(ips) == 0 {
+		return nil, nil, ErrBadIssueRequest
+	}
+
+	return dnsNames, ips, nil
+}
+
+// allowedName reports whether the name matches one of the domains of the role, names are case insensitive
+// Domains are matched label by label so a * never spans a dot, *.example.com allows api.example.com but not a.b.example.com
+func allowedName(role *model.PKIRole, name string) bool {
+
+	labels := strings.Split(strings.ToLower(name), ".")
+
+	for _, domain := range role.AllowedDomains {
+
+		patterns := strings.Split(strings.ToLower(domain), ".")
+		if len(patterns) != len(labels) {
+			continue
+		}
+
+		matched := true
+		for i, pattern := range patterns {
+			if !MatchPattern(pattern, labels[i]) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validHostname reports whether the name is a dns hostname, its first label may be a lone * standing for a wildcard name
+func validHostname(name string) bool {
+
+	if len(name) > 253 {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+
+	for i, label := range labels {
+
+		if i == 0 && label == "*" && len(labels) > 2 {
+			continue
+		}
+
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// extKeyUsage returns the extended key usages of the role, server authentication when it tells none
+func extKeyUsage(role *model.PKIRole) []x509.ExtKeyUsage {
+
+	usages := []x509.ExtKeyUsage{}
+
+	if role.ServerFlag || !role.ClientFlag {
+		usages = append(usages, x509.ExtKeyUsageServerAuth)
+	}
+
+	if role.ClientFlag {
+		usages = append(usages, x509.ExtKeyUsageClientAuth)
+	}
+
+	return usages
+}
+
+// pkiRoleTTLs parses the ttl and max ttl of the role falling back to PKITTL and PKIMaxTTL
+func pkiRoleTTLs(role *model.PKIRole) (time.Duration, time.Duration, error) {
+
+	ttl, max := PKITTL, PKIMaxTTL
+	var err error
+
+	if role.TTL != "" {
+		ttl, err = time.ParseDuration(role.TTL)
+		if err != nil || ttl <= 0 {
+			return 0, 0, ErrBadPKIRole
+		}
+	}
+
+	if role.MaxTTL != "" {
+		max, err = time.ParseDuration(role.MaxTTL)
+		if err != nil || max <= 0 {
+			return 0, 0, ErrBadPKIRole
+		}
+	}
+
+	return ttl, max, nil
+}
+
+func knownKeyType(keyType string) bool {
+	return keyType == "" || keyType == model.KeyTypeEC || keyType == model.KeyTypeRSA || keyType == model.KeyTypeEd25519
+}
+
+// newKey generates a key pair of the type, ecdsa when empty, returning the signer along with the pem encoded private key
+func newKey(keyType string) (crypto.Signer, []byte, error) {
+
+	asymmetrical := algo.NewAsymmetrical()
+
+	var key []byte
+	var err error
+
+	switch keyType {
+	case "", model.KeyTypeEC:
+		key, _, err = asymmetrical.GenerateECDSA()
+	case model.KeyTypeRSA:
+		key, _, err = asymmetrical.GenerateRSA(algo.RSAKeySize)
+	case model.KeyTypeEd25519:
+		key, _, err = asymmetrical.GenerateEd25519()
+	default:
+		return nil, nil, ErrBadPKIRole
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := parseSigner(key)
+	return signer, key, err
+}
+
+func parseSigner(key []byte) (crypto.Signer, error) {
+
+	parsed, err := algo.NewAsymmetrical().ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrBadCA
+	}
+
+	return signer, nil
+}
+
+// parseBundle splits the pem blocks into the first private key and the certificates, in the order found
+func parseBundle(data []byte) ([]byte, []*x509.Certificate, error) {
+
+	var key []byte
+	certs := []*x509.Certificate{}
+
+	for {
+
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != pemCertificate {
+			if key == nil && strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				key = pem.EncodeToMemory(block)
+			}
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return key, certs, nil
+}
+
+func subject(req *model.CARequest) pkix.Name {
+
+	name := pkix.Name{CommonName: req.CommonName}
+	if req.Organization != "" {
+		name.Organization = []string{req.Organization}
+	}
+
+	return name
+}
+
+// newSerial returns a random positive serial number, serials are never reused by the CA
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialBits))
+}
+
+func serialHex(serial *big.Int) string {
+	return fmt.Sprintf("%x", serial)
+}
+
+func writePKIEntry(kind, name string, v interface{}, phrase string) error {
+
+	data, err := encryptJSON(v, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataPKI)
+	return sys.MakeEntry(kind, name, data)
+}
+
+// readPKIEntry reads and decrypts the entry into the value, a missing or badly named entry is notFound
+func readPKIEntry(kind, name, phrase string, v interface{}, notFound error) error {
+
+	sys := fs.NewFileSystem(dataPKI)
+
+	data, err := sys.ReadEntry(kind, name)
+	if os.IsNotExist(err) || err == fs.ErrBadInput {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return decryptJSON(data, phrase, v)
+}
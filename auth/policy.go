@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+var (
+	// ErrPolicyNotFound is returned when the requested policy has no record into the authdb
+	ErrPolicyNotFound = errors.New("policy: not found")
+	// ErrRoleNotFound is returned when the requested role has no record into the authdb
+	ErrRoleNotFound = errors.New("role: not found")
+)
+
+// WritePolicy validates and encrypts the policy storing it into the authdb
+func WritePolicy(policy *model.Policy, phrase string) error {
+
+	err := ValidatePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	data, err := encryptJSON(policy, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+	err = sys.MakePolicy(policy.Name, data)
+	return err
+}
+
+// ReadPolicy retrieves the policy from the authdb and decrypts it
+func ReadPolicy(name, phrase string) (*model.Policy, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadPolicy(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrPolicyNotFound
+		}
+		return nil, err
+	}
+
+	policy := &model.Policy{}
+	err = decryptJSON(data, phrase, policy)
+	return policy, err
+}
+
+// RemovePolicy drops the policy from the authdb
+func RemovePolicy(name string) error {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err := sys.DeletePolicy(name)
+	if os.IsNotExist(err) {
+		return ErrPolicyNotFound
+	}
+
+	return err
+}
+
+// ListPolicies reads and decrypts every policy found into the authdb
+func ListPolicies(phrase string) ([]*model.Policy, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	names, err := sys.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []*model.Policy{}
+	for _, name := range names {
+
+		policy, err := ReadPolicy(name, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// WriteRole encrypts the role storing it into the authdb
+func WriteRole(role *model.Role, phrase string) error {
+
+	data, err := encryptJSON(role, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+	err = sys.MakeRole(role.Name, data)
+	return err
+}
+
+// ReadRole retrieves the role from the authdb and decrypts it
+func ReadRole(name, phrase string) (*model.Role, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadRole(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+
+	role := &model.Role{}
+	err = decryptJSON(data, phrase, role)
+	return role, err
+}
+
+// RemoveRole drops the role from the authdb
+func RemoveRole(name string) error {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err := sys.DeleteRole(name)
+	if os.IsNotExist(err) {
+		return ErrRoleNotFound
+	}
+
+	return err
+}
+
+// ListRoles reads and decrypts every role found into the authdb
+func ListRoles(phrase string) ([]*model.Role, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	names, err := sys.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []*model.Role{}
+	for _, name := range names {
+
+		role, err := ReadRole(name, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// ValidatePolicy checks the policy carries only known capabilities and non empty patterns
+func ValidatePolicy(policy *model.Policy) error {
+
+	for _, rule := range policy.Rules {
+
+		if rule.Apps == "" {
+			return errors.New("policy: rule without apps pattern")
+		}
+
+		for _, capability := range rule.Capabilities {
+			if !contains(model.Capabilities, capability) {
+				return fmt.Errorf("policy: unknown capability %s", capability)
+			}
+		}
+	}
+
+	return nil
+}
+
+// EffectivePolicies resolves every policy granted to the principal either directly or through its roles
+// Policies or roles that no longer exist are ignored
+func EffectivePolicies(principal *model.Principal, phrase string) ([]*model.Policy, error) {
+
+	names := append([]string{}, principal.Policies...)
+
+	for _, name := range principal.Roles {
+
+		role, err := ReadRole(name, phrase)
+		if err == ErrRoleNotFound {
+			logL.Warn("User: %s has a missing role %s", principal.Username, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, role.Policies...)
+	}
+
+	policies := []*model.Policy{}
+	seen := map[string]bool{}
+	for _, name := range names {
+
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		policy, err := ReadPolicy(name, phrase)
+		if err == ErrPolicyNotFound {
+			logL.Warn("User: %s has a missing policy %s", principal.Username, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// Allowed checks whether the principal holds the capability over the app
+// Admin principals are allowed to do anything
+func Allowed(principal *model.Principal, app, capability, phrase string) (bool, error) {
+
+	if principal.Admin {
+		return true, nil
+	}
+
+	policies, err := EffectivePolicies(principal, phrase)
+	if err != nil {
+		return false, err
+	}
+
+	return Grants(policies, app, capability), nil
+}
+
+// Grants checks whether any of the policies holds the capability over the app
+func Grants(policies []*model.Policy, app, capability string) bool {
+
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+
+			if !MatchPattern(rule.Apps, app) {
+				continue
+			}
+
+			if contains(rule.Capabilities, capability) || contains(rule.Capabilities, model.CapabilityAdmin) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MatchPattern reports whether the name matches the pattern where * stands for any sequence of characters
+func MatchPattern(pattern, name string) bool {
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	reg, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+
+	return reg.MatchString(name)
+}
+
+// encryptJSON marshals the value and encrypts it using the kripto built in passphrase
+func encryptJSON(v interface{}, phrase string) ([]byte, error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	symmetrical := algo.NewSymmetrical()
+	return symmetrical.Encrypt(b, phrase)
+}
+
+// decryptJSON decrypts the data using the kripto built in passphrase and unmarshals it into the value
+func decryptJSON(data []byte, phrase string, v interface{}) error {
+
+	symmetrical := algo.NewSymmetrical()
+	b, err := symmetrical.Decrypt(data, phrase)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
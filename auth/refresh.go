@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	dataRefresh   = "/data/refresh"
+	kindRefresh   = "refresh"
+	kindFamily    = "family"
+	refreshSize   = 32
+	refreshPrefix = "krr_"
+)
+
+var (
+	// AccessTokenTTL is the longest lifetime of an access token, the user expiration time may shorten it
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is the lifetime of each refresh token, every rotation issues a new one
+	RefreshTokenTTL = 24 * time.Hour
+
+	// ErrInvalidRefresh is returned when the refresh token is unknown, expired or its family was revoked
+	ErrInvalidRefresh = errors.New("refresh: non valid token")
+	// ErrRefreshReused is returned when an already rotated refresh token is presented again
+	ErrRefreshReused = errors.New("refresh: token reused, family revoked")
+)
+
+// rotation serializes refresh token rotations so a token can never be used twice
+var rotation sync.Mutex
+
+// NewSession issues a short lived access token along with a refresh token starting a new family
+func NewSession(u *model.User, phrase string) (*model.TokenResponse, error) {
+
+	family, err := algo.RandomHex(tokenIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueSession(u, family, phrase)
+}
+
+// Refresh rotates the refresh token returning a new access and refresh token of the same family
+// Presenting an already rotated token revokes the whole family
+func Refresh(refreshToken, phrase string) (*model.TokenResponse, error) {
+
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	rt, err := readRefresh(hashRefresh(refreshToken), phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if rt == nil || rt.ExpiresAt < time.Now().Unix() || familyRevoked(rt.Family) {
+		return nil, ErrInvalidRefresh
+	}
+
+	if rt.Used {
+		logJ.Warn("User: %s Refresh token reused! Revoking family %s", rt.Username, rt.Family)
+
+		err = RevokeFamily(rt.Family, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrRefreshReused
+	}
+
+	u, err := ReadUser(rt.Username, phrase)
+	if err != nil || u == nil || u.Disabled {
+		return nil, ErrInvalidRefresh
+	}
+
+	rt.Used = true
+
+	err = writeRefresh(rt, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueSession(u, rt.Family, phrase)
+}
+
+// RevokeRefresh revokes the family of the refresh token owned by the user, used on logout
+func RevokeRefresh(refreshToken, username, phrase string) error {
+
+	rt, err := readRefresh(hashRefresh(refreshToken), phrase)
+	if err != nil {
+		return err
+	}
+
+	if rt == nil || rt.Username != username {
+		return ErrInvalidRefresh
+	}
+
+	return RevokeFamily(rt.Family, phrase)
+}
+
+// RevokeFamily revokes every refresh token of the family along with the access tokens issued with them
+func RevokeFamily(family, phrase string) error {
+
+	sys := fs.NewFileSystem(dataRefresh)
+
+	ids, err := sys.ListEntries(kindRefresh)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(RefreshTokenTTL).Unix()
+
+	for _, id := range ids {
+
+		rt, err := readRefresh(id, phrase)
+		if err != nil {
+			return err
+		}
+
+		if rt == nil || rt.Family != family {
+			continue
+		}
+
+		if rt.ExpiresAt > expiresAt {
+			expiresAt = rt.ExpiresAt
+		}
+
+		err = RevokeToken(rt.TokenID, time.Now().Add(AccessTokenTTL))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the family mark outlives every token of the family
+	return sys.MakeEntry(kindFamily, family, []byte(fmt.Sprintf("%d", expiresAt)))
+}
+
+// RevokeUserFamilies revokes every refresh token family of the user so a revoked or removed user cannot get new access tokens
+func RevokeUserFamilies(username, phrase string) error {
+
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	sys := fs.NewFileSystem(dataRefresh)
+
+	ids, err := sys.ListEntries(kindRefresh)
+	if err != nil {
+		return err
+	}
+
+	families := map[string]bool{}
+
+	for _, id := range ids {
+
+		rt, err := readRefresh(id, phrase)
+		if err != nil {
+			return err
+		}
+
+		if rt == nil || rt.Username != username || families[rt.Family] || familyRevoked(rt.Family) {
+			continue
+		}
+
+		families[rt.Family] = true
+
+		err = RevokeFamily(rt.Family, phrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PruneRefreshTokens drops every expired refresh token and family mark and returns how many were dropped
+func PruneRefreshTokens(phrase string) (int, error) {
+
+	sys := fs.NewFileSystem(dataRefresh)
+	now := time.Now().Unix()
+	pruned := 0
+
+	ids, err := sys.ListEntries(kindRefresh)
+	if err != nil {
+		return pruned, err
+	}
+
+	for _, id := range ids {
+
+		rt, err := readRefresh(id, phrase)
+		if err != nil {
+			return pruned, err
+		}
+
+		if rt != nil && rt.ExpiresAt > now {
+			continue
+		}
+
+		err = sys.DeleteEntry(kindRefresh, id)
+		if err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	families, err := sys.ListEntries(kindFamily)
+	if err != nil {
+		return pruned, err
+	}
+
+	for _, family := range families {
+
+		data, err := sys.ReadEntry(kindFamily, family)
+		if err != nil {
+			return pruned, err
+		}
+
+		var expiresAt int64
+		_, err = fmt.Sscanf(string(data), "%d", &expiresAt)
+		if err == nil && expiresAt > now {
+			continue
+		}
+
+		err = sys.DeleteEntry(kindFamily, family)
+		if err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// issueSession issues the access token and the refresh token of the family
+func issueSession(u *model.User, family, phrase string) (*model.TokenResponse, error) {
+
+	ttl := AccessTokenTTL
+	if u.TokenExpiresIn > 0 && u.TokenExpiresIn < ttl {
+		ttl = u.TokenExpiresIn
+	}
+
+	jwta := NewJwtAuth(&model.Credentials{
+		Username:       u.Username,
+		TokenExpiresIn: ttl,
+	})
+
+	tokenString, claims, err := jwta.IssueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := algo.RandomHex(refreshSize)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := refreshPrefix + secret
+	now := time.Now()
+
+	rt := &model.RefreshToken{
+		ID:        hashRefresh(refreshToken),
+		Family:    family,
+		Username:  u.Username,
+		TokenID:   claims.Id,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(RefreshTokenTTL).Unix(),
+	}
+
+	err = writeRefresh(rt, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		Token:            tokenString,
+		ExpiresAt:        claims.ExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: rt.ExpiresAt,
+	}, nil
+}
+
+// hashRefresh returns the hash under which the refresh token is stored, the token itself is never kept
+func hashRefresh(refreshToken string) string {
+	return fmt.Sprintf("%x", algo.MakeSimpleHash(refreshToken))
+}
+
+func familyRevoked(family string) bool {
+
+	sys := fs.NewFileSystem(dataRefresh)
+
+	_, err := sys.ReadEntry(kindFamily, family)
+	return err == nil
+}
+
+func writeRefresh(rt *model.RefreshToken, phrase string) error {
+
+	data, err := encryptJSON(rt, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataRefresh)
+	return sys.MakeEntry(kindRefresh, rt.ID, data)
+}
+
+// readRefresh returns the refresh token record or nil when there is none
+func readRefresh(id, phrase string) (*model.RefreshToken, error) {
+
+	sys := fs.NewFileSystem(dataRefresh)
+
+	data, err := sys.ReadEntry(kindRefresh, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rt := &model.RefreshToken{}
+	err = decryptJSON(data, phrase, rt)
+	return rt, err
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	dataRevoked = "/data/revoked"
+	kindToken   = "jti"
+	kindUser    = "user"
+
+	defaultTokenLifetime = 24 * time.Hour
+)
+
+// ErrTokenRevoked is returned when the token, or every token of its owner, has been revoked
+var ErrTokenRevoked = errors.New("jwt: token revoked")
+
+// RevokeToken adds the token id to the revocation list until the token expires
+func RevokeToken(jti string, expiresAt time.Time) error {
+
+	if jti == "" {
+		return errors.New("revoke: missing token id")
+	}
+
+	return writeRevocation(kindToken, jti, &model.Revocation{
+		ID:        jti,
+		RevokedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+}
+
+// RevokeUser revokes every token issued to the user up to now along with every refresh token family of the user
+// The entry is kept as long as the longest token lifetime among users, named after the hash of the username
+// so federated principals such as ldap-john.doe fit a file name as well
+func RevokeUser(username, phrase string) error {
+
+	lifetime, err := MaxTokenLifetime(phrase)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = writeRevocation(kindUser, userRevocation(username), &model.Revocation{
+		ID:            username,
+		RevokedAt:     now.Unix(),
+		RevokedAtNano: now.UnixNano(),
+		ExpiresAt:     now.Add(lifetime).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return RevokeUserFamilies(username, phrase)
+}
+
+// MaxTokenLifetime returns how long a token may live given every user expiration time
+func MaxTokenLifetime(phrase string) (time.Duration, error) {
+
+	users, err := ListUsers(phrase)
+	if err != nil {
+		return 0, err
+	}
+
+	lifetime := defaultTokenLifetime
+	for _, u := range users {
+		if u.TokenExpiresIn > lifetime {
+			lifetime = u.TokenExpiresIn
+		}
+	}
+
+	return lifetime, nil
+}
+
+// IsRevoked checks the token id and its owner against the revocation list
+// Tokens of a revoked user are compared in nanoseconds, a token issued right after the revocation stays valid
+// even within the same second, tokens issued before nanoseconds were kept are compared in whole seconds
+func IsRevoked(claims *model.CustomClaims) (bool, error) {
+
+	if claims.Id != "" {
+
+		r, err := readRevocation(kindToken, claims.Id)
+		if err != nil {
+			return false, err
+		}
+
+		if r != nil {
+			return true, nil
+		}
+	}
+
+	r, err := readUserRevocation(claims.Username)
+	if err != nil {
+		return false, err
+	}
+
+	if r == nil {
+		return false, nil
+	}
+
+	if claims.IssuedAtNano != 0 && r.RevokedAtNano != 0 {
+		return claims.IssuedAtNano <= r.RevokedAtNano, nil
+	}
+
+	return claims.IssuedAt <= r.RevokedAt, nil
+}
+
+// PruneRevocations drops every revocation whose tokens are expired by now and returns how many were dropped
+func PruneRevocations() (int, error) {
+
+	sys := fs.NewFileSystem(dataRevoked)
+	now := time.Now().Unix()
+	pruned := 0
+
+	for _, kind := range []string{kindToken, kindUser} {
+
+		ids, err := sys.ListEntries(kind)
+		if err != nil {
+			return pruned, err
+		}
+
+		for _, id := range ids {
+
+			r, err := readRevocation(kind, id)
+			if err != nil {
+				return pruned, err
+			}
+
+			if r == nil || r.ExpiresAt > now {
+				continue
+			}
+
+			err = sys.DeleteEntry(kind, id)
+			if err != nil && !os.IsNotExist(err) {
+				return pruned, err
+			}
+
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
+
+func writeRevocation(kind, name string, r *model.Revocation) error {
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataRevoked)
+	return sys.MakeEntry(kind, name, data)
+}
+
+// readUserRevocation returns the revocation of the user or nil when there is none
+// Entries written before they were named after the hash of the username are still honored until pruned
+func readUserRevocation(username string) (*model.Revocation, error) {
+
+	r, err := readRevocation(kindUser, userRevocation(username))
+	if err != nil || r != nil {
+		return r, err
+	}
+
+	r, err = readRevocation(kindUser, username)
+	if err != nil || r == nil || r.ID != username {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// userRevocation names the revocation entry of the user
+func userRevocation(username string) string {
+
+	sum := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(sum[:])
+}
+
+// readRevocation returns the revocation or nil when there is none
+func readRevocation(kind, name string) (*model.Revocation, error) {
+
+	sys := fs.NewFileSystem(dataRevoked)
+
+	data, err := sys.ReadEntry(kind, name)
+	if err != nil {
+		if os.IsNotExist(err) || err == fs.ErrBadInput {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	r := &model.Revocation{}
+	err = json.Unmarshal(data, r)
+	return r, err
+}
@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	dataSSH          = "/data/ssh"
+	kindSSHCA        = "sshca"
+	kindSSHRole      = "sshrole"
+	entrySSHCA       = "active"
+	usernameTemplate = "{{username}}"
+)
+
+var (
+	// SSHTTL is how long signed keys live when neither the request nor the role tell
+	SSHTTL = time.Hour
+	// SSHMaxTTL is the longest signed keys live when the role does not tell
+	SSHMaxTTL = 24 * time.Hour
+
+	// ErrSSHCANotFound is returned when kripto holds no SSH CA
+	ErrSSHCANotFound = errors.New("ssh: ca not found")
+	// ErrSSHCAExists is returned when generating the SSH CA while another one is in place
+	ErrSSHCAExists = errors.New("ssh: ca already in place, remove it first")
+	// ErrSSHRoleNotFound is returned when the requested ssh role does not exist
+	ErrSSHRoleNotFound = errors.New("ssh: role not found")
+	// ErrBadSSHRole is returned when the role lacks allowed principals or carries a bad cert type, defaults or ttls
+	ErrBadSSHRole = errors.New("ssh: allowed principals, a known cert type, allowed defaults and valid ttls required")
+	// ErrBadSignRequest is returned when the request carries a bad public key, no principal or a bad ttl
+	ErrBadSignRequest = errors.New("ssh: public key, at least one principal and a valid ttl required")
+	// ErrSSHNotAllowed is returned when a principal, critical option or extension of the request is not allowed by the role
+	ErrSSHNotAllowed = errors.New("ssh: principal, critical option or extension not allowed by the role")
+)
+
+// sshRecords serializes the generation and removal of the SSH CA
+var sshRecords sync.Mutex
+
+// GenerateSSHCA creates the ed25519 SSH CA key returning its public key, the private key never leaves kripto
+func GenerateSSHCA(phrase string) (*model.SSHCA, error) {
+
+	sshRecords.Lock()
+	defer sshRecords.Unlock()
+
+	_, err := readSSHSigner(phrase)
+	if err == nil {
+		return nil, ErrSSHCAExists
+	}
+	if err != ErrSSHCANotFound {
+		return nil, err
+	}
+
+	key, _, err := algo.NewAsymmetrical().GenerateEd25519()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeSSHEntry(kindSSHCA, entrySSHCA, &model.SSHCAKey{PrivateKey: string(key)}, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadSSHCA(phrase)
+}
+
+// ReadSSHCA returns the public key of the SSH CA
+func ReadSSHCA(phrase string) (*model.SSHCA, error) {
+
+	signer, err := readSSHSigner(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SSHCA{PublicKey: string(ssh.MarshalAuthorizedKey(signer.PublicKey()))}, nil
+}
+
+// RemoveSSHCA drops the SSH CA, certificates already signed keep working wherever the CA is still trusted
+func RemoveSSHCA() error {
+
+	sshRecords.Lock()
+	defer sshRecords.Unlock()
+
+	sys := fs.NewFileSystem(dataSSH)
+
+	err := sys.DeleteEntry(kindSSHCA, entrySSHCA)
+	if os.IsNotExist(err) {
+		return ErrSSHCANotFound
+	}
+
+	return err
+}
+
+// WriteSSHRole validates and encrypts the ssh role storing it
+func WriteSSHRole(role *model.SSHRole, phrase string) error {
+
+	if len(role.AllowedPrincipals) == 0 || (role.CertType != "" && role.CertType != model.CertTypeUser && role.CertType != model.CertTypeHost) {
+		return ErrBadSSHRole
+	}
+
+	for _, principal := range role.AllowedPrincipals {
+		if principal == "" {
+			return ErrBadSSHRole
+		}
+	}
+
+	// host certificates carry neither critical options nor extensions
+	if role.CertType == model.CertTypeHost && len(role.AllowedCriticalOptions)+len(role.AllowedExtensions) > 0 {
+		return ErrBadSSHRole
+	}
+
+	if !allowedOptions(role.DefaultCriticalOptions, role.AllowedCriticalOptions) || !allowedOptions(role.DefaultExtensions, role.AllowedExtensions) {
+		return ErrBadSSHRole
+	}
+
+	ttl, max, err := sshRoleTTLs(role)
+	if err != nil || ttl > max {
+		return ErrBadSSHRole
+	}
+
+	return writeSSHEntry(kindSSHRole, role.Name, role, phrase)
+}
+
+// ReadSSHRole retrieves the ssh role and decrypts it
+func ReadSSHRole(name, phrase string) (*model.SSHRole, error) {
+
+	role := &model.SSHRole{}
+	err := readSSHEntry(kindSSHRole, name, phrase, role, ErrSSHRoleNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// RemoveSSHRole drops the ssh role, certificates already signed through it live until they expire
+func RemoveSSHRole(name string) error {
+
+	sys := fs.NewFileSystem(dataSSH)
+
+	err := sys.DeleteEntry(kindSSHRole, name)
+	if os.IsNotExist(err) {
+		return ErrSSHRoleNotFound
+	}
+
+	return err
+}
+
+// SignSSHKey signs the public key of the request for the principals, critical options and extensions allowed by the role
+// The username of the caller stands for {{username}} in the principals of the role, a zero ttl asks for the role default
+func SignSSHKey(name string, req *model.SSHSignRequest, username, phrase string) (*model.SSHSignedKey, error) {
+
+	role, err := ReadSSHRole(name, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, ErrBadSignRequest
+	}
+
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, ErrBadSignRequest
+	}
+
+	principals := req.ValidPrincipals
+	if len(principals) == 0 {
+		principals = expandPrincipals(role.DefaultPrincipals, username)
+	}
+
+	if len(principals) == 0 {
+		return nil, ErrBadSignRequest
+	}
+
+	for _, principal := range principals {
+		if principal == "" || !matchPrincipals(role.AllowedPrincipals, username, principal) {
+			return nil, ErrSSHNotAllowed
+		}
+	}
+
+	criticalOptions, err := requestedOptions(req.CriticalOptions, role.DefaultCriticalOptions, role.AllowedCriticalOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := requestedOptions(req.Extensions, role.DefaultExtensions, role.AllowedExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultTTL, max, err := sshRoleTTLs(role)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := defaultTTL
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, ErrBadSignRequest
+		}
+	}
+	if ttl > max {
+		ttl = max
+	}
+
+	signer, err := readSSHSigner(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 8)
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	serial := binary.BigEndian.Uint64(b)
+	now := time.Now()
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("kripto-%s-%s-%x", role.Name, username, serial),
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-ClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+			Extensions:      extensions,
+		},
+	}
+
+	if role.CertType == model.CertTypeHost {
+		cert.CertType = ssh.HostCert
+	}
+
+	err = cert.SignCert(rand.Reader, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SSHSignedKey{
+		SignedKey:       string(ssh.MarshalAuthorizedKey(cert)),
+		SerialNumber:    fmt.Sprintf("%x", serial),
+		KeyID:           cert.KeyId,
+		ValidPrincipals: principals,
+		ValidAfter:      int64(cert.ValidAfter),
+		ValidBefore:     int64(cert.ValidBefore),
+	}, nil
+}
+
+// readSSHSigner returns the signer of the SSH CA key
+func readSSHSigner(phrase string) (ssh.Signer, error) {
+
+	record := &model.SSHCAKey{}
+	err := readSSHEntry(kindSSHCA, entrySSHCA, phrase, record, ErrSSHCANotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := algo.NewAsymmetrical().ParsePrivateKey([]byte(record.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(key)
+}
+
+// requestedOptions returns the options of the request, or the defaults when it asks for none, refusing the ones not allowed
+func requestedOptions(requested, defaults map[string]string, allowed []string) (map[string]string, error) {
+
+	if len(requested) == 0 {
+		return defaults, nil
+	}
+
+	if !allowedOptions(requested, allowed) {
+		return nil, ErrSSHNotAllowed
+	}
+
+	return requested, nil
+}
+
+// allowedOptions reports whether every option is listed as allowed, * allowing any
+func allowedOptions(options map[string]string, allowed []string) bool {
+
+	for option := range options {
+		if !contains(allowed, option) && !contains(allowed, "*") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func expandPrincipals(principals []string, username string) []string {
+
+	expanded := []string{}
+
+	for _, principal := range principals {
+		expanded = append(expanded, strings.Replace(principal, usernameTemplate, username, -1))
+	}
+
+	return expanded
+}
+
+// matchPrincipals reports whether the principal matches any of the allowed ones where * stands for any sequence of characters
+// The username filling in {{username}} is taken literally, a username carrying * never widens into a pattern
+func matchPrincipals(allowed []string, username, principal string) bool {
+
+	for _, pattern := range allowed {
+
+		segments := strings.Split(pattern, usernameTemplate)
+		for i, segment := range segments {
+
+			parts := strings.Split(segment, "*")
+			for j, part := range parts {
+				parts[j] = regexp.QuoteMeta(part)
+			}
+
+			segments[i] = strings.Join(parts, ".*")
+		}
+
+		reg, err := regexp.Compile("^" + strings.Join(segments, regexp.QuoteMeta(username)) + "$")
+		if err == nil && reg.MatchString(principal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sshRoleTTLs parses the ttl and max ttl of the role falling back to SSHTTL and SSHMaxTTL
+func sshRoleTTLs(role *model.SSHRole) (time.Duration, time.Duration, error) {
+
+	ttl, max := SSHTTL, SSHMaxTTL
+	var err error
+
+	if role.TTL != "" {
+		ttl, err = time.ParseDuration(role.TTL)
+		if err != nil || ttl <= 0 {
+			return 0, 0, ErrBadSSHRole
+		}
+	}
+
+	if role.MaxTTL != "" {
+		max, err = time.ParseDuration(role.MaxTTL)
+		if err != nil || max <= 0 {
+			return 0, 0, ErrBadSSHRole
+		}
+	}
+
+	return ttl, max, nil
+}
+
+func writeSSHEntry(kind, name string, v interface{}, phrase string) error {
+
+	data, err := encryptJSON(v, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataSSH)
+	return sys.MakeEntry(kind, name, data)
+}
+
+// readSSHEntry reads and decrypts the entry into the value, a missing or badly named entry is notFound
+func readSSHEntry(kind, name, phrase string, v interface{}, notFound error) error {
+
+	sys := fs.NewFileSystem(dataSSH)
+
+	data, err := sys.ReadEntry(kind, name)
+	if os.IsNotExist(err) || err == fs.ErrBadInput {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return decryptJSON(data, phrase, v)
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const throttleSweep = 10000
+
+var (
+	// LoginBackoff is the delay after the first failed attempt past the free ones, doubling on every further failure
+	LoginBackoff = time.Second
+	// LoginBackoffMax caps the delay, failures are forgotten once twice as long passed since the last one
+	LoginBackoffMax = 5 * time.Minute
+	// UserFreeAttempts is the number of failed logins of a username allowed before backing off
+	UserFreeAttempts = 3
+	// ClientFreeAttempts is the number of failed logins of a client ip allowed before backing off
+	ClientFreeAttempts = 20
+)
+
+type (
+	// Throttle tracks the failed attempts per key in memory, asking to wait exponentially longer after the free ones
+	Throttle struct {
+		mu       sync.Mutex
+		free     int
+		failures map[string]*failures
+	}
+
+	failures struct {
+		count int
+		last  time.Time
+	}
+)
+
+// NewThrottle returns a Throttle allowing free failed attempts per key before backing off
+func NewThrottle(free int) *Throttle {
+	return &Throttle{free: free, failures: map[string]*failures{}}
+}
+
+// Wait returns how long the key must wait before trying again, zero when it may try right away
+func (t *Throttle) Wait(key string) time.Duration {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[key]
+	if !ok {
+		return 0
+	}
+
+	now := time.Now()
+
+	if f.stale(now) {
+		delete(t.failures, key)
+		return 0
+	}
+
+	wait := f.last.Add(t.backoff(f.count)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// Fail counts a failed attempt of the key returning how long it must wait before trying again
+func (t *Throttle) Fail(key string) time.Duration {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	if len(t.failures) > throttleSweep {
+		t.sweep(now)
+	}
+
+	f, ok := t.failures[key]
+	if !ok || f.stale(now) {
+		f = &failures{}
+		t.failures[key] = f
+	}
+
+	f.count++
+	f.last = now
+
+	return t.backoff(f.count)
+}
+
+// Reset forgets the failed attempts of the key
+func (t *Throttle) Reset(key string) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, key)
+}
+
+// backoff returns the delay after count failures
+func (t *Throttle) backoff(count int) time.Duration {
+
+	if count <= t.free {
+		return 0
+	}
+
+	delay := LoginBackoff
+	for i := t.free + 1; i < count && delay < LoginBackoffMax; i++ {
+		delay *= 2
+	}
+
+	if delay > LoginBackoffMax {
+		return LoginBackoffMax
+	}
+
+	return delay
+}
+
+// sweep drops the failures already forgotten
+func (t *Throttle) sweep(now time.Time) {
+
+	for key, f := range t.failures {
+		if f.stale(now) {
+			delete(t.failures, key)
+		}
+	}
+}
+
+func (f *failures) stale(now time.Time) bool {
+	return now.Sub(f.last) > 2*LoginBackoffMax
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	dataTOTP = "/data/totp"
+	kindTOTP = "totp"
+)
+
+var (
+	// ErrTOTPKeyNotFound is returned when no seed was imported under the name
+	ErrTOTPKeyNotFound = errors.New("totp: key not found")
+	// ErrBadTOTPKey is returned when the request carries both or neither of the url and the key
+	ErrBadTOTPKey = errors.New("totp: either an otpauth url or a base32 key required")
+)
+
+// ImportTOTPKey encrypts the seed of the otpauth url, or the bare key, storing it under the name, an existing seed is replaced
+// The seed is checked by computing a code right away and never shows up again
+func ImportTOTPKey(name string, req *model.TOTPKeyRequest, phrase string) (*model.TOTPKey, error) {
+
+	if (req.URL == "") == (req.Key == "") {
+		return nil, ErrBadTOTPKey
+	}
+
+	var t *algo.TOTP
+	issuer, account := req.Issuer, req.AccountName
+
+	if req.URL != "" {
+
+		var err error
+		t, issuer, account, err = algo.ParseTOTPURI(req.URL)
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+
+		t = algo.NewTOTP(strings.ToUpper(strings.Replace(req.Key, " ", "", -1)))
+
+		if req.Algorithm != "" {
+			t.Algorithm = strings.ToUpper(req.Algorithm)
+		}
+		if req.Digits != 0 {
+			t.Digits = req.Digits
+		}
+		if req.Period != 0 {
+			t.Period = time.Duration(req.Period) * time.Second
+		}
+
+		err := t.Check()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seed := &model.TOTPSeed{
+		TOTPKey: model.TOTPKey{
+			Name:        name,
+			Issuer:      issuer,
+			AccountName: account,
+			Algorithm:   t.Algorithm,
+			Digits:      t.Digits,
+			Period:      int64(t.Period / time.Second),
+		},
+		Secret: t.Secret,
+	}
+
+	data, err := encryptJSON(seed, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sys := fs.NewFileSystem(dataTOTP)
+
+	err = sys.MakeEntry(kindTOTP, name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &seed.TOTPKey, nil
+}
+
+// ReadTOTPKey returns what is shown of the seed imported under the name
+func ReadTOTPKey(name, phrase string) (*model.TOTPKey, error) {
+
+	seed, err := readTOTPSeed(name, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &seed.TOTPKey, nil
+}
+
+// RemoveTOTPKey drops the seed imported under the name
+func RemoveTOTPKey(name string) error {
+
+	sys := fs.NewFileSystem(dataTOTP)
+
+	err := sys.DeleteEntry(kindTOTP, name)
+	if os.IsNotExist(err) {
+		return ErrTOTPKeyNotFound
+	}
+
+	return err
+}
+
+// GenerateTOTPCode returns the current code of the seed imported under the name along with when it expires
+func GenerateTOTPCode(name, phrase string) (*model.TOTPCode, error) {
+
+	seed, err := readTOTPSeed(name, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &algo.TOTP{
+		Secret:    seed.Secret,
+		Digits:    seed.Digits,
+		Period:    time.Duration(seed.Period) * time.Second,
+		Algorithm: seed.Algorithm,
+	}
+
+	now := time.Now()
+
+	code, err := t.Code(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TOTPCode{Code: code, ExpiresAt: (t.Step(now) + 1) * seed.Period}, nil
+}
+
+func readTOTPSeed(name, phrase string) (*model.TOTPSeed, error) {
+
+	sys := fs.NewFileSystem(dataTOTP)
+
+	data, err := sys.ReadEntry(kindTOTP, name)
+	if os.IsNotExist(err) || err == fs.ErrBadInput {
+		return nil, ErrTOTPKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed := &model.TOTPSeed{}
+	err = decryptJSON(data, phrase, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return seed, nil
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NeowayLabs/logger"
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+var logL = logger.Namespace("kripto.login")
+
+var (
+	// ErrUserNotFound is returned when the requested user has no record into the authdb
+	ErrUserNotFound = errors.New("user: not found")
+	// ErrUserExists is returned when creating a user whose record is already into the authdb
+	ErrUserExists = errors.New("user: already exists")
+)
+
+// WriteUser encrypts the user record using the kripto built in passphrase and stores it into the authdb
+func WriteUser(u *model.User, phrase string) error {
+
+	data, err := encryptJSON(u, phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataAuthdb)
+	err = sys.MakeAuth(u.Username, data)
+	return err
+}
+
+// ReadUser retrieves the user record from the authdb and decrypts it
+// A nil user with no error means the record exists but is empty
+func ReadUser(username, phrase string) (*model.User, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	data, err := sys.ReadAuth(username)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	symmetrical := algo.NewSymmetrical()
+
+	b, err := symmetrical.Decrypt(data, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeUser(b)
+}
+
+// CreateUser stores the record of a new user, refusing to replace the one already kept under the same name
+func CreateUser(u *model.User, phrase string) error {
+
+	records.Lock()
+	defer records.Unlock()
+
+	_, err := readUser(u.Username, phrase)
+	if err == nil {
+		return ErrUserExists
+	}
+
+	if err != ErrUserNotFound {
+		return err
+	}
+
+	return WriteUser(u, phrase)
+}
+
+// UpdateUser reads the user record, applies the changes of update and writes it back
+// Nothing is written when update fails, its error is returned as is
+func UpdateUser(username string, update func(*model.User) error, phrase string) (*model.User, error) {
+
+	records.Lock()
+	defer records.Unlock()
+
+	u, err := readUser(username, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	err = update(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, WriteUser(u, phrase)
+}
+
+// RemoveUser drops the user record from the authdb
+func RemoveUser(username string) error {
+
+	records.Lock()
+	defer records.Unlock()
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	err := sys.DeleteAuth(username)
+	if os.IsNotExist(err) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
+
+// ListUsers reads and decrypts every user record found into the authdb
+func ListUsers(phrase string) ([]*model.User, error) {
+
+	sys := fs.NewFileSystem(dataAuthdb)
+
+	names, err := sys.ListAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*model.User{}
+	for _, name := range names {
+
+		u, err := ReadUser(name, phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if u != nil {
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+// decodeUser parses the user record, records written before the json format
+// are kept as username@password@expiration and still understood
+func decodeUser(b []byte) (*model.User, error) {
+
+	u := &model.User{}
+
+	err := json.Unmarshal(b, u)
+	if err == nil {
+		return u, nil
+	}
+
+	output := strings.Split(string(b), "@")
+	if len(output) < 3 {
+		return nil, errors.New("user: bad record format")
+	}
+
+	t, err := strconv.Atoi(output[2])
+	if err != nil {
+		return nil, err
+	}
+
+	u.Username = output[0]
+	u.Password = output[1]
+	u.TokenExpiresIn = time.Duration(t)
+
+	return u, nil
+}
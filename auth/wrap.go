@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	dataWrapped = "/data/wrapped"
+	kindWrapped = "wrapped"
+	wrapSize    = 32
+	wrapPrefix  = "krw_"
+)
+
+var (
+	// WrapMaxTTL is the longest a response may stay wrapped
+	WrapMaxTTL = 24 * time.Hour
+
+	// ErrInvalidWrap is returned when the wrapping token is unknown, already unwrapped or expired
+	ErrInvalidWrap = errors.New("wrap: non valid wrapping token")
+	// ErrBadWrapTTL is returned when the requested ttl is not a positive duration up to WrapMaxTTL
+	ErrBadWrapTTL = errors.New("wrap: bad ttl")
+)
+
+// unwrapping serializes unwraps so a response is never returned twice
+var unwrapping sync.Mutex
+
+// ParseWrapTTL parses the requested wrapping ttl checking it is positive and up to WrapMaxTTL
+func ParseWrapTTL(value string) (time.Duration, error) {
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 || ttl > WrapMaxTTL {
+		return 0, ErrBadWrapTTL
+	}
+
+	return ttl, nil
+}
+
+// Wrap keeps the response encrypted aside returning the single use token that unwraps it
+// The token itself is returned once and only its hash is kept
+func Wrap(creator, path string, status int, body []byte, ttl time.Duration, phrase string) (*model.WrapInfo, error) {
+
+	value, err := algo.RandomHex(wrapSize)
+	if err != nil {
+		return nil, err
+	}
+
+	token := wrapPrefix + value
+	now := time.Now()
+
+	wrapped := &model.Wrapped{
+		ID:           hashSecretID(token),
+		CreationPath: path,
+		Creator:      creator,
+		Status:       status,
+		Body:         body,
+		CreatedAt:    now.Unix(),
+		ExpiresAt:    now.Add(ttl).Unix(),
+	}
+
+	data, err := encryptJSON(wrapped, phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sys := fs.NewFileSystem(dataWrapped)
+
+	err = sys.MakeEntry(kindWrapped, wrapped.ID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.WrapInfo{
+		Token:        token,
+		CreationPath: path,
+		ExpiresAt:    wrapped.ExpiresAt,
+	}, nil
+}
+
+// Unwrap returns the wrapped response destroying it, so it is returned exactly once
+func Unwrap(token, phrase string) (*model.Wrapped, error) {
+
+	unwrapping.Lock()
+	defer unwrapping.Unlock()
+
+	if token == "" {
+		return nil, ErrInvalidWrap
+	}
+
+	id := hashSecretID(token)
+	sys := fs.NewFileSystem(dataWrapped)
+
+	data, err := sys.ReadEntry(kindWrapped, id)
+	if os.IsNotExist(err) {
+		return nil, ErrInvalidWrap
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// destroyed before anything else, a failure past this point loses the response rather than risking a second unwrap
+	err = sys.DeleteEntry(kindWrapped, id)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := &model.Wrapped{}
+
+	err = decryptJSON(data, phrase, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	if wrapped.ExpiresAt < time.Now().Unix() {
+		return nil, ErrInvalidWrap
+	}
+
+	return wrapped, nil
+}
+
+// PruneWrapped drops every expired wrapped response and returns how many were dropped
+func PruneWrapped(phrase string) (int, error) {
+
+	sys := fs.NewFileSystem(dataWrapped)
+	now := time.Now().Unix()
+	pruned := 0
+
+	ids, err := sys.ListEntries(kindWrapped)
+	if err != nil {
+		return pruned, err
+	}
+
+	for _, id := range ids {
+
+		data, err := sys.ReadEntry(kindWrapped, id)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return pruned, err
+		}
+
+		wrapped := &model.Wrapped{}
+
+		err = decryptJSON(data, phrase, wrapped)
+		if err != nil {
+			return pruned, err
+		}
+
+		if wrapped.ExpiresAt > now {
+			continue
+		}
+
+		err = sys.DeleteEntry(kindWrapped, id)
+		if err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
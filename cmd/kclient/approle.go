@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	appRoleAddHelp = "Creates or replaces an app role for machines, keeping its role id! \nThe policies are granted to every token issued through the role. \nExample: approleadd ci-runner ci deploy\n"
+	appRoleDelHelp = "Removes an app role and every secret id issued for it! \nExample: approledel ci-runner\n"
+	appRolesHelp   = "Lists every app role, its role id and policies\n"
+	secretIDHelp   = "Issues a new secret id for an app role, optionally bound to cidrs! \nExample: secretid ci-runner 10.0.0.0/8\n"
+)
+
+func appRoleAdd(args []string) string {
+
+	if len(args) < 1 {
+		return "Missing value! <name> [<policy>...]"
+	}
+
+	role := &model.AppRole{Name: args[0], Policies: args[1:]}
+
+	existing, err := auth.ReadAppRole(role.Name, Phrase)
+	if err == nil {
+		role.RoleID = existing.RoleID
+		role.TokenTTL = existing.TokenTTL
+		role.SecretIDTTL = existing.SecretIDTTL
+		role.SecretIDUses = existing.SecretIDUses
+		role.BoundCIDRs = existing.BoundCIDRs
+	}
+
+	err = auth.WriteAppRole(role, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error adding app role!! %v", err)
+	}
+
+	return fmt.Sprintf("App role added successfully \"%s\" role_id: %s", role.Name, role.RoleID)
+}
+
+func appRoleDel(args []string) string {
+
+	if len(args) != 1 {
+		return "Missing value! <name>"
+	}
+
+	err := auth.RemoveAppRole(args[0], Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error removing app role!! %v", err)
+	}
+
+	return fmt.Sprintf("App role removed successfully \"%s\"", args[0])
+}
+
+func appRoles(args []string) string {
+
+	list, err := auth.ListAppRoles(Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error listing app roles!! %v", err)
+	}
+
+	lines := []string{}
+	for _, role := range list {
+		lines = append(lines, fmt.Sprintf("%s %s %s", role.Name, role.RoleID, strings.Join(role.Policies, " ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func secretID(args []string) string {
+
+	if len(args) < 1 {
+		return "Missing value! <name> [<cidr>...]"
+	}
+
+	secret, err := auth.NewSecretID(args[0], args[1:], Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error issuing secret id!! %v", err)
+	}
+
+	return fmt.Sprintf("Secret id issued successfully \"%s\", it will not be shown again", secret.SecretID)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ffhenkes/kripto/auth"
+)
+
+const keysHelp = "Manages the token signing keys! \nGenerate makes a new key the active one, the former one keeps verifying its tokens until they expire. \nThe valid algorithms are \"EdDSA\" (default), \"ES256\" and \"RS256\". \nExample: keys generate ES256 \nExample: keys list\n"
+
+func keys(args []string) string {
+
+	if len(args) == 0 {
+		return "Missing value! <generate [algorithm]|list>"
+	}
+
+	switch args[0] {
+	case "generate":
+		return keysGenerate(args[1:])
+	case "list":
+		return keysList()
+	default:
+		return fmt.Sprintf("Unknown keys command \"%s\"! Use: generate or list", args[0])
+	}
+}
+
+func keysGenerate(args []string) string {
+
+	if len(args) > 1 {
+		return "Too many arguments! Use: keys generate [algorithm]"
+	}
+
+	algorithm := ""
+	if len(args) == 1 {
+		algorithm = args[0]
+	}
+
+	key, err := auth.RotateKey(algorithm)
+	if err != nil {
+		return fmt.Sprintf("Error generating key!! %v", err)
+	}
+
+	return fmt.Sprintf("Key generated successfully \"%s\" %s", key.ID, key.Algorithm)
+}
+
+func keysList() string {
+
+	ring, err := auth.ReadKeyring()
+	if err != nil {
+		return fmt.Sprintf("Error listing keys!! %v", err)
+	}
+
+	lines := []string{}
+	for _, k := range ring {
+		lines = append(lines, fmt.Sprintf("%s %s %s", k.ID, k.Algorithm, k.Status))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -41,9 +41,15 @@ func main() {
 		logK.Fatal("Critical failure!")
 	}
 
-	err = cli.AddOption("useradd", "Creates a valid user for Kripto! \nOptionally an expiration time for the token can be specified, default expiration time is 24h. \nThe valid units are \"ns\", \"us\" (or \"µs\"), \"ms\", \"s\", \"m\", \"h\". \nExample: useradd username@password 200m\n", func(args []string) string {
+	err = cli.AddOption("useradd", "Creates a valid user for Kripto! \nOptionally an expiration time for the token can be specified, default expiration time is 24h. \nThe valid units are \"ns\", \"us\" (or \"µs\"), \"ms\", \"s\", \"m\", \"h\". \nAppend admin to grant the user access to the user management api. \nExample: useradd username@password 200m admin\n", func(args []string) string {
 		res := ""
 
+		admin := false
+		if len(args) > 1 && args[len(args)-1] == "admin" {
+			admin = true
+			args = args[:len(args)-1]
+		}
+
 		size := len(args)
 
 		if size == 0 {
@@ -90,7 +96,10 @@ func main() {
 		}
 
 		login := auth.NewLogin(&c)
-		ok := login.AddCredentials(Phrase)
+		u := login.User()
+		u.Admin = admin
+
+		ok := auth.WriteUser(u, Phrase)
 		if ok != nil {
 			res = "Error adding new credentials!!"
 			return res
@@ -103,6 +112,33 @@ func main() {
 		logK.Fatal("Critical failure!")
 	}
 
+	options := []struct {
+		name, help string
+		f          func([]string) string
+	}{
+		{"policyadd", policyAddHelp, policyAdd},
+		{"policydel", policyDelHelp, policyDel},
+		{"policies", policiesHelp, policies},
+		{"roleadd", roleAddHelp, roleAdd},
+		{"roledel", roleDelHelp, roleDel},
+		{"roles", rolesHelp, roles},
+		{"grant", grantHelp, grant},
+		{"withdraw", withdrawHelp, withdraw},
+		{"approleadd", appRoleAddHelp, appRoleAdd},
+		{"approledel", appRoleDelHelp, appRoleDel},
+		{"approles", appRolesHelp, appRoles},
+		{"secretid", secretIDHelp, secretID},
+		{"keys", keysHelp, keys},
+		{"mfa", mfaHelp, mfa},
+	}
+
+	for _, o := range options {
+		err = cli.AddOption(o.name, o.help, o.f)
+		if err != nil {
+			logK.Fatal("Critical failure!")
+		}
+	}
+
 	cli.DefaultOption(func(args []string) string {
 		return fmt.Sprintf("%s: command not found, type 'help' for help", args[0])
 	})
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ffhenkes/kripto/auth"
+)
+
+const mfaHelp = "Manages the totp second factor of a user! \nEnroll prints the otpauth uri, render it as a qr code or paste the secret into the authenticator app, along with the recovery codes shown only once. \nConfirm with a code of the app to start asking for codes on login. \nExample: mfa enroll username \nExample: mfa confirm username 123456 \nExample: mfa disable username\n"
+
+func mfa(args []string) string {
+
+	if len(args) < 2 {
+		return "Missing value! <enroll|confirm|disable> <username>"
+	}
+
+	switch args[0] {
+	case "enroll":
+		return mfaEnroll(args[1])
+	case "confirm":
+		if len(args) != 3 {
+			return "Missing value! confirm <username> <code>"
+		}
+		return mfaConfirm(args[1], args[2])
+	case "disable":
+		return mfaDisable(args[1])
+	default:
+		return fmt.Sprintf("Unknown mfa command \"%s\"! Use: enroll, confirm or disable", args[0])
+	}
+}
+
+func mfaEnroll(username string) string {
+
+	enrollment, err := auth.EnrollTOTP(username, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error enrolling user!! %v", err)
+	}
+
+	return fmt.Sprintf("Secret: %s\nURI: %s\nRecovery codes:\n%s", enrollment.Secret, enrollment.URI, strings.Join(enrollment.RecoveryCodes, "\n"))
+}
+
+func mfaConfirm(username, code string) string {
+
+	err := auth.ConfirmTOTP(username, code, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error confirming enrollment!! %v", err)
+	}
+
+	return fmt.Sprintf("Second factor enabled successfully \"%s\"", username)
+}
+
+func mfaDisable(username string) string {
+
+	err := auth.DisableTOTP(username, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error disabling second factor!! %v", err)
+	}
+
+	return fmt.Sprintf("Second factor disabled successfully \"%s\"", username)
+}
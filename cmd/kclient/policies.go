@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	policyAddHelp = "Creates or replaces a policy granting capabilities over apps! \nEach rule is an apps pattern and its comma separated capabilities (read, write, delete, list, admin). \nExample: policyadd payments payments-*=read,list billing=admin\n"
+	policyDelHelp = "Removes a policy! \nExample: policydel payments\n"
+	policiesHelp  = "Lists every policy and its rules\n"
+	roleAddHelp   = "Creates or replaces a role grouping policies! \nExample: roleadd developers payments billing\n"
+	roleDelHelp   = "Removes a role! \nExample: roledel developers\n"
+	rolesHelp     = "Lists every role and its policies\n"
+	grantHelp     = "Grants roles or policies to a user! \nExample: grant username role:developers policy:payments\n"
+	withdrawHelp  = "Withdraws roles or policies from a user! \nExample: withdraw username role:developers policy:payments\n"
+)
+
+func policyAdd(args []string) string {
+
+	if len(args) < 2 {
+		return "Missing value! <name> <apps>=<capability,...>"
+	}
+
+	policy := &model.Policy{Name: args[0]}
+
+	for _, arg := range args[1:] {
+
+		input := strings.Split(arg, "=")
+		if len(input) != 2 || input[0] == "" || input[1] == "" {
+			return "Bad input format! Use: <apps>=<capability,...>"
+		}
+
+		policy.Rules = append(policy.Rules, model.Rule{
+			Apps:         input[0],
+			Capabilities: strings.Split(input[1], ","),
+		})
+	}
+
+	err := auth.WritePolicy(policy, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error adding policy!! %v", err)
+	}
+
+	return fmt.Sprintf("Policy added successfully \"%s\"", policy.Name)
+}
+
+func policyDel(args []string) string {
+
+	if len(args) != 1 {
+		return "Missing value! <name>"
+	}
+
+	err := auth.RemovePolicy(args[0])
+	if err != nil {
+		return fmt.Sprintf("Error removing policy!! %v", err)
+	}
+
+	return fmt.Sprintf("Policy removed successfully \"%s\"", args[0])
+}
+
+func policies(args []string) string {
+
+	list, err := auth.ListPolicies(Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error listing policies!! %v", err)
+	}
+
+	lines := []string{}
+	for _, policy := range list {
+
+		rules := []string{}
+		for _, rule := range policy.Rules {
+			rules = append(rules, fmt.Sprintf("%s=%s", rule.Apps, strings.Join(rule.Capabilities, ",")))
+		}
+
+		lines = append(lines, fmt.Sprintf("%s %s", policy.Name, strings.Join(rules, " ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func roleAdd(args []string) string {
+
+	if len(args) < 2 {
+		return "Missing value! <name> <policy> [<policy>...]"
+	}
+
+	role := &model.Role{Name: args[0], Policies: args[1:]}
+
+	err := auth.WriteRole(role, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error adding role!! %v", err)
+	}
+
+	return fmt.Sprintf("Role added successfully \"%s\"", role.Name)
+}
+
+func roleDel(args []string) string {
+
+	if len(args) != 1 {
+		return "Missing value! <name>"
+	}
+
+	err := auth.RemoveRole(args[0])
+	if err != nil {
+		return fmt.Sprintf("Error removing role!! %v", err)
+	}
+
+	return fmt.Sprintf("Role removed successfully \"%s\"", args[0])
+}
+
+func roles(args []string) string {
+
+	list, err := auth.ListRoles(Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error listing roles!! %v", err)
+	}
+
+	lines := []string{}
+	for _, role := range list {
+		lines = append(lines, fmt.Sprintf("%s %s", role.Name, strings.Join(role.Policies, " ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func grant(args []string) string {
+	return changeGrants(args, true)
+}
+
+func withdraw(args []string) string {
+	return changeGrants(args, false)
+}
+
+// changeGrants adds or removes the role:<name> and policy:<name> arguments from the user record
+func changeGrants(args []string, add bool) string {
+
+	if len(args) < 2 {
+		return "Missing value! <username> role:<name>|policy:<name> [...]"
+	}
+
+	u, err := auth.ReadUser(args[0], Phrase)
+	if err != nil || u == nil {
+		return fmt.Sprintf("Error reading user!! %v", err)
+	}
+
+	for _, arg := range args[1:] {
+
+		input := strings.SplitN(arg, ":", 2)
+		if len(input) != 2 || input[1] == "" {
+			return "Bad input format! Use: role:<name> or policy:<name>"
+		}
+
+		switch input[0] {
+		case "role":
+			u.Roles = toggle(u.Roles, input[1], add)
+		case "policy":
+			u.Policies = toggle(u.Policies, input[1], add)
+		default:
+			return "Bad input format! Use: role:<name> or policy:<name>"
+		}
+	}
+
+	err = auth.WriteUser(u, Phrase)
+	if err != nil {
+		return fmt.Sprintf("Error updating user!! %v", err)
+	}
+
+	return fmt.Sprintf("User updated successfully \"%s\" roles: %v policies: %v", u.Username, u.Roles, u.Policies)
+}
+
+func toggle(values []string, value string, add bool) []string {
+
+	result := []string{}
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+
+	if add {
+		result = append(result, value)
+	}
+
+	return result
+}
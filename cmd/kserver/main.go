@@ -1,12 +1,25 @@
 package main
 
 import (
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/NeowayLabs/logger"
+	"github.com/ffhenkes/kripto/auth"
 	"github.com/ffhenkes/kripto/routes"
-	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	pruneInterval       = time.Hour
+	keysWatchInterval   = 10 * time.Second
+	leaseReaperInterval = time.Minute
+	rotationInterval    = time.Minute
 )
 
 var (
@@ -23,21 +36,199 @@ func main() {
 		key  = os.Getenv("KEY_PATH")
 	)
 
-	// Instantiate a new router
-	r := httprouter.New()
+	// token lifetimes
+	auth.AccessTokenTTL = duration(logH, "KRIPTO_ACCESS_TOKEN_TTL", auth.AccessTokenTTL)
+	auth.RefreshTokenTTL = duration(logH, "KRIPTO_REFRESH_TOKEN_TTL", auth.RefreshTokenTTL)
+
+	// standard claims
+	if issuer := os.Getenv("KRIPTO_ISSUER"); issuer != "" {
+		auth.Issuer = issuer
+	}
+	if audience := os.Getenv("KRIPTO_AUDIENCE"); audience != "" {
+		auth.Audience = audience
+	}
+	if audiences := os.Getenv("KRIPTO_AUDIENCES"); audiences != "" {
+		auth.Audiences = strings.Split(audiences, ",")
+	}
+	auth.ClockSkew = duration(logH, "KRIPTO_CLOCK_SKEW", auth.ClockSkew)
+
+	// brute force protection
+	auth.LoginBackoff = duration(logH, "KRIPTO_LOGIN_BACKOFF", auth.LoginBackoff)
+	auth.LoginBackoffMax = duration(logH, "KRIPTO_LOGIN_BACKOFF_MAX", auth.LoginBackoffMax)
+	auth.LockoutThreshold = number(logH, "KRIPTO_LOCKOUT_THRESHOLD", auth.LockoutThreshold)
+	auth.LockoutDuration = duration(logH, "KRIPTO_LOCKOUT_DURATION", auth.LockoutDuration)
+
+	// api keys
+	auth.APIKeyTTL = duration(logH, "KRIPTO_APIKEY_TTL", auth.APIKeyTTL)
+	auth.APIKeyMaxTTL = duration(logH, "KRIPTO_APIKEY_MAX_TTL", auth.APIKeyMaxTTL)
+
+	// response wrapping
+	auth.WrapMaxTTL = duration(logH, "KRIPTO_WRAP_MAX_TTL", auth.WrapMaxTTL)
+
+	// database credentials
+	auth.DatabaseTTL = duration(logH, "KRIPTO_DATABASE_TTL", auth.DatabaseTTL)
+	auth.DatabaseMaxTTL = duration(logH, "KRIPTO_DATABASE_MAX_TTL", auth.DatabaseMaxTTL)
+
+	// pki
+	auth.PKITTL = duration(logH, "KRIPTO_PKI_TTL", auth.PKITTL)
+	auth.PKIMaxTTL = duration(logH, "KRIPTO_PKI_MAX_TTL", auth.PKIMaxTTL)
+	auth.CRLTTL = duration(logH, "KRIPTO_CRL_TTL", auth.CRLTTL)
+	auth.CRLURL = os.Getenv("KRIPTO_CRL_URL")
+
+	// ssh
+	auth.SSHTTL = duration(logH, "KRIPTO_SSH_TTL", auth.SSHTTL)
+	auth.SSHMaxTTL = duration(logH, "KRIPTO_SSH_MAX_TTL", auth.SSHMaxTTL)
 
 	nr := routes.NewRouter(Phrase)
 
-	// health check
-	r.GET("/v1/health", nr.Health)
-	r.POST("/v1/authenticate", nr.Authenticate)
-	r.POST("/v1/secrets", nr.CreateSecret)
-	r.GET("/v1/secrets", nr.GetSecretsByApp)
-	r.DELETE("/v1/secrets", nr.RemoveSecretsByApp)
+	// generate and persist the signing key on first start
+	if alg := os.Getenv("KRIPTO_SIGNING_ALG"); alg != "" {
+		auth.SigningAlgorithm = alg
+	}
+
+	active, err := auth.EnsureKey()
+	if err != nil {
+		logH.Fatal("Signing key: %v", err)
+	}
+	logH.Info("Signing tokens with %s key %s", active.Algorithm, active.ID)
+
+	// keep the parsed keys in memory, reloading them whenever rotated by another process
+	go auth.WatchKeys(duration(logH, "KRIPTO_KEYS_WATCH_INTERVAL", keysWatchInterval))
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			logH.Info("SIGHUP received! Reloading signing keys")
+			auth.ReloadKeys()
+		}
+	}()
+
+	// drop the revocations, refresh tokens, secret ids, api keys, wrapped responses, certificates and retired keys that are expired anyway
+	// a step failing is logged and does not hold the next ones
+	prunes := []struct {
+		what  string
+		prune func() (int, error)
+	}{
+		{"expired revocations", auth.PruneRevocations},
+		{"expired refresh tokens", func() (int, error) { return auth.PruneRefreshTokens(Phrase) }},
+		{"expired secret ids", func() (int, error) { return auth.PruneSecretIDs(Phrase) }},
+		{"expired api keys", func() (int, error) { return auth.PruneAPIKeys(Phrase) }},
+		{"expired wrapped responses", func() (int, error) { return auth.PruneWrapped(Phrase) }},
+		{"expired certificates", func() (int, error) { return auth.PruneCertificates(Phrase) }},
+		{"retired signing keys", func() (int, error) {
+			lifetime, err := auth.MaxTokenLifetime(Phrase)
+			if err != nil {
+				return 0, err
+			}
+			return auth.PruneKeys(lifetime)
+		}},
+	}
+
+	go func() {
+		for range time.Tick(pruneInterval) {
+			for _, step := range prunes {
+				pruned, err := step.prune()
+				if err != nil {
+					logH.Error("Pruning %s: %v", step.what, err)
+					continue
+				}
+				logH.Info("Pruned %d %s", pruned, step.what)
+			}
+		}
+	}()
+
+	// drop the secrets, the keys and the database users whose lease expired
+	go func() {
+		for range time.Tick(duration(logH, "KRIPTO_LEASE_REAPER_INTERVAL", leaseReaperInterval)) {
+			reaped, err := nr.ReapSecrets()
+			if err != nil {
+				logH.Error("Reaping secrets: %v", err)
+			}
+			if reaped > 0 {
+				logH.Info("Reaped %d expired secrets", reaped)
+			}
+
+			revoked, err := auth.RevokeExpiredDatabaseLeases(Phrase)
+			if err != nil {
+				logH.Error("Revoking database users: %v", err)
+			}
+			if revoked > 0 {
+				logH.Info("Revoked %d expired database users", revoked)
+			}
+		}
+	}()
+
+	// rewrite the keys due for rotation
+	go func() {
+		for range time.Tick(duration(logH, "KRIPTO_ROTATION_INTERVAL", rotationInterval)) {
+			rotated, err := nr.RotateSecrets()
+			if err != nil {
+				logH.Error("Rotating secrets: %v", err)
+			}
+			if rotated > 0 {
+				logH.Info("Rotated the secrets of %d apps", rotated)
+			}
+		}
+	}()
+
+	// Instantiate a new router holding every route
+	r := nr.Routes()
+
+	server := &http.Server{Addr: addr, Handler: r}
+
+	// verify client certificates against the client CA bundle, when configured
+	if ca := os.Getenv("KRIPTO_CLIENT_CA"); ca != "" {
+
+		bundle, err := ioutil.ReadFile(ca)
+		if err != nil {
+			logH.Fatal("Client CA: %v", err)
+		}
+
+		server.TLSConfig, err = auth.ClientTLSConfig(bundle, os.Getenv("KRIPTO_CLIENT_CERT_REQUIRED") == "true")
+		if err != nil {
+			logH.Fatal("Client CA: %v", err)
+		}
+
+		logH.Info("Verifying client certificates against %s", ca)
+	}
 
 	logH.Info("Running on %s", addr)
 
-	if err := http.ListenAndServeTLS(addr, crt, key, r); err != nil {
+	if err := server.ListenAndServeTLS(crt, key); err != nil {
 		logH.Fatal("ListenAndServe: %s", err)
 	}
 }
+
+// duration reads a time duration from the environment falling back to the default one
+func duration(logH *logger.Logger, name string, fallback time.Duration) time.Duration {
+
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logH.Fatal("Bad %s: %s", name, err)
+	}
+
+	return d
+}
+
+// number reads a positive integer from the environment falling back to the default one
+func number(logH *logger.Logger, name string, fallback int) int {
+
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		logH.Fatal("Bad %s: %s", name, value)
+	}
+
+	return n
+}
@@ -6,8 +6,12 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"time"
 )
 
+// ErrBadInput is returned whenever a file name carries bad or malicious characters
+var ErrBadInput = errors.New("sanitize: bad or malicious characters found")
+
 type (
 	// FileSystem represent a type that loads operations that can be performed into the file system.
 	// Such as create, read, delete
@@ -65,6 +69,109 @@ func (fs *FileSystem) DeleteAuth(filename string) error {
 	return err
 }
 
+// ListAuth returns the name of every user found into the authdb directory
+func (fs *FileSystem) ListAuth() ([]string, error) {
+	return list(fs.path, `^\.(.+)\.auth$`)
+}
+
+// MakePolicy creates a file into the authdb directory that contains a policy
+func (fs *FileSystem) MakePolicy(filename string, data []byte) error {
+
+	err := sanitize(filename)
+	if err != nil {
+		return err
+	}
+
+	err = mkdir(fs.path)
+	if err != nil {
+		return err
+	}
+	err = touch(policy(fs.path, filename), data)
+	return err
+}
+
+// ReadPolicy reads the authdb refered policy file
+func (fs *FileSystem) ReadPolicy(filename string) ([]byte, error) {
+
+	err := sanitize(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := read(policy(fs.path, filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return data, err
+}
+
+// DeletePolicy removes the specific policy file
+func (fs *FileSystem) DeletePolicy(filename string) error {
+
+	err := sanitize(filename)
+	if err != nil {
+		return err
+	}
+
+	err = del(policy(fs.path, filename))
+	return err
+}
+
+// ListPolicies returns the name of every policy found into the authdb directory
+func (fs *FileSystem) ListPolicies() ([]string, error) {
+	return list(fs.path, `^\.(.+)\.policy$`)
+}
+
+// MakeRole creates a file into the authdb directory that contains a role
+func (fs *FileSystem) MakeRole(filename string, data []byte) error {
+
+	err := sanitize(filename)
+	if err != nil {
+		return err
+	}
+
+	err = mkdir(fs.path)
+	if err != nil {
+		return err
+	}
+	err = touch(role(fs.path, filename), data)
+	return err
+}
+
+// ReadRole reads the authdb refered role file
+func (fs *FileSystem) ReadRole(filename string) ([]byte, error) {
+
+	err := sanitize(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := read(role(fs.path, filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return data, err
+}
+
+// DeleteRole removes the specific role file
+func (fs *FileSystem) DeleteRole(filename string) error {
+
+	err := sanitize(filename)
+	if err != nil {
+		return err
+	}
+
+	err = del(role(fs.path, filename))
+	return err
+}
+
+// ListRoles returns the name of every role found into the authdb directory
+func (fs *FileSystem) ListRoles() ([]string, error) {
+	return list(fs.path, `^\.(.+)\.role$`)
+}
+
 // ReadKey reads the rsa private key from rsa directory
 func (fs *FileSystem) ReadKey(keyname string) ([]byte, error) {
 
@@ -142,6 +249,108 @@ func (fs *FileSystem) DeleteSecret(filename string) error {
 	return err
 }
 
+// ListSecrets returns the name of every app found into the secrets directory
+func (fs *FileSystem) ListSecrets() ([]string, error) {
+	return list(fs.path, `^(.+)\.secret$`)
+}
+
+// MakeEntry creates a file for an entry of the given kind, e.g. token.revoked
+func (fs *FileSystem) MakeEntry(kind, filename string, data []byte) error {
+
+	err := sanitizeAll(kind, filename)
+	if err != nil {
+		return err
+	}
+
+	err = mkdir(fs.path)
+	if err != nil {
+		return err
+	}
+
+	err = touch(entry(fs.path, kind, filename), data)
+	return err
+}
+
+// ReadEntry reads the file of an entry of the given kind
+func (fs *FileSystem) ReadEntry(kind, filename string) ([]byte, error) {
+
+	err := sanitizeAll(kind, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := read(entry(fs.path, kind, filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return data, err
+}
+
+// DeleteEntry removes the file of an entry of the given kind
+func (fs *FileSystem) DeleteEntry(kind, filename string) error {
+
+	err := sanitizeAll(kind, filename)
+	if err != nil {
+		return err
+	}
+
+	err = del(entry(fs.path, kind, filename))
+	return err
+}
+
+// ListEntries returns the name of every entry of the given kind
+func (fs *FileSystem) ListEntries(kind string) ([]string, error) {
+
+	err := sanitize(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	return list(fs.path, fmt.Sprintf(`^([^.]+)\.%s$`, kind))
+}
+
+// AppendLog appends a new line to the log file kept into the audit directory
+func (fs *FileSystem) AppendLog(filename string, data []byte) error {
+
+	err := sanitize(filename)
+	if err != nil {
+		return err
+	}
+
+	err = mkdir(fs.path)
+	if err != nil {
+		return err
+	}
+
+	err = appendLine(logfile(fs.path, filename), data)
+	return err
+}
+
+// LastModified returns the latest modification time among the base path and the files right under it
+func (fs *FileSystem) LastModified() (time.Time, error) {
+
+	info, err := os.Stat(fs.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	latest := info.ModTime()
+
+	files, err := ioutil.ReadDir(fs.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, f := range files {
+		if f.ModTime().After(latest) {
+			latest = f.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
 // RemovePath drops the base path
 func (fs *FileSystem) RemovePath() error {
 
@@ -169,6 +378,19 @@ func touch(out string, data []byte) error {
 	return err
 }
 
+func appendLine(out string, data []byte) error {
+
+	f, err := os.OpenFile(out, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer closeFile(f)
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
 func read(out string) ([]byte, error) {
 
 	// the annotation below suppress gosec warning
@@ -182,6 +404,35 @@ func read(out string) ([]byte, error) {
 	return data, nil
 }
 
+func list(path, pattern string) ([]string, error) {
+
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := []string{}
+	for _, f := range files {
+
+		match := reg.FindStringSubmatch(f.Name())
+		if f.IsDir() || match == nil {
+			continue
+		}
+
+		names = append(names, match[1])
+	}
+
+	return names, nil
+}
+
 func del(out string) error {
 
 	err := os.Remove(out)
@@ -190,14 +441,31 @@ func del(out string) error {
 
 func sanitize(input string) error {
 
-	reg, err := regexp.Compile("[^a-zA-Z0-9_]+")
+	reg, err := regexp.Compile("[^a-zA-Z0-9_-]+")
 	if err != nil {
 		return err
 	}
 
 	hasMatch := reg.MatchString(input)
 	if hasMatch {
-		return errors.New("sanitize: bad or malicious characters found")
+		return ErrBadInput
+	}
+
+	return nil
+}
+
+func sanitizeAll(inputs ...string) error {
+
+	for _, input := range inputs {
+
+		if input == "" {
+			return ErrBadInput
+		}
+
+		err := sanitize(input)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -215,10 +483,26 @@ func authdb(p, f string) string {
 	return fmt.Sprintf("%s/.%s.auth", p, f)
 }
 
+func policy(p, f string) string {
+	return fmt.Sprintf("%s/.%s.policy", p, f)
+}
+
+func role(p, f string) string {
+	return fmt.Sprintf("%s/.%s.role", p, f)
+}
+
 func secret(p, f string) string {
 	return fmt.Sprintf("%s/%s.secret", p, f)
 }
 
+func entry(p, k, f string) string {
+	return fmt.Sprintf("%s/%s.%s", p, f, k)
+}
+
+func logfile(p, f string) string {
+	return fmt.Sprintf("%s/%s.log", p, f)
+}
+
 func closeFile(f *os.File) {
 	err := f.Close()
 	if err != nil {
@@ -0,0 +1,61 @@
+package model
+
+const (
+	// MethodAPIKey is the auth method of requests sending an api key instead of a token
+	MethodAPIKey = "apikey"
+)
+
+type (
+	// APIKey represents a long lived key of a user, only its hash is kept
+	// It carries a subset of the owner policies and is refused once expired or out of its bound cidrs
+	APIKey struct {
+		ID         string   `json:"id"`
+		Hash       string   `json:"hash"`
+		Name       string   `json:"name"`
+		Owner      string   `json:"owner"`
+		Policies   []string `json:"policies"`
+		BoundCIDRs []string `json:"bound_cidrs,omitempty"`
+		CreatedAt  int64    `json:"created_at"`
+		ExpiresAt  int64    `json:"expires_at"`
+	}
+
+	// APIKeyRequest represents the payload accepted to create an api key
+	// An empty ttl means the default one
+	APIKeyRequest struct {
+		Name       string   `json:"name"`
+		Policies   []string `json:"policies"`
+		TTL        string   `json:"ttl"`
+		BoundCIDRs []string `json:"bound_cidrs"`
+	}
+
+	// APIKeyResponse represents a created api key, the only time it is ever shown
+	APIKeyResponse struct {
+		ID        string `json:"id"`
+		Key       string `json:"key"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+
+	// APIKeyInfo represents the public view of an api key returned by the api
+	APIKeyInfo struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		Owner      string   `json:"owner"`
+		Policies   []string `json:"policies"`
+		BoundCIDRs []string `json:"bound_cidrs,omitempty"`
+		CreatedAt  int64    `json:"created_at"`
+		ExpiresAt  int64    `json:"expires_at"`
+	}
+)
+
+// Info returns the public view of the api key, leaving its hash behind
+func (k *APIKey) Info() *APIKeyInfo {
+	return &APIKeyInfo{
+		ID:         k.ID,
+		Name:       k.Name,
+		Owner:      k.Owner,
+		Policies:   k.Policies,
+		BoundCIDRs: k.BoundCIDRs,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+	}
+}
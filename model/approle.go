@@ -0,0 +1,60 @@
+package model
+
+import "time"
+
+const (
+	// MethodAppRole is the auth method of tokens issued to machines logging in with a role id and secret id
+	MethodAppRole = "approle"
+)
+
+type (
+	// AppRole represents a machine role, its public RoleID is paired with issued secret ids to log in
+	// Tokens issued through the role carry its policies
+	AppRole struct {
+		Name         string        `json:"name"`
+		RoleID       string        `json:"role_id"`
+		Policies     []string      `json:"policies"`
+		TokenTTL     time.Duration `json:"token_ttl"`
+		SecretIDTTL  time.Duration `json:"secret_id_ttl"`
+		SecretIDUses int           `json:"secret_id_uses"`
+		BoundCIDRs   []string      `json:"bound_cidrs,omitempty"`
+	}
+
+	// SecretID represents an issued secret id, only its hash is kept
+	// Zero Uses means unlimited uses, zero ExpiresAt means it never expires
+	SecretID struct {
+		ID         string   `json:"id"`
+		Role       string   `json:"role"`
+		Uses       int      `json:"uses"`
+		ExpiresAt  int64    `json:"expires_at"`
+		BoundCIDRs []string `json:"bound_cidrs,omitempty"`
+	}
+
+	// AppRoleRequest represents the payload accepted to create or replace an app role
+	// Durations use the same units as the token expiration times
+	AppRoleRequest struct {
+		Policies     []string `json:"policies"`
+		TokenTTL     string   `json:"token_ttl"`
+		SecretIDTTL  string   `json:"secret_id_ttl"`
+		SecretIDUses int      `json:"secret_id_uses"`
+		BoundCIDRs   []string `json:"bound_cidrs"`
+	}
+
+	// SecretIDRequest represents the payload accepted to issue a secret id, it may narrow the role bindings
+	SecretIDRequest struct {
+		BoundCIDRs []string `json:"bound_cidrs"`
+	}
+
+	// SecretIDResponse represents an issued secret id, the only time it is ever shown
+	SecretIDResponse struct {
+		SecretID  string `json:"secret_id"`
+		ExpiresAt int64  `json:"expires_at,omitempty"`
+		Uses      int    `json:"uses,omitempty"`
+	}
+
+	// AppRoleLogin represents the payload accepted to log in with an app role
+	AppRoleLogin struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+)
@@ -6,19 +6,237 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
+const (
+	// KeyActive is the status of the single key signing new tokens
+	KeyActive = "active"
+	// KeyRetiring is the status of keys that only verify the tokens they signed until those expire
+	KeyRetiring = "retiring"
+)
+
 type (
 	// Credentials represents the authentication model containing username and password
 	// This model will be embed into the Login and Jwt types
 	Credentials struct {
 		Username       string        `json:"username"`
 		Password       string        `json:"password"`
+		Code           string        `json:"code,omitempty"`
 		TokenExpiresIn time.Duration `json:"token_expires_in"`
+		Audience       string        `json:"-"`
 	}
 
 	// CustomClaims represents the customizable model embed into the Jwt
 	// This one is used to carry on custom data within the token
+	// The embedded StandardClaims.Id is the jti, the unique token id used for revocation
+	// Subject repeats the Username, which is kept for the tokens already issued
+	// Tokens of other auth methods name their Method and carry their Roles and Policies, users get theirs from the authdb
+	// IssuedAtNano is the issue time in nanoseconds, checked against the revocation of every token of the user
 	CustomClaims struct {
 		*jwt.StandardClaims
-		Username string
+		Username     string
+		Method       string   `json:"method,omitempty"`
+		Roles        []string `json:"roles,omitempty"`
+		Policies     []string `json:"policies,omitempty"`
+		IssuedAtNano int64    `json:"iat_ns,omitempty"`
+	}
+
+	// User represents the user record persisted into the authdb
+	// The password and the recovery codes are always kept as a hash, never as plain text
+	// The totp secret only asks for a code on login once the enrollment is confirmed
+	User struct {
+		Username       string        `json:"username"`
+		Password       string        `json:"password"`
+		TokenExpiresIn time.Duration `json:"token_expires_in"`
+		Admin          bool          `json:"admin"`
+		Disabled       bool          `json:"disabled"`
+		Roles          []string      `json:"roles,omitempty"`
+		Policies       []string      `json:"policies,omitempty"`
+		TOTPSecret     string        `json:"totp_secret,omitempty"`
+		TOTPEnabled    bool          `json:"totp_enabled,omitempty"`
+		TOTPLastStep   int64         `json:"totp_last_step,omitempty"`
+		RecoveryCodes  []string      `json:"recovery_codes,omitempty"`
+		FailedLogins   int           `json:"failed_logins,omitempty"`
+		LockedUntil    int64         `json:"locked_until,omitempty"`
+	}
+
+	// TOTPEnrollment represents the totp secret of a user shown once along with its recovery codes
+	TOTPEnrollment struct {
+		Secret        string   `json:"secret"`
+		URI           string   `json:"uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	// TOTPRequest represents the payload accepted to confirm or drop a totp enrollment
+	TOTPRequest struct {
+		Code string `json:"code"`
+	}
+
+	// Principal represents the authenticated identity behind a request
+	Principal struct {
+		Username  string    `json:"username"`
+		Admin     bool      `json:"admin"`
+		Roles     []string  `json:"roles"`
+		Policies  []string  `json:"policies"`
+		Method    string    `json:"method,omitempty"`
+		TokenID   string    `json:"jti,omitempty"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	// WhoAmI represents the authenticated principal along with every policy it holds and how long its credentials live
+	WhoAmI struct {
+		*Principal
+		EffectivePolicies []string `json:"effective_policies"`
+		ExpiresIn         int64    `json:"expires_in,omitempty"`
+	}
+
+	// Introspection represents the state of a token as RFC 7662 defines it, only Active is set for non valid tokens
+	// Scope lists the policies carried by the token, space separated
+	Introspection struct {
+		Active    bool     `json:"active"`
+		Scope     string   `json:"scope,omitempty"`
+		Username  string   `json:"username,omitempty"`
+		TokenType string   `json:"token_type,omitempty"`
+		Exp       int64    `json:"exp,omitempty"`
+		Iat       int64    `json:"iat,omitempty"`
+		Nbf       int64    `json:"nbf,omitempty"`
+		Sub       string   `json:"sub,omitempty"`
+		Aud       string   `json:"aud,omitempty"`
+		Iss       string   `json:"iss,omitempty"`
+		Jti       string   `json:"jti,omitempty"`
+		Method    string   `json:"method,omitempty"`
+		Roles     []string `json:"roles,omitempty"`
+	}
+
+	// IntrospectionRequest represents the token to introspect and the audience it was minted for, kripto by default
+	IntrospectionRequest struct {
+		Token    string `json:"token"`
+		Audience string `json:"audience"`
+	}
+
+	// Revocation represents a revoked token id, or a user whose tokens issued up to RevokedAt are revoked
+	// RevokedAtNano tells apart the tokens issued within the same second as the revocation
+	// It is kept until ExpiresAt, when every token it refers to is expired anyway
+	Revocation struct {
+		ID            string `json:"id"`
+		RevokedAt     int64  `json:"revoked_at"`
+		RevokedAtNano int64  `json:"revoked_at_ns,omitempty"`
+		ExpiresAt     int64  `json:"expires_at"`
+	}
+
+	// RefreshToken represents the server side record of an opaque refresh token, kept by its hash
+	// Every token rotated from the same login shares the Family
+	RefreshToken struct {
+		ID        string `json:"id"`
+		Family    string `json:"family"`
+		Username  string `json:"username"`
+		TokenID   string `json:"jti"`
+		IssuedAt  int64  `json:"issued_at"`
+		ExpiresAt int64  `json:"expires_at"`
+		Used      bool   `json:"used"`
+	}
+
+	// RefreshRequest represents the payload accepted to rotate or drop a refresh token
+	RefreshRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// TokenResponse represents the tokens returned on login and refresh
+	TokenResponse struct {
+		Token            string `json:"token"`
+		ExpiresAt        int64  `json:"expires_at"`
+		RefreshToken     string `json:"refresh_token,omitempty"`
+		RefreshExpiresAt int64  `json:"refresh_expires_at,omitempty"`
+	}
+
+	// ScopedTokenRequest represents the payload accepted to mint a token for another service
+	ScopedTokenRequest struct {
+		Audience       string `json:"audience"`
+		TokenExpiresIn string `json:"token_expires_in"`
+	}
+
+	// SigningKey represents the metadata of a key used to sign tokens
+	// Only the active key signs new tokens while retiring keys still verify the ones they signed
+	SigningKey struct {
+		ID        string `json:"kid"`
+		Algorithm string `json:"alg"`
+		Status    string `json:"status"`
+		CreatedAt int64  `json:"created_at"`
+		RetiredAt int64  `json:"retired_at,omitempty"`
+	}
+
+	// JWK represents a public key in the json web key format
+	JWK struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv,omitempty"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+
+	// KeyRequest represents the payload accepted to rotate the signing key, an empty algorithm means the default one
+	KeyRequest struct {
+		Algorithm string `json:"alg"`
+	}
+
+	// JWKS represents the json web key set published for downstream services to verify kripto tokens
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	// RevokeRequest represents the payload accepted to revoke a single token or every token of a user
+	RevokeRequest struct {
+		TokenID  string `json:"jti"`
+		Username string `json:"username"`
+	}
+
+	// UserRequest represents the payload accepted by the user management api
+	// Optional fields are pointers so a partial update only touches what was sent
+	UserRequest struct {
+		Username       string    `json:"username"`
+		Password       *string   `json:"password,omitempty"`
+		TokenExpiresIn *string   `json:"token_expires_in,omitempty"`
+		Admin          *bool     `json:"admin,omitempty"`
+		Disabled       *bool     `json:"disabled,omitempty"`
+		Roles          *[]string `json:"roles,omitempty"`
+		Policies       *[]string `json:"policies,omitempty"`
+	}
+
+	// UserInfo represents the public view of a user returned by the api
+	UserInfo struct {
+		Username       string   `json:"username"`
+		TokenExpiresIn string   `json:"token_expires_in"`
+		Admin          bool     `json:"admin"`
+		Disabled       bool     `json:"disabled"`
+		Roles          []string `json:"roles"`
+		Policies       []string `json:"policies"`
+		MFA            bool     `json:"mfa"`
+		LockedUntil    int64    `json:"locked_until,omitempty"`
 	}
 )
+
+// Principal returns the authenticated identity of the user
+func (u *User) Principal() *Principal {
+	return &Principal{
+		Username: u.Username,
+		Admin:    u.Admin,
+		Roles:    u.Roles,
+		Policies: u.Policies,
+	}
+}
+
+// Info returns the public view of the user, leaving the password hash behind
+func (u *User) Info() *UserInfo {
+	return &UserInfo{
+		Username:       u.Username,
+		TokenExpiresIn: u.TokenExpiresIn.String(),
+		Admin:          u.Admin,
+		Disabled:       u.Disabled,
+		Roles:          u.Roles,
+		Policies:       u.Policies,
+		MFA:            u.TOTPEnabled,
+		LockedUntil:    u.LockedUntil,
+	}
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+const (
+	// MethodCert is the auth method of clients presenting a certificate signed by the configured client CA
+	MethodCert = "cert"
+)
+
+type (
+	// CertRole maps client certificates to a kripto identity holding policies
+	// A certificate matches when its common name or any of its dns names matches one of the patterns
+	CertRole struct {
+		Name        string        `json:"name"`
+		CommonNames []string      `json:"common_names,omitempty"`
+		DNSNames    []string      `json:"dns_names,omitempty"`
+		Policies    []string      `json:"policies"`
+		TokenTTL    time.Duration `json:"token_ttl"`
+	}
+
+	// CertRoleRequest represents the payload accepted to create or replace a cert role
+	CertRoleRequest struct {
+		CommonNames []string `json:"common_names"`
+		DNSNames    []string `json:"dns_names"`
+		Policies    []string `json:"policies"`
+		TokenTTL    string   `json:"token_ttl"`
+	}
+)
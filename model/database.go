@@ -0,0 +1,53 @@
+package model
+
+const (
+	// PluginPostgreSQL is the plugin of the PostgreSQL connections
+	PluginPostgreSQL = "postgresql"
+)
+
+type (
+	// DatabaseConfig represents the admin connection to a database short lived users are created with
+	// The ConnectionURL may carry {{username}} and {{password}} standing for the Username and Password
+	DatabaseConfig struct {
+		Name          string   `json:"name"`
+		Plugin        string   `json:"plugin"`
+		ConnectionURL string   `json:"connection_url"`
+		Username      string   `json:"username,omitempty"`
+		Password      string   `json:"password,omitempty"`
+		AllowedRoles  []string `json:"allowed_roles"`
+	}
+
+	// DatabaseRole represents the statements creating, renewing and dropping the users handed out through the role
+	// Statements may carry {{name}}, {{password}} and {{expiration}}
+	DatabaseRole struct {
+		Name                 string   `json:"name"`
+		Database             string   `json:"database"`
+		CreationStatements   []string `json:"creation_statements"`
+		RenewStatements      []string `json:"renew_statements,omitempty"`
+		RevocationStatements []string `json:"revocation_statements,omitempty"`
+		DefaultTTL           string   `json:"default_ttl,omitempty"`
+		MaxTTL               string   `json:"max_ttl,omitempty"`
+	}
+
+	// DatabaseCredentials represents a short lived database user along with its lease
+	DatabaseCredentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Lease    *Lease `json:"lease"`
+	}
+
+	// DatabaseLease represents the record kept for every database user handed out, times are unix seconds
+	// The renew and revocation statements are kept along so the user is renewed and dropped even after its role is gone
+	DatabaseLease struct {
+		ID                   string   `json:"lease_id"`
+		Role                 string   `json:"role"`
+		Database             string   `json:"database"`
+		Username             string   `json:"username"`
+		RenewStatements      []string `json:"renew_statements,omitempty"`
+		RevocationStatements []string `json:"revocation_statements"`
+		TTL                  int64    `json:"ttl"`
+		IssuedAt             int64    `json:"issued_at"`
+		ExpiresAt            int64    `json:"expires_at"`
+		MaxExpiresAt         int64    `json:"max_expires_at"`
+	}
+)
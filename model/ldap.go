@@ -0,0 +1,32 @@
+package model
+
+const (
+	// MethodLDAP is the auth method of users logging in with their directory credentials
+	MethodLDAP = "ldap"
+)
+
+type (
+	// LDAPConfig represents the directory users log in against
+	// Users are searched with the service account, the UserFilter receives the escaped username
+	// Groups are searched under GroupBaseDN with the GroupFilter receiving the user dn, or read from memberOf when it is empty
+	LDAPConfig struct {
+		URL            string              `json:"url"`
+		StartTLS       bool                `json:"start_tls"`
+		CACert         string              `json:"ca_cert,omitempty"`
+		BindDN         string              `json:"bind_dn"`
+		BindPassword   string              `json:"bind_password,omitempty"`
+		UserBaseDN     string              `json:"user_base_dn"`
+		UserFilter     string              `json:"user_filter"`
+		GroupBaseDN    string              `json:"group_base_dn,omitempty"`
+		GroupFilter    string              `json:"group_filter,omitempty"`
+		GroupAttribute string              `json:"group_attribute,omitempty"`
+		GroupRoles     map[string][]string `json:"group_roles"`
+		DefaultRoles   []string            `json:"default_roles,omitempty"`
+	}
+
+	// LDAPLogin represents the directory credentials of a user logging in
+	LDAPLogin struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+)
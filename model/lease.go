@@ -0,0 +1,21 @@
+package model
+
+type (
+	// Lease represents how long a secret lives, times are unix seconds
+	// Zero ExpiresAt means only the keys listed in KeyExpiresAt expire
+	// Renewing extends every expiry by its own ttl again
+	Lease struct {
+		ID           string           `json:"lease_id"`
+		TTL          int64            `json:"ttl,omitempty"`
+		ExpiresAt    int64            `json:"expires_at,omitempty"`
+		KeyTTLs      map[string]int64 `json:"key_ttls,omitempty"`
+		KeyExpiresAt map[string]int64 `json:"key_expires_at,omitempty"`
+	}
+
+	// LeaseRequest represents the payload accepted to renew or revoke a lease
+	// An increment renews every expiry by it instead of its own ttl
+	LeaseRequest struct {
+		ID        string `json:"lease_id"`
+		Increment string `json:"increment,omitempty"`
+	}
+)
@@ -0,0 +1,26 @@
+package model
+
+const (
+	// MethodOIDC is the auth method of users logging in with an id token of the configured identity provider
+	MethodOIDC = "oidc"
+)
+
+type (
+	// OIDCConfig represents the identity provider trusted to log users in
+	// Its id tokens are verified against the provider jwks, discovered from the issuer when JWKSURL is empty
+	// Each group found in the GroupsClaim grants the kripto roles mapped to it
+	OIDCConfig struct {
+		Issuer        string              `json:"issuer"`
+		JWKSURL       string              `json:"jwks_url,omitempty"`
+		Audience      string              `json:"audience"`
+		UsernameClaim string              `json:"username_claim"`
+		GroupsClaim   string              `json:"groups_claim"`
+		GroupRoles    map[string][]string `json:"group_roles"`
+		DefaultRoles  []string            `json:"default_roles,omitempty"`
+	}
+
+	// OIDCLogin represents the payload accepted to log in with an id token
+	OIDCLogin struct {
+		IDToken string `json:"id_token"`
+	}
+)
@@ -0,0 +1,93 @@
+package model
+
+const (
+	// KeyTypeEC asks for P-256 ecdsa keys
+	KeyTypeEC = "ec"
+	// KeyTypeRSA asks for 2048 bits rsa keys
+	KeyTypeRSA = "rsa"
+	// KeyTypeEd25519 asks for ed25519 keys
+	KeyTypeEd25519 = "ed25519"
+)
+
+type (
+	// CARequest represents the request generating the kripto CA, or the key and csr of an intermediate, or importing one
+	// PEMBundle carries the CA certificate, its private key and, optionally, the certificates up to the root
+	CARequest struct {
+		CommonName   string `json:"common_name,omitempty"`
+		Organization string `json:"organization,omitempty"`
+		KeyType      string `json:"key_type,omitempty"`
+		TTL          string `json:"ttl,omitempty"`
+		Certificate  string `json:"certificate,omitempty"`
+		PEMBundle    string `json:"pem_bundle,omitempty"`
+	}
+
+	// CA represents the public side of the kripto CA, the CSR shows up only while an intermediate waits to be signed
+	CA struct {
+		Certificate  string   `json:"certificate,omitempty"`
+		CAChain      []string `json:"ca_chain,omitempty"`
+		CSR          string   `json:"csr,omitempty"`
+		SerialNumber string   `json:"serial_number,omitempty"`
+		Expiration   int64    `json:"expiration,omitempty"`
+	}
+
+	// CAKey represents the CA record kept encrypted, the private key never leaves kripto
+	CAKey struct {
+		Certificate string   `json:"certificate,omitempty"`
+		PrivateKey  string   `json:"private_key"`
+		CAChain     []string `json:"ca_chain,omitempty"`
+	}
+
+	// PKIRole represents the constraints on the certificates issued through it
+	// AllowedDomains holds the patterns every name of the certificate must match, * standing for any part of a single label
+	// Wildcard names such as *.example.com are only issued when AllowWildcards is set
+	PKIRole struct {
+		Name           string   `json:"name"`
+		AllowedDomains []string `json:"allowed_domains"`
+		AllowWildcards bool     `json:"allow_wildcards,omitempty"`
+		AllowIPSANs    bool     `json:"allow_ip_sans,omitempty"`
+		ServerFlag     bool     `json:"server_flag,omitempty"`
+		ClientFlag     bool     `json:"client_flag,omitempty"`
+		KeyType        string   `json:"key_type,omitempty"`
+		TTL            string   `json:"ttl,omitempty"`
+		MaxTTL         string   `json:"max_ttl,omitempty"`
+	}
+
+	// IssueRequest represents the names and the ttl asked for a leaf certificate
+	IssueRequest struct {
+		CommonName string   `json:"common_name"`
+		AltNames   []string `json:"alt_names,omitempty"`
+		IPSANs     []string `json:"ip_sans,omitempty"`
+		TTL        string   `json:"ttl,omitempty"`
+	}
+
+	// IssuedCertificate represents a leaf certificate along with its private key, shown only once, and the CA chain
+	IssuedCertificate struct {
+		Certificate  string   `json:"certificate"`
+		PrivateKey   string   `json:"private_key"`
+		IssuingCA    string   `json:"issuing_ca"`
+		CAChain      []string `json:"ca_chain"`
+		SerialNumber string   `json:"serial_number"`
+		Expiration   int64    `json:"expiration"`
+	}
+
+	// CertificateRecord represents the record kept for every leaf issued so it may be revoked, times are unix seconds
+	CertificateRecord struct {
+		SerialNumber string `json:"serial_number"`
+		Role         string `json:"role"`
+		Certificate  string `json:"certificate"`
+		Expiration   int64  `json:"expiration"`
+		RevokedAt    int64  `json:"revoked_at,omitempty"`
+	}
+
+	// CertRevokeRequest represents the serial number, in hex, of the certificate to revoke
+	CertRevokeRequest struct {
+		SerialNumber string `json:"serial_number"`
+	}
+
+	// CRL represents the last revocation list signed by the kripto CA
+	CRL struct {
+		Number     int64  `json:"number"`
+		DER        []byte `json:"der"`
+		NextUpdate int64  `json:"next_update"`
+	}
+)
@@ -0,0 +1,38 @@
+package model
+
+const (
+	// CapabilityRead allows reading the secrets of an app
+	CapabilityRead = "read"
+	// CapabilityWrite allows creating and replacing the secrets of an app
+	CapabilityWrite = "write"
+	// CapabilityDelete allows removing the secrets of an app
+	CapabilityDelete = "delete"
+	// CapabilityList allows seeing that an app has secrets
+	CapabilityList = "list"
+	// CapabilityAdmin implies every other capability over the matching apps
+	CapabilityAdmin = "admin"
+)
+
+// Capabilities holds every capability a rule may grant
+var Capabilities = []string{CapabilityRead, CapabilityWrite, CapabilityDelete, CapabilityList, CapabilityAdmin}
+
+type (
+	// Policy represents a named set of rules granting capabilities over apps
+	Policy struct {
+		Name  string `json:"name"`
+		Rules []Rule `json:"rules"`
+	}
+
+	// Rule grants capabilities over every app matching its pattern
+	// The pattern accepts * as a wildcard, e.g. payments-*
+	Rule struct {
+		Apps         string   `json:"apps"`
+		Capabilities []string `json:"capabilities"`
+	}
+
+	// Role represents a named group of policies that can be granted at once
+	Role struct {
+		Name     string   `json:"name"`
+		Policies []string `json:"policies"`
+	}
+)
@@ -0,0 +1,56 @@
+package model
+
+const (
+	// GeneratorPassword generates passwords out of character classes
+	GeneratorPassword = "password"
+	// GeneratorBytes generates random bytes encoded as base64 or hex
+	GeneratorBytes = "bytes"
+	// GeneratorUUID generates random uuids
+	GeneratorUUID = "uuid"
+)
+
+type (
+	// Generator represents how a value is generated
+	// Length counts characters of passwords and bytes of random data, uuids take no other field
+	// Passwords asking for Words are passphrases out of the WordList, the EFF large word list when empty
+	Generator struct {
+		Type      string   `json:"type"`
+		Length    int      `json:"length,omitempty"`
+		Charset   string   `json:"charset,omitempty"`
+		Classes   []string `json:"classes,omitempty"`
+		Exclude   string   `json:"exclude,omitempty"`
+		Words     int      `json:"words,omitempty"`
+		WordList  []string `json:"word_list,omitempty"`
+		Separator string   `json:"separator,omitempty"`
+		Encoding  string   `json:"encoding,omitempty"`
+	}
+
+	// Generated represents a value generated on request
+	Generated struct {
+		Value string `json:"value"`
+	}
+
+	// KeyRotation represents the schedule rotating a key of an app, times are unix seconds
+	KeyRotation struct {
+		Interval     string    `json:"interval"`
+		Generator    Generator `json:"generator"`
+		LastRotated  int64     `json:"last_rotated,omitempty"`
+		NextRotation int64     `json:"next_rotation"`
+	}
+
+	// Rotation represents the rotation policies of the keys of an app
+	// The Webhook, when set, is told which keys were rotated, never their values
+	Rotation struct {
+		App     string                  `json:"app"`
+		Webhook string                  `json:"webhook,omitempty"`
+		Keys    map[string]*KeyRotation `json:"keys"`
+	}
+
+	// RotationEvent represents the payload posted to the webhook after a rotation
+	RotationEvent struct {
+		App       string   `json:"app"`
+		Keys      []string `json:"keys"`
+		Version   int      `json:"version"`
+		RotatedAt int64    `json:"rotated_at"`
+	}
+)
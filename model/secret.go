@@ -2,8 +2,16 @@ package model
 
 type (
 	// Secret represents the variables attached to an specific app
+	// A ttl for the whole app, or for some of its keys, starts a lease at write time, secrets without one live until removed
+	// Version counts the rotations the secret went through, writes keep it
+	// Keys listed in Generate are filled in by kripto at write time, their values never travel with the request
 	Secret struct {
-		App  string            `json:"app"`
-		Vars map[string]string `json:"vars"`
+		App      string                `json:"app"`
+		Vars     map[string]string     `json:"vars"`
+		TTL      string                `json:"ttl,omitempty"`
+		KeyTTLs  map[string]string     `json:"key_ttls,omitempty"`
+		Generate map[string]*Generator `json:"generate,omitempty"`
+		Lease    *Lease                `json:"lease,omitempty"`
+		Version  int                   `json:"version,omitempty"`
 	}
 )
@@ -0,0 +1,55 @@
+package model
+
+const (
+	// CertTypeUser asks for certificates authenticating users to hosts, the default
+	CertTypeUser = "user"
+	// CertTypeHost asks for certificates authenticating hosts to users
+	CertTypeHost = "host"
+)
+
+type (
+	// SSHCA represents the public key of the kripto SSH CA in authorized_keys format, ready for TrustedUserCAKeys
+	SSHCA struct {
+		PublicKey string `json:"public_key"`
+	}
+
+	// SSHCAKey represents the SSH CA record kept encrypted, the private key never leaves kripto
+	SSHCAKey struct {
+		PrivateKey string `json:"private_key"`
+	}
+
+	// SSHRole represents the constraints on the keys signed through it
+	// AllowedPrincipals holds the patterns, * standing for any sequence and {{username}} for the caller, every principal must match
+	// Critical options and extensions not listed as allowed are refused, the defaults apply when the request asks for none
+	SSHRole struct {
+		Name                   string            `json:"name"`
+		CertType               string            `json:"cert_type,omitempty"`
+		AllowedPrincipals      []string          `json:"allowed_principals"`
+		DefaultPrincipals      []string          `json:"default_principals,omitempty"`
+		AllowedCriticalOptions []string          `json:"allowed_critical_options,omitempty"`
+		DefaultCriticalOptions map[string]string `json:"default_critical_options,omitempty"`
+		AllowedExtensions      []string          `json:"allowed_extensions,omitempty"`
+		DefaultExtensions      map[string]string `json:"default_extensions,omitempty"`
+		TTL                    string            `json:"ttl,omitempty"`
+		MaxTTL                 string            `json:"max_ttl,omitempty"`
+	}
+
+	// SSHSignRequest represents the public key, in authorized_keys format, to be signed along with what the certificate grants
+	SSHSignRequest struct {
+		PublicKey       string            `json:"public_key"`
+		ValidPrincipals []string          `json:"valid_principals,omitempty"`
+		CriticalOptions map[string]string `json:"critical_options,omitempty"`
+		Extensions      map[string]string `json:"extensions,omitempty"`
+		TTL             string            `json:"ttl,omitempty"`
+	}
+
+	// SSHSignedKey represents the certificate signed by the kripto SSH CA in authorized_keys format, times are unix seconds
+	SSHSignedKey struct {
+		SignedKey       string   `json:"signed_key"`
+		SerialNumber    string   `json:"serial_number"`
+		KeyID           string   `json:"key_id"`
+		ValidPrincipals []string `json:"valid_principals"`
+		ValidAfter      int64    `json:"valid_after"`
+		ValidBefore     int64    `json:"valid_before"`
+	}
+)
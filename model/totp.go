@@ -0,0 +1,37 @@
+package model
+
+type (
+	// TOTPKeyRequest represents the seed of a shared account imported either out of its otpauth url or as a base32 key
+	// Algorithm, digits and period only apply to a bare key, the url carries its own
+	TOTPKeyRequest struct {
+		URL         string `json:"url,omitempty"`
+		Key         string `json:"key,omitempty"`
+		Issuer      string `json:"issuer,omitempty"`
+		AccountName string `json:"account_name,omitempty"`
+		Algorithm   string `json:"algorithm,omitempty"`
+		Digits      int    `json:"digits,omitempty"`
+		Period      int64  `json:"period,omitempty"`
+	}
+
+	// TOTPKey represents what is shown of an imported seed, the seed itself never leaves kripto again
+	TOTPKey struct {
+		Name        string `json:"name"`
+		Issuer      string `json:"issuer,omitempty"`
+		AccountName string `json:"account_name,omitempty"`
+		Algorithm   string `json:"algorithm"`
+		Digits      int    `json:"digits"`
+		Period      int64  `json:"period"`
+	}
+
+	// TOTPSeed represents the record kept encrypted for every imported seed
+	TOTPSeed struct {
+		TOTPKey
+		Secret string `json:"secret"`
+	}
+
+	// TOTPCode represents the current code of a seed along with the unix time it stops being valid
+	TOTPCode struct {
+		Code      string `json:"code"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+)
@@ -0,0 +1,26 @@
+package model
+
+type (
+	// Wrapped represents a response kept aside until unwrapped, only the hash of its wrapping token is kept
+	Wrapped struct {
+		ID           string `json:"id"`
+		CreationPath string `json:"creation_path"`
+		Creator      string `json:"creator"`
+		Status       int    `json:"status"`
+		Body         []byte `json:"body"`
+		CreatedAt    int64  `json:"created_at"`
+		ExpiresAt    int64  `json:"expires_at"`
+	}
+
+	// WrapInfo represents the single use wrapping token returned instead of the response
+	WrapInfo struct {
+		Token        string `json:"wrap_token"`
+		CreationPath string `json:"creation_path"`
+		ExpiresAt    int64  `json:"expires_at"`
+	}
+
+	// UnwrapRequest represents the payload accepted to unwrap a response
+	UnwrapRequest struct {
+		Token string `json:"wrap_token"`
+	}
+)
@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const apiKeyHeader = "X-Kripto-Key"
+
+var errNotUser = errors.New("apikey: only users logged in with a token manage api keys")
+
+// CreateAPIKey creates an api key for the authenticated user carrying a subset of its policies
+// The key is shown only once, requests send it in the X-Kripto-Key header
+func (router *Router) CreateAPIKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	principal, ok := router.user(w, r, "apikey.create")
+	if !ok {
+		return
+	}
+
+	req := model.APIKeyRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(principal.Username, "apikey.create", "", err)
+		badRequest(w, err)
+		return
+	}
+
+	key, err := auth.NewAPIKey(principal, &req, router.phrase)
+	if err != nil {
+		audit.Record(principal.Username, "apikey.create", "", err)
+		apiKeyError(w, err)
+		return
+	}
+
+	audit.Record(principal.Username, "apikey.create", key.ID, nil)
+	responseJSON(w, http.StatusCreated, key)
+}
+
+// ListAPIKeys returns the api keys of the authenticated user, admins may ask for the keys of any owner
+func (router *Router) ListAPIKeys(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	principal, ok := router.user(w, r, "apikey.list")
+	if !ok {
+		return
+	}
+
+	owner := principal.Username
+	if o := r.URL.Query().Get("owner"); o != "" && o != owner {
+		if _, ok := router.administrator(w, r, "apikey.list", o); !ok {
+			return
+		}
+		owner = o
+	}
+
+	keys, err := auth.ListAPIKeys(owner, router.phrase)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	infos := []*model.APIKeyInfo{}
+	for _, k := range keys {
+		infos = append(infos, k.Info())
+	}
+
+	responseJSON(w, http.StatusOK, infos)
+}
+
+// RemoveAPIKey revokes an api key of the authenticated user, admins may revoke anyone's
+func (router *Router) RemoveAPIKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	id := p.ByName("id")
+
+	principal, ok := router.user(w, r, "apikey.remove")
+	if !ok {
+		return
+	}
+
+	k, err := auth.ReadAPIKey(id, router.phrase)
+	if err == nil && k.Owner != principal.Username && !principal.Admin {
+		// keys of other users are as good as missing
+		err = auth.ErrAPIKeyNotFound
+	}
+	if err == nil {
+		err = auth.RemoveAPIKey(id)
+	}
+
+	audit.Record(principal.Username, "apikey.remove", id, err)
+	if err != nil {
+		apiKeyError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// apiKeyPrincipal validates the api key and resolves the enabled user owning it
+func (router *Router) apiKeyPrincipal(r *http.Request, key string) (*model.Principal, error) {
+
+	k, err := auth.ValidateAPIKey(key, remoteIP(r), router.phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := router.readUser(k.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Disabled {
+		return nil, errUserDisabled
+	}
+
+	return auth.APIKeyPrincipal(k, u.Principal(), router.phrase)
+}
+
+// user checks whether the authenticated principal is a user logged in with a token
+// On failure the proper status is written, the attempt is audited and false is returned
+func (router *Router) user(w http.ResponseWriter, r *http.Request, action string) (*model.Principal, bool) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		audit.Record("", action, "", errNoPrincipal)
+		unauthorized(w, errNoPrincipal)
+		return nil, false
+	}
+
+	if principal.Method != "" {
+		audit.Record(principal.Username, action, "", errNotUser)
+		forbidden(w, errNotUser)
+		return nil, false
+	}
+
+	return principal, true
+}
+
+// apiKeyError maps the api key errors into the proper response status
+func apiKeyError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrAPIKeyNotFound:
+		notFound(w, err)
+	case auth.ErrBadAPIKey, fs.ErrBadInput:
+		badRequest(w, err)
+	case auth.ErrPolicyNotHeld:
+		forbidden(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,248 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const testKeyOwner = "kripto_automation"
+
+func TestShouldAuthenticateWithAPIKeys(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/payments", adminToken, `{"rules": [{"apps": "payments-*", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/policies/billing", adminToken, `{"rules": [{"apps": "billing", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	c := &model.Credentials{Username: testKeyOwner, Password: "automation", TokenExpiresIn: time.Hour}
+
+	l := auth.NewLogin(c)
+	u := l.User()
+	u.Policies = []string{"payments", "billing"}
+
+	err = auth.WriteUser(u, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, app := range []string{"payments-api", "billing"} {
+		res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "`+app+`", "vars": {"TOKEN": "xyz"}}`)
+		if res.Code != http.StatusCreated {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/apikeys", userToken, `{"name": "ci", "policies": ["admin-everything"]}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Policy not held granted! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/apikeys", userToken, `{"name": "ci", "policies": ["payments"], "ttl": "24h"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	key := model.APIKeyResponse{}
+	err = json.NewDecoder(res.Body).Decode(&key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key.Key, "krk_"+key.ID+"_") {
+		t.Errorf("Bad key format! Got %s", key.Key)
+	}
+
+	res = serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", key.Key, "192.0.2.1", "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Key policy not granted! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	// the owner holds billing but the key does not carry it
+	res = serveKey(router, http.MethodGet, "/v1/secrets?app=billing", key.Key, "192.0.2.1", "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serveKey(router, http.MethodPost, "/v1/auth/apikeys", key.Key, "192.0.2.1", `{"name": "more", "policies": ["payments"]}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Key created another key! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/apikeys", userToken, `{"name": "office", "policies": ["payments"], "bound_cidrs": ["10.0.0.0/8"]}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	bound := model.APIKeyResponse{}
+	err = json.NewDecoder(res.Body).Decode(&bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", bound.Key, "192.0.2.1", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("Key used out of its cidrs! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	if res := serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", bound.Key, "10.1.2.3", ""); res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	forged := key.Key[:len(key.Key)-4] + "beef"
+	if res := serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", forged, "192.0.2.1", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("Forged key accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/auth/apikeys", userToken, "")
+	infos := []model.APIKeyInfo{}
+	err = json.NewDecoder(bytes.NewReader(res.Body.Bytes())).Decode(&infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 2 || strings.Contains(res.Body.String(), "hash") {
+		t.Errorf("Bad keys listed! Got %s", res.Body.String())
+	}
+
+	// removing the policy from the owner removes it from its keys as well
+	u.Policies = []string{"billing"}
+	err = auth.WriteUser(u, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", key.Key, "192.0.2.1", ""); res.Code != http.StatusForbidden {
+		t.Errorf("Policy withdrawn from the owner still granted! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/auth/apikeys/"+key.ID, userToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	if res := serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", key.Key, "192.0.2.1", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("Revoked key accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/auth/apikeys/"+bound.ID, adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	for _, app := range []string{"payments-api", "billing"} {
+		err = sys.DeleteSecret(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRemoveAPIKeysWithTheOwner(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/payments", adminToken, `{"rules": [{"apps": "payments-*", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	c := &model.Credentials{Username: testKeyOwner, Password: "automation", TokenExpiresIn: time.Hour}
+
+	u := auth.NewLogin(c).User()
+	u.Policies = []string{"payments"}
+
+	err = auth.WriteUser(u, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/apikeys", userToken, `{"name": "ci", "policies": ["payments"]}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	key := model.APIKeyResponse{}
+	err = json.NewDecoder(res.Body).Decode(&key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/users/"+testKeyOwner, adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	// a user created later under the same name does not inherit the key
+	err = auth.WriteUser(u, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serveKey(router, http.MethodGet, "/v1/secrets?app=payments-api", key.Key, "192.0.2.1", "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Key of a removed owner accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	keys, err := auth.ListAPIKeys(testKeyOwner, testPassphrase)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("Keys of a removed owner kept! Got %v %v", keys, err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveKey sends the request through every kripto route and middleware using the api key from the client ip
+func serveKey(router *Router, method, url, key, ip, body string) *httptest.ResponseRecorder {
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	req.Header.Add("X-Kripto-Key", key)
+	req.RemoteAddr = ip + ":40000"
+
+	router.Routes().ServeHTTP(res, req)
+	return res
+}
@@ -0,0 +1,231 @@
+package routes
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// AppRoleLogin trades a role id and secret id for a token carrying the app role policies
+func (router *Router) AppRoleLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	login := model.AppRoleLogin{}
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	session, err := auth.LoginAppRole(&login, remoteIP(r), router.phrase)
+	if err == auth.ErrInvalidSecretID {
+		audit.Record("", "auth.approle.login", "", err)
+		unauthorized(w, err)
+		return
+	}
+	if err != nil {
+		audit.Record("", "auth.approle.login", "", err)
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, session)
+}
+
+// WriteAppRole creates or replaces the app role named in the path keeping its role id, only admins are allowed to do so
+func (router *Router) WriteAppRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "approle.write", name)
+	if !ok {
+		return
+	}
+
+	req := model.AppRoleRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "approle.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	role, err := appRoleFromRequest(name, &req)
+	if err != nil {
+		audit.Record(actor, "approle.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	existing, err := auth.ReadAppRole(name, router.phrase)
+	if err != nil && err != auth.ErrAppRoleNotFound {
+		audit.Record(actor, "approle.write", name, err)
+		appRoleError(w, err)
+		return
+	}
+
+	if existing != nil {
+		role.RoleID = existing.RoleID
+	}
+
+	err = auth.WriteAppRole(role, router.phrase)
+	audit.Record(actor, "approle.write", name, err)
+	if err != nil {
+		appRoleError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// GetAppRole returns the app role named in the path along with its role id, only admins are allowed to do so
+func (router *Router) GetAppRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "approle.read", name)
+	if !ok {
+		return
+	}
+
+	role, err := auth.ReadAppRole(name, router.phrase)
+	audit.Record(actor, "approle.read", name, err)
+	if err != nil {
+		appRoleError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// ListAppRoles returns every app role, only admins are allowed to do so
+func (router *Router) ListAppRoles(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "approle.list", "")
+	if !ok {
+		return
+	}
+
+	roles, err := auth.ListAppRoles(router.phrase)
+	audit.Record(actor, "approle.list", "", err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, roles)
+}
+
+// RemoveAppRole drops the app role named in the path and its secret ids, only admins are allowed to do so
+// Tokens issued through the app role are refused from then on
+func (router *Router) RemoveAppRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "approle.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveAppRole(name, router.phrase)
+	audit.Record(actor, "approle.delete", name, err)
+	if err != nil {
+		appRoleError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// IssueSecretID issues a new secret id for the app role named in the path, only admins are allowed to do so
+func (router *Router) IssueSecretID(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "approle.secretid", name)
+	if !ok {
+		return
+	}
+
+	req := model.SecretIDRequest{}
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			audit.Record(actor, "approle.secretid", name, err)
+			badRequest(w, err)
+			return
+		}
+	}
+
+	secret, err := auth.NewSecretID(name, req.BoundCIDRs, router.phrase)
+	audit.Record(actor, "approle.secretid", name, err)
+	if err != nil {
+		appRoleError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, secret)
+}
+
+// appRoleFromRequest parses the durations of the request into an app role
+func appRoleFromRequest(name string, req *model.AppRoleRequest) (*model.AppRole, error) {
+
+	role := &model.AppRole{
+		Name:         name,
+		Policies:     req.Policies,
+		SecretIDUses: req.SecretIDUses,
+		BoundCIDRs:   req.BoundCIDRs,
+	}
+
+	var err error
+
+	if req.TokenTTL != "" {
+		role.TokenTTL, err = time.ParseDuration(req.TokenTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.SecretIDTTL != "" {
+		role.SecretIDTTL, err = time.ParseDuration(req.SecretIDTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return role, nil
+}
+
+// remoteIP returns the ip the request came from
+func remoteIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// appRoleError maps the app role errors into the proper response status
+func appRoleError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrAppRoleNotFound:
+		notFound(w, err)
+	case auth.ErrBadAppRole, fs.ErrBadInput:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
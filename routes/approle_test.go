@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldLoginWithAppRole(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/ci", adminToken, `{"rules": [{"apps": "ci-*", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/approles/runner", adminToken, `{"policies": ["ci"], "token_ttl": "10m", "secret_id_ttl": "1h", "secret_id_uses": 2, "bound_cidrs": ["192.0.2.0/24"]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	role := model.AppRole{}
+	err = json.NewDecoder(res.Body).Decode(&role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/approles/runner/secret-id", adminToken, "")
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	secret := model.SecretIDResponse{}
+	err = json.NewDecoder(res.Body).Decode(&secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, app := range []string{"ci-build", "billing"} {
+		res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "`+app+`", "vars": {"TOKEN": "xyz"}}`)
+		if res.Code != http.StatusCreated {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+		}
+	}
+
+	login := `{"role_id": "` + role.RoleID + `", "secret_id": "` + secret.SecretID + `"}`
+
+	res = appRoleLogin(router, login)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	session := decodeSession(t, res.Body)
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=ci-build", session.Token, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("App role policy not granted! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=billing", session.Token, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/users", session.Token, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("App role reached the admin api! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	// the secret id is good for two logins only
+	res = appRoleLogin(router, login)
+	if res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = appRoleLogin(router, login)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Used up secret id accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/approles/runner", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=ci-build", session.Token, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Token of a removed app role accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	for _, app := range []string{"ci-build", "billing"} {
+		err = sys.DeleteSecret(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotLoginOutOfBoundCIDR(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/approles/deployer", adminToken, `{"policies": []}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	role := model.AppRole{}
+	err = json.NewDecoder(res.Body).Decode(&role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/approles/deployer/secret-id", adminToken, `{"bound_cidrs": ["10.0.0.0/8"]}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	secret := model.SecretIDResponse{}
+	err = json.NewDecoder(res.Body).Decode(&secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = appRoleLogin(router, `{"role_id": "`+role.RoleID+`", "secret_id": "`+secret.SecretID+`"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Login out of the bound cidr accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = appRoleLogin(router, `{"role_id": "`+role.RoleID+`", "secret_id": "krs_guess"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad secret id accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/approles/deployer", adminToken, `{"bound_cidrs": ["not-a-cidr"]}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// appRoleLogin sends the login from 192.0.2.1, the remote address set by httptest
+func appRoleLogin(router *Router, body string) *httptest.ResponseRecorder {
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/approle/login", strings.NewReader(body))
+
+	router.Routes().ServeHTTP(res, req)
+	return res
+}
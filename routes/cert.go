@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CertLogin trades the verified client certificate for a token carrying the policies of the matching cert role
+func (router *Router) CertLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	cert := clientCertificate(r)
+	if cert == nil {
+		audit.Record("", "auth.cert.login", "", errNoCertificate)
+		unauthorized(w, errNoCertificate)
+		return
+	}
+
+	session, err := auth.LoginCert(cert, router.phrase)
+	audit.Record(cert.Subject.CommonName, "auth.cert.login", "", err)
+	if err == auth.ErrCertNotMapped {
+		unauthorized(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, session)
+}
+
+// WriteCertRole creates or replaces the cert role named in the path, only admins are allowed to do so
+func (router *Router) WriteCertRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "certrole.write", name)
+	if !ok {
+		return
+	}
+
+	req := model.CertRoleRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "certrole.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	role := &model.CertRole{
+		Name:        name,
+		CommonNames: req.CommonNames,
+		DNSNames:    req.DNSNames,
+		Policies:    req.Policies,
+	}
+
+	if req.TokenTTL != "" {
+		role.TokenTTL, err = time.ParseDuration(req.TokenTTL)
+		if err != nil {
+			audit.Record(actor, "certrole.write", name, err)
+			badRequest(w, err)
+			return
+		}
+	}
+
+	err = auth.WriteCertRole(role, router.phrase)
+	audit.Record(actor, "certrole.write", name, err)
+	if err != nil {
+		certRoleError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// GetCertRole returns the cert role named in the path, only admins are allowed to do so
+func (router *Router) GetCertRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "certrole.read", name)
+	if !ok {
+		return
+	}
+
+	role, err := auth.ReadCertRole(name, router.phrase)
+	audit.Record(actor, "certrole.read", name, err)
+	if err != nil {
+		certRoleError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// ListCertRoles returns every cert role, only admins are allowed to do so
+func (router *Router) ListCertRoles(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "certrole.list", "")
+	if !ok {
+		return
+	}
+
+	roles, err := auth.ListCertRoles(router.phrase)
+	audit.Record(actor, "certrole.list", "", err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, roles)
+}
+
+// RemoveCertRole drops the cert role named in the path, only admins are allowed to do so
+func (router *Router) RemoveCertRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "certrole.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveCertRole(name)
+	audit.Record(actor, "certrole.delete", name, err)
+	if err != nil {
+		certRoleError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// certRoleError maps the cert role errors into the proper response status
+func certRoleError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrCertRoleNotFound:
+		notFound(w, err)
+	case auth.ErrBadCertRole, fs.ErrBadInput:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+)
+
+func TestShouldAuthenticateClientCertificates(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/billing", adminToken, `{"rules": [{"apps": "billing", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/certroles/billing-svc", adminToken, `{"common_names": ["billing.*"], "policies": ["billing"]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/certroles/empty", adminToken, `{"policies": ["billing"]}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Cert role without patterns accepted! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "billing", "vars": {"TOKEN": "xyz"}}`)
+	if res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	ca, caKey, caPEM := testCertificate(t, "kripto test ca", nil, nil)
+	billing, billingKey, _ := testCertificate(t, "billing.internal", ca, caKey)
+	other, otherKey, _ := testCertificate(t, "other.internal", ca, caKey)
+	rogueCA, rogueKey, _ := testCertificate(t, "rogue ca", nil, nil)
+	rogue, rogueLeafKey, _ := testCertificate(t, "billing.internal", rogueCA, rogueKey)
+
+	server := httptest.NewUnstartedServer(router.Routes())
+	server.TLS, err = auth.ClientTLSConfig(caPEM, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// direct access authenticated by the certificate alone
+	status := tlsRequest(t, server, billing, billingKey, http.MethodGet, "/v1/secrets?app=billing")
+	if status != http.StatusOK {
+		t.Errorf("Mapped certificate refused! Got %v expected %v", status, http.StatusOK)
+	}
+
+	status = tlsRequest(t, server, other, otherKey, http.MethodGet, "/v1/secrets?app=billing")
+	if status != http.StatusUnauthorized {
+		t.Errorf("Unmapped certificate accepted! Got %v expected %v", status, http.StatusUnauthorized)
+	}
+
+	status = tlsRequest(t, server, nil, nil, http.MethodPost, "/v1/auth/cert/login")
+	if status != http.StatusUnauthorized {
+		t.Errorf("Login without certificate accepted! Got %v expected %v", status, http.StatusUnauthorized)
+	}
+
+	status = tlsRequest(t, server, rogue, rogueLeafKey, http.MethodGet, "/v1/secrets?app=billing")
+	if status != 0 {
+		t.Errorf("Certificate of another CA accepted! Got %v", status)
+	}
+
+	// the login token carries the cert role policies
+	client := tlsClient(server, billing, billingKey)
+
+	resp, err := client.Post(server.URL+"/v1/auth/cert/login", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	session := decodeSession(t, resp.Body)
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=billing", session.Token, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Cert login token refused! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/certroles/billing-svc", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=billing", session.Token, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Token of a removed cert role accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = fs.NewFileSystem(dataSecrets).DeleteSecret("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCertificate creates a certificate signed by the parent, or a self signed CA when there is no parent
+func testCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// tlsClient returns a client trusting the test server and presenting the certificate, if any
+func tlsClient(server *httptest.Server, cert *x509.Certificate, key *ecdsa.PrivateKey) *http.Client {
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+
+	// always present the certificate, even when its CA is not one the server asked for
+	if cert != nil {
+		transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}, nil
+		}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// tlsRequest sends the request presenting the certificate and returns the status, zero when the handshake fails
+func tlsRequest(t *testing.T, server *httptest.Server, cert *x509.Certificate, key *ecdsa.PrivateKey, method, url string) int {
+
+	client := tlsClient(server, cert, key)
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest(method, server.URL+url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	defer closeBody(resp)
+
+	return resp.StatusCode
+}
+
+func closeBody(resp *http.Response) {
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,214 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// WriteDatabaseConfig creates or replaces the database connection named in the path, only admins are allowed to do so
+func (router *Router) WriteDatabaseConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "database.config.write", name)
+	if !ok {
+		return
+	}
+
+	config := model.DatabaseConfig{}
+
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		audit.Record(actor, "database.config.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	config.Name = name
+
+	err = auth.WriteDatabaseConfig(&config, router.phrase)
+	audit.Record(actor, "database.config.write", name, err)
+	if err == auth.ErrDatabaseUnreachable {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	config.Password = ""
+	responseJSON(w, http.StatusOK, config)
+}
+
+// GetDatabaseConfig returns the database connection named in the path without its password, only admins are allowed to do so
+func (router *Router) GetDatabaseConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "database.config.read", name)
+	if !ok {
+		return
+	}
+
+	config, err := auth.ReadDatabaseConfig(name, router.phrase)
+	audit.Record(actor, "database.config.read", name, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	config.Password = ""
+	responseJSON(w, http.StatusOK, config)
+}
+
+// RemoveDatabaseConfig drops the database connection named in the path, only admins are allowed to do so
+func (router *Router) RemoveDatabaseConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "database.config.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveDatabaseConfig(name, router.phrase)
+	audit.Record(actor, "database.config.delete", name, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// WriteDatabaseRole creates or replaces the database role named in the path, only admins are allowed to do so
+func (router *Router) WriteDatabaseRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "database.role.write", name)
+	if !ok {
+		return
+	}
+
+	role := model.DatabaseRole{}
+
+	err := json.NewDecoder(r.Body).Decode(&role)
+	if err != nil {
+		audit.Record(actor, "database.role.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	role.Name = name
+
+	err = auth.WriteDatabaseRole(&role, router.phrase)
+	audit.Record(actor, "database.role.write", name, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// GetDatabaseRole returns the database role named in the path, only admins are allowed to do so
+func (router *Router) GetDatabaseRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "database.role.read", name)
+	if !ok {
+		return
+	}
+
+	role, err := auth.ReadDatabaseRole(name, router.phrase)
+	audit.Record(actor, "database.role.read", name, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// RemoveDatabaseRole drops the database role named in the path, only admins are allowed to do so
+func (router *Router) RemoveDatabaseRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "database.role.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveDatabaseRole(name)
+	audit.Record(actor, "database.role.delete", name, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// IssueDatabaseCredentials creates a short lived database user through the role named in the path
+// Whoever may read the database/<role> app is allowed to do so, the optional ttl query asks for a shorter or longer lease
+func (router *Router) IssueDatabaseCredentials(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("role")
+	app := auth.DatabaseLeasePrefix + name
+
+	actor, ok := router.allowed(w, r, "database.creds", app, model.CapabilityRead)
+	if !ok {
+		return
+	}
+
+	var ttl time.Duration
+	if value := r.URL.Query().Get("ttl"); value != "" {
+
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			audit.Record(actor, "database.creds", app, errBadTTL)
+			badRequest(w, errBadTTL)
+			return
+		}
+	}
+
+	creds, err := auth.IssueDatabaseCredentials(name, ttl, router.phrase)
+	if err != nil {
+		audit.Record(actor, "database.creds", app, err)
+		databaseError(w, err)
+		return
+	}
+
+	audit.Record(actor, "database.creds", creds.Lease.ID, nil)
+	responseJSON(w, http.StatusOK, creds)
+}
+
+// databaseError maps the database engine errors into the proper response status
+func databaseError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrDatabaseNotFound, auth.ErrDatabaseRoleNotFound, auth.ErrDatabaseLeaseNotFound:
+		notFound(w, err)
+	case auth.ErrBadDatabaseConfig, auth.ErrBadDatabaseRole, fs.ErrBadInput:
+		badRequest(w, err)
+	case auth.ErrDatabaseRoleNotAllowed:
+		forbidden(w, err)
+	case auth.ErrDatabaseInUse:
+		conflict(w, err)
+	default:
+		serverError(w, err)
+	}
+}
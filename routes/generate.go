@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultPasswordLength = 20
+	defaultBytesLength    = 32
+)
+
+var (
+	errUnknownGenerator = errors.New("generate: unknown generator")
+	errGeneratedVar     = errors.New("generate: key both sent and generated")
+)
+
+// GeneratePassword returns a random password, or passphrase when asking for words, any authenticated principal is allowed to do so
+func (router *Router) GeneratePassword(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	router.generated(w, r, model.GeneratorPassword)
+}
+
+// GenerateBytes returns random bytes encoded as base64 or hex, any authenticated principal is allowed to do so
+func (router *Router) GenerateBytes(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	router.generated(w, r, model.GeneratorBytes)
+}
+
+// generated decodes the optional generator of the request and answers with a value of the kind
+func (router *Router) generated(w http.ResponseWriter, r *http.Request, kind string) {
+
+	g := model.Generator{}
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&g)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+	}
+
+	g.Type = kind
+
+	value, err := generate(&g)
+	if err != nil {
+		generateError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, model.Generated{Value: value})
+}
+
+// generateVars fills in the keys the secret asks kripto to generate
+func generateVars(s *model.Secret) error {
+
+	if len(s.Generate) == 0 {
+		return nil
+	}
+
+	if s.Vars == nil {
+		s.Vars = map[string]string{}
+	}
+
+	for key, g := range s.Generate {
+
+		if _, ok := s.Vars[key]; ok || g == nil {
+			return errGeneratedVar
+		}
+
+		value, err := generate(g)
+		if err != nil {
+			return err
+		}
+
+		s.Vars[key] = value
+	}
+
+	s.Generate = nil
+	return nil
+}
+
+// generate returns a new value out of the generator, passwords default to 20 characters and random data to 32 bytes
+func generate(g *model.Generator) (string, error) {
+
+	switch g.Type {
+	case model.GeneratorPassword:
+
+		if g.Words > 0 {
+			return algo.GeneratePassphrase(algo.PassphraseRules{Words: g.Words, WordList: g.WordList, Separator: g.Separator})
+		}
+
+		length := g.Length
+		if length == 0 {
+			length = defaultPasswordLength
+		}
+
+		return algo.GeneratePassword(algo.PasswordRules{Length: length, Charset: g.Charset, Classes: g.Classes, Exclude: g.Exclude})
+
+	case model.GeneratorBytes:
+
+		length := g.Length
+		if length == 0 {
+			length = defaultBytesLength
+		}
+
+		return algo.GenerateBytes(length, g.Encoding)
+
+	case model.GeneratorUUID:
+		return algo.GenerateUUID()
+
+	default:
+		return "", errUnknownGenerator
+	}
+}
+
+// generateError maps the generator errors into the proper response status
+func generateError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case errUnknownGenerator, errGeneratedVar, algo.ErrBadLength, algo.ErrUnknownClass, algo.ErrUnknownEncoding, algo.ErrEmptyCharset, algo.ErrBadWords:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldGenerateValues(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/sys/generate/password", "", "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	for body, pattern := range map[string]string{
+		``: `^.{20}$`,
+		`{"length": 40, "classes": ["lower", "digit"]}`:                 `^[a-z0-9]{40}$`,
+		`{"length": 30, "charset": "abcdef", "exclude": "ef"}`:          `^[a-d]{30}$`,
+		`{"length": 12, "classes": ["upper"], "exclude": "ABCDEFGHIJ"}`: `^[K-Z]{12}$`,
+		`{"words": 5, "separator": " "}`:                                `^[a-z-]+( [a-z-]+){4}$`,
+		`{"words": 3, "word_list": ["alpha", "beta"]}`:                  `^(alpha|beta)(-(alpha|beta)){2}$`,
+	} {
+		res = serve(router, http.MethodPost, "/v1/sys/generate/password", adminToken, body)
+		if res.Code != http.StatusOK {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusOK)
+			continue
+		}
+
+		generated := model.Generated{}
+		err = json.NewDecoder(res.Body).Decode(&generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !regexp.MustCompile(pattern).MatchString(generated.Value) {
+			t.Errorf("Bad password for %s! Got %s", body, generated.Value)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/sys/generate/bytes", adminToken, `{"length": 16, "encoding": "hex"}`)
+	if res.Code != http.StatusOK || !regexp.MustCompile(`^{"value":"[0-9a-f]{32}"}`).MatchString(res.Body.String()) {
+		t.Errorf("Bad hex bytes! Got %v %s", res.Code, res.Body.String())
+	}
+
+	res = serve(router, http.MethodPost, "/v1/sys/generate/bytes", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	generated := model.Generated{}
+	err = json.NewDecoder(res.Body).Decode(&generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(generated.Value)
+	if err != nil || len(b) != 32 {
+		t.Errorf("Bad base64 bytes! Got %s", generated.Value)
+	}
+
+	for path, body := range map[string]string{
+		"/v1/sys/generate/password": `{"length": 2000}`,
+		"/v1/sys/generate/bytes":    `{"encoding": "base32"}`,
+	} {
+		res = serve(router, http.MethodPost, path, adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	for _, body := range []string{
+		`{"length": 8, "classes": ["digit"], "exclude": "0123456789"}`,
+		`{"length": 8, "classes": ["lower"], "charset": "0123"}`,
+		`{"words": 100}`,
+		`{"words": 4, "word_list": ["lonely"]}`,
+	} {
+		res = serve(router, http.MethodPost, "/v1/sys/generate/password", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldCreateSecretWithGeneratedValues(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "generated-app", "vars": {"DB_PASSWD": "sent"},
+		"generate": {"DB_PASSWD": {"type": "password"}}}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "generated-app", "vars": {"DB_USER": "orders"},
+		"generate": {"DB_PASSWD": {"type": "password", "length": 32, "classes": ["lower", "upper", "digit"]}, "SESSION_KEY": {"type": "bytes"}},
+		"key_ttls": {"SESSION_KEY": "24h"}}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	if res.Body.Len() != 0 {
+		t.Errorf("Generated values disclosed! %s", res.Body.String())
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=generated-app", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if strings.Contains(res.Body.String(), `"generate"`) {
+		t.Errorf("Generators stored along with the secret! %s", res.Body.String())
+	}
+
+	secret, err := decodeSecret(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if secret.Vars["DB_USER"] != "orders" || !regexp.MustCompile(`^[a-zA-Z0-9]{32}$`).MatchString(secret.Vars["DB_PASSWD"]) {
+		t.Errorf("Bad generated secret! Got %+v", secret.Vars)
+	}
+
+	if secret.Vars["SESSION_KEY"] == "" || secret.Lease == nil || secret.Lease.KeyTTLs["SESSION_KEY"] != 86400 {
+		t.Errorf("Bad generated key lease! Got %+v", secret.Lease)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,11 +2,15 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/NeowayLabs/logger"
-	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/audit"
 	"github.com/ffhenkes/kripto/auth"
 	"github.com/ffhenkes/kripto/fs"
 	"github.com/ffhenkes/kripto/model"
@@ -16,20 +20,33 @@ import (
 
 var logR = logger.Namespace("kripto.router")
 
+var (
+	errThrottled      = errors.New("login: too many failed attempts, try again later")
+	errBadCredentials = errors.New("login: bad credentials")
+)
+
 const (
 	dataSecrets = "/data/secrets"
+	mfaHeader   = "X-Kripto-MFA"
 )
 
 type (
 	// Router represents the http api router that embed the built in passphrase for encryption
+	// Failed logins are throttled per username and per client ip
 	Router struct {
-		phrase string
+		phrase  string
+		users   *auth.Throttle
+		clients *auth.Throttle
 	}
 )
 
 // NewRouter returns an http Router reference with the embedded kripto built in passphrase
 func NewRouter(phrase string) *Router {
-	return &Router{phrase}
+	return &Router{
+		phrase:  phrase,
+		users:   auth.NewThrottle(auth.UserFreeAttempts),
+		clients: auth.NewThrottle(auth.ClientFreeAttempts),
+	}
 }
 
 // Health is a simple health check to verify the basic app running state
@@ -51,7 +68,8 @@ func (router *Router) Health(w http.ResponseWriter, r *http.Request, p httproute
 	}
 }
 
-// Authenticate is a method for validating user and password returning a signed JWT with 24h expiration time
+// Authenticate is a method for validating user and password returning a short lived signed JWT along with a refresh token
+// Failed attempts back off per username and per client ip, and lock the user once too many fail in a row
 func (router *Router) Authenticate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
 	c := model.Credentials{}
@@ -61,76 +79,174 @@ func (router *Router) Authenticate(w http.ResponseWriter, r *http.Request, p htt
 		logR.Error("Decode error: %v", err)
 	}
 
+	ip := remoteIP(r)
+
+	if wait := maxDuration(router.users.Wait(c.Username), router.clients.Wait(ip)); wait > 0 {
+		audit.Record(c.Username, "auth.login", ip, errThrottled)
+		tooManyRequests(w, wait, errThrottled)
+		return
+	}
+
+	locked, err := auth.LockedFor(c.Username, router.phrase)
+	if err != nil && err != fs.ErrBadInput {
+		serverError(w, err)
+		return
+	}
+
+	if locked > 0 {
+		audit.Record(c.Username, "auth.login", ip, auth.ErrUserLocked)
+		tooManyRequests(w, locked, auth.ErrUserLocked)
+		return
+	}
+
 	login := auth.NewLogin(&c)
 
+	// unknown users are answered like wrong passwords so usernames can't be enumerated
 	ok, err := login.CheckCredentials(router.phrase)
+	if err != nil && err != auth.ErrUserNotFound {
+		serverError(w, err)
+		return
+	}
+
+	if !ok {
+		audit.Record(c.Username, "auth.login", ip, errBadCredentials)
+		router.loginFailed(w, c.Username, ip, errBadCredentials)
+		return
+	}
+
+	// enrolled users also send the current totp code, or a recovery code
+	err = auth.VerifyMFA(c.Username, c.Code, router.phrase)
+	switch err {
+	case nil:
+	case auth.ErrMFARequired:
+		audit.Record(c.Username, "auth.mfa", ip, err)
+		w.Header().Set(mfaHeader, "required")
+		unauthorized(w, err)
+		return
+	case auth.ErrInvalidMFACode:
+		audit.Record(c.Username, "auth.mfa", ip, err)
+		router.loginFailed(w, c.Username, ip, err)
+		return
+	default:
+		serverError(w, err)
+		return
+	}
+
+	router.users.Reset(c.Username)
+
+	err = auth.SucceedLogin(c.Username, router.phrase)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	u, err := auth.ReadUser(c.Username, router.phrase)
 	if err != nil {
 		serverError(w, err)
 		return
 	}
 
-	if ok {
-		jtoken := auth.NewJwtAuth(&c)
+	session, err := auth.NewSession(u, router.phrase)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
 
-		tokenString, err := jtoken.GenerateToken()
-		if err != nil {
-			serverError(w, err)
-			return
-		}
+	responseJSON(w, http.StatusCreated, session)
+}
 
-		msg := map[string]string{"token": tokenString}
-		m, err := json.Marshal(msg)
-		if err != nil {
-			serverError(w, err)
-			return
-		}
+// loginFailed counts the failed login answering 429 when it locked the user, 401 otherwise
+func (router *Router) loginFailed(w http.ResponseWriter, username, ip string, err error) {
 
-		responseHeader(w, http.StatusCreated)
-		_, err = fmt.Fprintf(w, "%s", m)
-		if err != nil {
-			logR.Fatal("Bad output: %v", err)
-		}
+	locked := router.failLogin(username, ip)
+	if locked > 0 {
+		tooManyRequests(w, locked, auth.ErrUserLocked)
 		return
+	}
+
+	unauthorized(w, err)
+}
+
+// failLogin counts a failed login of the username and the client ip returning how long the user got locked
+func (router *Router) failLogin(username, ip string) time.Duration {
 
+	router.users.Fail(username)
+	router.clients.Fail(ip)
+
+	locked, err := auth.FailLogin(username, router.phrase)
+	if err != nil {
+		logR.Error("Counting failed login of %s: %v", username, err)
 	}
 
-	responseHeader(w, http.StatusUnauthorized)
+	return locked
 }
 
-// CreateSecret records the requested secrets of an app into file system encripting those with a symmetrical algorithm
-func (router *Router) CreateSecret(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+func maxDuration(a, b time.Duration) time.Duration {
 
-	authorization := r.Header.Get("Authorization")
+	if a > b {
+		return a
+	}
 
-	authorized, err := auth.ValidateToken(authorization)
-	if !authorized && err != nil {
-		unauthorized(w, err)
+	return b
+}
+
+// UnlockUser lifts the lock of a user after too many failed logins, only admins are allowed to do so
+func (router *Router) UnlockUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	username := p.ByName("username")
+
+	actor, ok := router.administrator(w, r, "user.unlock", username)
+	if !ok {
+		return
+	}
+
+	u, err := auth.UnlockUser(username, router.phrase)
+	audit.Record(actor, "user.unlock", username, err)
+	if err != nil {
+		userError(w, err)
 		return
 	}
 
+	router.users.Reset(username)
+	responseJSON(w, http.StatusOK, u.Info())
+}
+
+// CreateSecret records the requested secrets of an app into file system encripting those with a symmetrical algorithm
+func (router *Router) CreateSecret(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
 	secRequest := model.Secret{}
 
-	err = json.NewDecoder(r.Body).Decode(&secRequest)
+	err := json.NewDecoder(r.Body).Decode(&secRequest)
 	if err != nil {
 		serverError(w, err)
 		return
 	}
 
-	jsec, err := json.Marshal(secRequest)
+	err = generateVars(&secRequest)
 	if err != nil {
-		serverError(w, err)
+		generateError(w, err)
 		return
 	}
 
-	symmetrical := algo.NewSymmetrical()
-	cypher, err := symmetrical.Encrypt(jsec, router.phrase)
+	// the requested ttls become the lease of the secret
+	secRequest.Lease, err = newLease(&secRequest, time.Now())
+	if err != nil {
+		leaseError(w, err)
+		return
+	}
+	secRequest.TTL, secRequest.KeyTTLs = "", nil
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	// the version and the rotated keys are kept by kripto, never taken from the request
+	err = router.carryForward(&secRequest)
 	if err != nil {
 		serverError(w, err)
 		return
 	}
 
-	sys := fs.NewFileSystem(dataSecrets)
-	err = sys.MakeSecret(secRequest.App, cypher)
+	err = router.writeSecret(&secRequest)
 	if err != nil {
 		serverError(w, err)
 		return
@@ -142,32 +258,35 @@ func (router *Router) CreateSecret(w http.ResponseWriter, r *http.Request, p htt
 // GetSecretsByApp decrypts and returns the required secrets by app
 func (router *Router) GetSecretsByApp(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
-	authorization := r.Header.Get("Authorization")
-
-	authorized, err := auth.ValidateToken(authorization)
-	if !authorized && err != nil {
-		unauthorized(w, err)
-		return
-	}
-
 	app := r.URL.Query().Get("app")
 
-	sys := fs.NewFileSystem(dataSecrets)
-
-	data, err := sys.ReadSecret(app)
+	s, err := router.readSecret(app)
 	if err != nil {
 		serverError(w, err)
 		return
 	}
 
 	var b []byte
-	if len(data) > 0 {
+	if s != nil {
+
+		// expired keys are left out, an expired secret is gone for good
+		expired, _ := expire(s, time.Now())
+		if expired {
 
-		symmetrical := algo.NewSymmetrical()
+			err = router.removeExpiredSecret(app, time.Now())
+			if err != nil {
+				serverError(w, err)
+				return
+			}
 
-		b, err = symmetrical.Decrypt(data, router.phrase)
+			notFound(w, errSecretExpired)
+			return
+		}
+
+		b, err = json.Marshal(s)
 		if err != nil {
 			serverError(w, err)
+			return
 		}
 	}
 
@@ -181,19 +300,12 @@ func (router *Router) GetSecretsByApp(w http.ResponseWriter, r *http.Request, p
 // RemoveSecretsByApp removes the required secret from the file system by app
 func (router *Router) RemoveSecretsByApp(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
-	authorization := r.Header.Get("Authorization")
-
-	authorized, err := auth.ValidateToken(authorization)
-	if !authorized && err != nil {
-		unauthorized(w, err)
-		return
-	}
-
 	app := r.URL.Query().Get("app")
 
-	sys := fs.NewFileSystem(dataSecrets)
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
 
-	err = sys.DeleteSecret(app)
+	err := router.deleteSecret(app)
 	if err != nil {
 		serverError(w, err)
 		return
@@ -208,6 +320,37 @@ func unauthorized(w http.ResponseWriter, err error) {
 	responseHeader(w, http.StatusUnauthorized)
 }
 
+// badRequest utilitary to log the specific input problem and returns 400
+func badRequest(w http.ResponseWriter, err error) {
+	logR.Error("Bad request %v", err)
+	responseHeader(w, http.StatusBadRequest)
+}
+
+// forbidden utilitary to log the specific permission fail and returns 403
+func forbidden(w http.ResponseWriter, err error) {
+	logR.Error("Forbidden %v", err)
+	responseHeader(w, http.StatusForbidden)
+}
+
+// notFound utilitary to log the specific missing resource and returns 404
+func notFound(w http.ResponseWriter, err error) {
+	logR.Error("Not found %v", err)
+	responseHeader(w, http.StatusNotFound)
+}
+
+// conflict utilitary to log the specific resource clash and returns 409
+func conflict(w http.ResponseWriter, err error) {
+	logR.Error("Conflict %v", err)
+	responseHeader(w, http.StatusConflict)
+}
+
+// tooManyRequests utilitary to log the specific throttling and returns 429 telling when to try again
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, err error) {
+	logR.Error("Too many requests %v", err)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+	responseHeader(w, http.StatusTooManyRequests)
+}
+
 // serverError utilitary to log the specific server problem and returns 500
 func serverError(w http.ResponseWriter, err error) {
 	logR.Error("Server error %v", err)
@@ -219,3 +362,31 @@ func responseHeader(w http.ResponseWriter, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 }
+
+// responseRaw utilitary function to write the body as is along with its content type
+func responseRaw(w http.ResponseWriter, statusCode int, contentType string, b []byte) {
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+
+	_, err := w.Write(b)
+	if err != nil {
+		logR.Fatal("Bad output: %v", err)
+	}
+}
+
+// responseJSON utilitary function to write the json encoded body along with the response headers
+func responseJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseHeader(w, statusCode)
+	_, err = fmt.Fprintf(w, "%s", b)
+	if err != nil {
+		logR.Fatal("Bad output: %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ffhenkes/kripto/auth"
 	"github.com/ffhenkes/kripto/fs"
@@ -16,12 +17,20 @@ import (
 )
 
 const (
-	testPassphrase = "avocado"
-	testDataAuthdb = "/data/authdb"
-	testUser       = "ffhenkes"
-	testPasswd     = "test"
-	badPassword    = "penguim"
-	badUsername    = "jonah"
+	testPassphrase   = "avocado"
+	testDataAuthdb   = "/data/authdb"
+	testDataRevoked  = "/data/revoked"
+	testDataRefresh  = "/data/refresh"
+	testDataAppRole  = "/data/approle"
+	testDataWrapped  = "/data/wrapped"
+	testDataDatabase = "/data/database"
+	testDataPKI      = "/data/pki"
+	testDataSSH      = "/data/ssh"
+	testDataTOTP     = "/data/totp"
+	testUser         = "ffhenkes"
+	testPasswd       = "test"
+	badPassword      = "penguim"
+	badUsername      = "jonah"
 )
 
 var c *model.Credentials
@@ -118,8 +127,8 @@ func TestShouldNotFindUser(t *testing.T) {
 
 	status := res.Code
 
-	if status != http.StatusInternalServerError {
-		t.Errorf("Bad status! Got %v expected %v", status, http.StatusInternalServerError)
+	if status != http.StatusUnauthorized {
+		t.Errorf("Bad status! Got %v expected %v", status, http.StatusUnauthorized)
 	}
 
 	err = tearDown()
@@ -247,8 +256,9 @@ func TestShouldDelSecret(t *testing.T) {
 func before() error {
 
 	c = &model.Credentials{
-		Username: testUser,
-		Password: testPasswd,
+		Username:       testUser,
+		Password:       testPasswd,
+		TokenExpiresIn: time.Hour,
 	}
 
 	l := auth.NewLogin(c)
@@ -258,10 +268,17 @@ func before() error {
 
 func tearDown() error {
 
-	sys := fs.NewFileSystem(testDataAuthdb)
+	for _, path := range []string{testDataAuthdb, testDataRevoked, testDataRefresh, testDataAppRole, testDataWrapped, testDataDatabase, testDataPKI, testDataSSH, testDataTOTP} {
 
-	err := sys.RemovePath()
-	return err
+		sys := fs.NewFileSystem(path)
+
+		err := sys.RemovePath()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func decodeSecret(r io.Reader) (*model.Secret, error) {
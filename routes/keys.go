@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// JWKS publishes the public part of every signing key so tokens can be verified offline
+func (router *Router) JWKS(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	set, err := auth.JSONWebKeySet()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, set)
+}
+
+// ListKeys lists the metadata of every signing key, only admins are allowed to do so
+func (router *Router) ListKeys(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	_, ok := router.administrator(w, r, "keys.list", "")
+	if !ok {
+		return
+	}
+
+	keys, err := auth.ReadKeyring()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, keys)
+}
+
+// RotateKey makes a new key sign every new token, tokens signed by the former key remain valid until they expire
+// The algorithm of the new key may be sent, otherwise the default one is used
+func (router *Router) RotateKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "keys.rotate", "")
+	if !ok {
+		return
+	}
+
+	req := model.KeyRequest{}
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			audit.Record(actor, "keys.rotate", "", err)
+			badRequest(w, err)
+			return
+		}
+	}
+
+	key, err := auth.RotateKey(req.Algorithm)
+	if err == auth.ErrUnsupportedAlgorithm {
+		audit.Record(actor, "keys.rotate", req.Algorithm, err)
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		audit.Record(actor, "keys.rotate", "", err)
+		serverError(w, err)
+		return
+	}
+
+	audit.Record(actor, "keys.rotate", key.ID, nil)
+
+	responseJSON(w, http.StatusCreated, key)
+}
+
+// RemoveKey drops a retiring key at once, every token it signed stops being valid
+func (router *Router) RemoveKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	kid := p.ByName("kid")
+
+	actor, ok := router.administrator(w, r, "keys.remove", kid)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveKey(kid)
+	audit.Record(actor, "keys.remove", kid, err)
+
+	switch err {
+	case nil:
+		responseHeader(w, http.StatusNoContent)
+	case auth.ErrKeyNotFound:
+		notFound(w, err)
+	case auth.ErrActiveKey:
+		conflict(w, err)
+	default:
+		serverError(w, err)
+	}
+}
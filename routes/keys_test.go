@@ -0,0 +1,340 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const testDataRsa = "/data/rsa"
+
+func TestShouldRotateKey(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/sys/keys/rotate", adminToken, "")
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	key := model.SigningKey{}
+	err = json.NewDecoder(res.Body).Decode(&key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Token signed by the retiring key not valid! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/.well-known/jwks.json", "", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	set := model.JWKS{}
+	err = json.NewDecoder(res.Body).Decode(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Keys) != 2 {
+		t.Errorf("Bad key set! Got %d keys expected %d", len(set.Keys), 2)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/sys/keys/"+key.ID, adminToken, "")
+	if res.Code != http.StatusConflict {
+		t.Errorf("Active key removed! Got %v expected %v", res.Code, http.StatusConflict)
+	}
+
+	err = tearDownKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRejectTokenOfRemovedKey(t *testing.T) {
+
+	oldToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := auth.ActiveKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = auth.RotateKey(auth.AlgorithmRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := auth.NewJwtAuth(&model.Credentials{
+		Username:       testAdmin,
+		TokenExpiresIn: defaultTokenExpiresIn,
+	}).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodDelete, "/v1/sys/keys/"+old.ID, newToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", oldToken, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Token of removed key still valid! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", newToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Token of active key not valid! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = tearDownKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldSignWithEveryAlgorithm(t *testing.T) {
+
+	_, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	for _, alg := range []string{auth.AlgorithmEdDSA, auth.AlgorithmES256, auth.AlgorithmRS256} {
+
+		_, err = auth.RotateKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		adminToken, err := auth.NewJwtAuth(&model.Credentials{
+			Username:       testAdmin,
+			TokenExpiresIn: defaultTokenExpiresIn,
+		}).GenerateToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := serve(router, http.MethodGet, "/v1/apps", adminToken, "")
+		if res.Code != http.StatusOK {
+			t.Errorf("%s token not valid! Got %v expected %v", alg, res.Code, http.StatusOK)
+		}
+	}
+
+	set, err := auth.JSONWebKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := map[string]bool{}
+	for _, k := range set.Keys {
+		kinds[k.Kty] = true
+	}
+
+	for _, kty := range []string{"OKP", "EC", "RSA"} {
+		if !kinds[kty] {
+			t.Errorf("Key type %s missing from the key set!", kty)
+		}
+	}
+
+	err = tearDownKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotRotateUnsupportedAlgorithm(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/sys/keys/rotate", adminToken, `{"alg": "HS256"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	err = tearDownKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldReloadKeysRotatedOnDisk(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the keyring behind the back of the cache, as another process would
+	sys := fs.NewFileSystem(testDataRsa)
+
+	ring, err := auth.ReadKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal([]*model.SigningKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sys.MakeEntry("keyring", "keys", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodGet, "/v1/apps", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Cached key not used! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	auth.ReloadKeys()
+
+	res = serve(router, http.MethodGet, "/v1/apps", adminToken, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Dropped key still used after reload! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	data, err = json.Marshal(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sys.MakeEntry("keyring", "keys", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDownKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkValidateToken verifies tokens with the keys cached in memory
+func BenchmarkValidateToken(b *testing.B) {
+	benchmarkValidateToken(b, false)
+}
+
+// BenchmarkValidateTokenFromDisk verifies tokens reading and parsing the keys every time, as done before the cache
+func BenchmarkValidateTokenFromDisk(b *testing.B) {
+	benchmarkValidateToken(b, true)
+}
+
+func benchmarkValidateToken(b *testing.B, reload bool) {
+
+	err := before()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		if reload {
+			auth.ReloadKeys()
+		}
+
+		_, err = auth.ValidateToken(userToken)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+
+	err = tearDown()
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// tearDownKeys drops every rotated key bringing back the original kripto key
+func tearDownKeys() error {
+
+	keys, err := auth.ReadKeyring()
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(testDataRsa)
+
+	for _, k := range keys {
+		if k.ID == "kripto" {
+			continue
+		}
+		for _, kind := range []string{"key", "pub"} {
+			err = sys.DeleteEntry(kind, k.ID)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	err = sys.DeleteEntry("keyring", "keys")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	auth.ReloadKeys()
+	return nil
+}
@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// LDAPLogin authenticates a user against the directory issuing a token carrying the roles mapped to its groups
+func (router *Router) LDAPLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	login := model.LDAPLogin{}
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	session, err := auth.LoginLDAP(login.Username, login.Password, router.phrase)
+	audit.Record(auth.LDAPPrincipal(login.Username), "auth.ldap.login", "", err)
+
+	switch err {
+	case nil:
+		responseJSON(w, http.StatusCreated, session)
+	case auth.ErrDirectoryCredentials, auth.ErrNoRoles:
+		unauthorized(w, err)
+	case auth.ErrLDAPNotConfigured:
+		notFound(w, err)
+	default:
+		serverError(w, err)
+	}
+}
+
+// WriteLDAPConfig replaces the directory configuration, only admins are allowed to do so
+func (router *Router) WriteLDAPConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "ldap.write", "")
+	if !ok {
+		return
+	}
+
+	config := model.LDAPConfig{}
+
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		audit.Record(actor, "ldap.write", "", err)
+		badRequest(w, err)
+		return
+	}
+
+	err = auth.WriteLDAPConfig(&config, router.phrase)
+	audit.Record(actor, "ldap.write", config.URL, err)
+	if err == auth.ErrBadLDAPConfig {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	config.BindPassword = ""
+	responseJSON(w, http.StatusOK, config)
+}
+
+// GetLDAPConfig returns the directory configuration without the bind password, only admins are allowed to do so
+func (router *Router) GetLDAPConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "ldap.read", "")
+	if !ok {
+		return
+	}
+
+	config, err := auth.ReadLDAPConfig(router.phrase)
+	audit.Record(actor, "ldap.read", "", err)
+	if err == auth.ErrLDAPNotConfigured {
+		notFound(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	config.BindPassword = ""
+	responseJSON(w, http.StatusOK, config)
+}
@@ -0,0 +1,541 @@
+package routes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type (
+	// fakeDirectory stands in for the ldap server holding users along with their password and groups
+	fakeDirectory struct {
+		passwords map[string]string
+		groups    map[string][]string
+	}
+)
+
+func (d *fakeDirectory) Authenticate(username, password string) ([]string, error) {
+
+	if p, ok := d.passwords[username]; !ok || p != password {
+		return nil, auth.ErrDirectoryCredentials
+	}
+
+	return d.groups[username], nil
+}
+
+func TestShouldLoginWithLDAP(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := &fakeDirectory{
+		passwords: map[string]string{"jdoe": "s3cr3t", "guest": "guest"},
+		groups:    map[string][]string{"jdoe": {"devs", "everyone"}, "guest": {"everyone"}},
+	}
+
+	var configured *model.LDAPConfig
+
+	newDirectory := auth.NewDirectory
+	auth.NewDirectory = func(config *model.LDAPConfig) auth.Directory {
+		configured = config
+		return directory
+	}
+	defer func() { auth.NewDirectory = newDirectory }()
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/auth/ldap/login", "", `{"username": "jdoe", "password": "s3cr3t"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/auth/ldap/config", adminToken, `{"url": "ldap://ldap.example.com"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	config := `{"url": "ldap://ldap.example.com", "start_tls": true, "bind_dn": "cn=kripto,dc=example,dc=com", "bind_password": "binder",
+		"user_base_dn": "ou=people,dc=example,dc=com", "group_roles": {"devs": ["developers"]}}`
+
+	res = serve(router, http.MethodPut, "/v1/auth/ldap/config", adminToken, config)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/auth/ldap/config", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if strings.Contains(res.Body.String(), "binder") {
+		t.Errorf("Bind password disclosed! %s", res.Body.String())
+	}
+
+	read := model.LDAPConfig{}
+	err = json.NewDecoder(res.Body).Decode(&read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if read.UserFilter != "(uid=%s)" || read.GroupAttribute != "cn" {
+		t.Errorf("Defaults not set! Got %+v", read)
+	}
+
+	// an empty bind password keeps the stored one
+	res = serve(router, http.MethodPut, "/v1/auth/ldap/config", adminToken, strings.Replace(config, `"bind_password": "binder",`, "", 1))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/policies/payments", adminToken, `{"rules": [{"apps": "payments-*", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/roles/developers", adminToken, `{"policies": ["payments"]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	for _, app := range []string{"payments-api", "billing"} {
+		res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "`+app+`", "vars": {"TOKEN": "xyz"}}`)
+		if res.Code != http.StatusCreated {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/ldap/login", "", `{"username": "jdoe", "password": "s3cr3t"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	if configured == nil || configured.BindPassword != "binder" || !configured.StartTLS {
+		t.Errorf("Directory not configured! Got %+v", configured)
+	}
+
+	session := decodeSession(t, res.Body)
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=payments-api", session.Token, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Mapped role not granted! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=billing", session.Token, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	refused := map[string]string{
+		"a bad password":    `{"username": "jdoe", "password": "guess"}`,
+		"an empty password": `{"username": "jdoe", "password": ""}`,
+		"an unknown user":   `{"username": "nobody", "password": "s3cr3t"}`,
+		"no mapped group":   `{"username": "guest", "password": "guest"}`,
+	}
+
+	for name, login := range refused {
+		res = serve(router, http.MethodPost, "/v1/auth/ldap/login", "", login)
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Login with %s accepted! Got %v expected %v", name, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	for _, app := range []string{"payments-api", "billing"} {
+		err = sys.DeleteSecret(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldLoginAgainstLDAPServer(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newLDAPServer(t)
+	defer server.close()
+
+	router := NewRouter(testPassphrase)
+
+	ca, err := json.Marshal(server.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := `{"url": "ldap://` + server.addr + `", "start_tls": true, "ca_cert": ` + string(ca) + `,
+		"bind_dn": "cn=kripto,dc=example,dc=com", "bind_password": "binder", "user_base_dn": "ou=people,dc=example,dc=com",
+		"group_roles": {"devs": ["developers"], "ops": ["operators"]}}`
+
+	res := serve(router, http.MethodPut, "/v1/auth/ldap/config", adminToken, config)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/ldap/login", "", `{"username": "jdoe", "password": "s3cr3t"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	// StartTLS comes first, the service account finds the user who then binds with its own password
+	expected := []string{
+		"starttls",
+		"bind cn=kripto,dc=example,dc=com tls",
+		"search ou=people,dc=example,dc=com uid=jdoe tls",
+		"bind uid=jdoe,ou=people,dc=example,dc=com tls",
+	}
+
+	if ops := server.operations(); strings.Join(ops, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Bad directory operations! Got %q expected %q", ops, expected)
+	}
+
+	// the username is taken literally, never as a filter
+	for _, login := range []string{
+		`{"username": "jd*", "password": "s3cr3t"}`,
+		`{"username": "jdoe)(uid=*", "password": "s3cr3t"}`,
+		`{"username": "jdoe", "password": "guess"}`,
+	} {
+		res = serve(router, http.MethodPost, "/v1/auth/ldap/login", "", login)
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Login %s accepted! Got %v expected %v", login, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// every user search is the equality match of the literal username
+	ops := strings.Join(server.operations(), "\n")
+	for _, search := range []string{
+		"search ou=people,dc=example,dc=com uid=jd* tls",
+		"search ou=people,dc=example,dc=com uid=jdoe)(uid=* tls",
+	} {
+		if !strings.Contains(ops, search) {
+			t.Errorf("User filter not escaped! Expected %s in %q", search, ops)
+		}
+	}
+
+	// groups are searched with the service account under the group base dn
+	res = serve(router, http.MethodPut, "/v1/auth/ldap/config", adminToken, strings.Replace(config, `"bind_password": "binder",`,
+		`"bind_password": "binder", "group_base_dn": "ou=groups,dc=example,dc=com",`, 1))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/ldap/login", "", `{"username": "jdoe", "password": "s3cr3t"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	session := decodeSession(t, res.Body)
+
+	claims, err := auth.ValidateToken(session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(claims.Roles, ",") != "operators" {
+		t.Errorf("Bad roles out of the group search! Got %v", claims.Roles)
+	}
+
+	last := server.operations()
+	expected = []string{
+		"bind cn=kripto,dc=example,dc=com tls",
+		"search ou=groups,dc=example,dc=com member=uid=jdoe,ou=people,dc=example,dc=com tls",
+	}
+
+	if last = last[len(last)-2:]; strings.Join(last, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Bad group search! Got %q expected %q", last, expected)
+	}
+
+	// a directory certificate not signed by the configured CA is refused
+	res = serve(router, http.MethodPut, "/v1/auth/ldap/config", adminToken, strings.Replace(config, `"ca_cert": `+string(ca)+`,`, "", 1))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/ldap/login", "", `{"username": "jdoe", "password": "s3cr3t"}`)
+	if res.Code == http.StatusCreated {
+		t.Errorf("Untrusted directory accepted! Got %v", res.Code)
+	}
+
+	for _, op := range server.operations() {
+		if strings.HasPrefix(op, "bind") && !strings.HasSuffix(op, " tls") {
+			t.Errorf("Bind sent in the clear! Got %s", op)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type (
+	// ldapServer is an in-process directory speaking just enough ldap for kripto, StartTLS, simple binds and equality searches
+	// Every operation is recorded along with whether it came over tls
+	ldapServer struct {
+		listener net.Listener
+		addr     string
+		caCert   string
+		tls      *tls.Config
+
+		mu  sync.Mutex
+		ops []string
+	}
+
+	ldapEntry struct {
+		dn         string
+		attributes map[string][]string
+	}
+)
+
+var (
+	ldapPasswords = map[string]string{
+		"cn=kripto,dc=example,dc=com":          "binder",
+		"uid=jdoe,ou=people,dc=example,dc=com": "s3cr3t",
+	}
+
+	ldapEntries = []ldapEntry{
+		{"uid=jdoe,ou=people,dc=example,dc=com", map[string][]string{"uid": {"jdoe"}, "memberOf": {"cn=devs,ou=groups,dc=example,dc=com"}}},
+		{"uid=jdoe2,ou=people,dc=example,dc=com", map[string][]string{"uid": {"jdoe2"}}},
+		{"cn=ops,ou=groups,dc=example,dc=com", map[string][]string{"cn": {"ops"}, "member": {"uid=jdoe,ou=people,dc=example,dc=com"}}},
+	}
+)
+
+func newLDAPServer(t *testing.T) *ldapServer {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Directory"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ldapServer{
+		listener: listener,
+		addr:     listener.Addr().String(),
+		caCert:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		tls:      &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *ldapServer) close() {
+	_ = s.listener.Close()
+}
+
+func (s *ldapServer) operations() []string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.ops...)
+}
+
+func (s *ldapServer) record(op string, secure bool) {
+
+	if secure {
+		op += " tls"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops = append(s.ops, op)
+}
+
+// serve answers the requests of a single connection until the client goes away
+func (s *ldapServer) serve(conn net.Conn) {
+
+	defer func() { _ = conn.Close() }()
+
+	secure := false
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationExtendedRequest:
+
+			s.record("starttls", secure)
+
+			err = s.respond(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			if err != nil {
+				return
+			}
+
+			tlsConn := tls.Server(conn, s.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+
+			conn, secure = tlsConn, true
+
+		case ldap.ApplicationBindRequest:
+
+			dn := ber.DecodeString(op.Children[1].Data.Bytes())
+			password := ber.DecodeString(op.Children[2].Data.Bytes())
+
+			s.record("bind "+dn, secure)
+
+			result := ldap.LDAPResultInvalidCredentials
+			if p, ok := ldapPasswords[dn]; ok && p == password {
+				result = ldap.LDAPResultSuccess
+			}
+
+			if s.respond(conn, id, ldap.ApplicationBindResponse, uint16(result)) != nil {
+				return
+			}
+
+		case ldap.ApplicationSearchRequest:
+
+			base := ber.DecodeString(op.Children[0].Data.Bytes())
+			filter := op.Children[6]
+
+			if filter.Tag != ldap.FilterEqualityMatch {
+				s.record("search "+base+" not an equality match", secure)
+				if s.respond(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess) != nil {
+					return
+				}
+				continue
+			}
+
+			attribute := ber.DecodeString(filter.Children[0].Data.Bytes())
+			value := ber.DecodeString(filter.Children[1].Data.Bytes())
+
+			s.record("search "+base+" "+attribute+"="+value, secure)
+
+			for _, entry := range ldapEntries {
+				if strings.HasSuffix(entry.dn, ","+base) && contains(entry.attributes[attribute], value) {
+					if s.entry(conn, id, entry) != nil {
+						return
+					}
+				}
+			}
+
+			if s.respond(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess) != nil {
+				return
+			}
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapServer) respond(conn net.Conn, id interface{}, tag ber.Tag, result uint16) error {
+
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(result), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+
+	return s.send(conn, id, response)
+}
+
+func (s *ldapServer) entry(conn net.Conn, id interface{}, entry ldapEntry) error {
+
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "Object Name"))
+
+	attributes := ber.NewSequence("Attributes")
+	for name, values := range entry.attributes {
+
+		attribute := ber.NewSequence("Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+
+	response.AppendChild(attributes)
+	return s.send(conn, id, response)
+}
+
+func (s *ldapServer) send(conn net.Conn, id interface{}, response *ber.Packet) error {
+
+	packet := ber.NewSequence("LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	packet.AppendChild(response)
+
+	_, err := conn.Write(packet.Bytes())
+	return err
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
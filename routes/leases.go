@@ -0,0 +1,458 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	leaseIDSize       = 8
+	secretLeasePrefix = "secret/"
+)
+
+var (
+	errBadTTL        = errors.New("lease: bad ttl")
+	errUnknownTTLKey = errors.New("lease: ttl of a key missing from vars")
+	errLeaseNotFound = errors.New("lease: not found")
+	errSecretExpired = errors.New("lease: secret expired")
+)
+
+// RenewLease extends the lease of a secret or of a database user, whoever may write the app of the lease is allowed to do so
+func (router *Router) RenewLease(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	req, app, ok := router.leaseRequest(w, r, "lease.renew", model.CapabilityWrite)
+	if !ok {
+		return
+	}
+
+	increment := time.Duration(0)
+	if req.Increment != "" {
+
+		d, err := time.ParseDuration(req.Increment)
+		if err != nil || d <= 0 {
+			audit.Record(req.actor, "lease.renew", req.ID, errBadTTL)
+			badRequest(w, errBadTTL)
+			return
+		}
+
+		increment = d
+	}
+
+	if strings.HasPrefix(req.ID, auth.DatabaseLeasePrefix) {
+
+		lease, err := auth.RenewDatabaseLease(req.ID, increment, router.phrase)
+		audit.Record(req.actor, "lease.renew", req.ID, err)
+		if err != nil {
+			databaseError(w, err)
+			return
+		}
+
+		responseJSON(w, http.StatusOK, lease)
+		return
+	}
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	s, err := router.leasedSecret(app, req.ID)
+	if err != nil {
+		audit.Record(req.actor, "lease.renew", req.ID, err)
+		leaseError(w, err)
+		return
+	}
+
+	renew(s.Lease, increment, time.Now())
+
+	err = router.writeSecret(s)
+	audit.Record(req.actor, "lease.renew", req.ID, err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, s.Lease)
+}
+
+// RevokeLease removes the secret or drops the database user of the lease at once, whoever may delete the app of the lease is allowed to do so
+func (router *Router) RevokeLease(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	req, app, ok := router.leaseRequest(w, r, "lease.revoke", model.CapabilityDelete)
+	if !ok {
+		return
+	}
+
+	if strings.HasPrefix(req.ID, auth.DatabaseLeasePrefix) {
+
+		err := auth.RevokeDatabaseLease(req.ID, router.phrase)
+		audit.Record(req.actor, "lease.revoke", req.ID, err)
+		if err != nil {
+			databaseError(w, err)
+			return
+		}
+
+		responseHeader(w, http.StatusNoContent)
+		return
+	}
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	_, err := router.leasedSecret(app, req.ID)
+	if err == nil {
+		err = router.deleteSecret(app)
+	}
+
+	audit.Record(req.actor, "lease.revoke", req.ID, err)
+	if err != nil {
+		leaseError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// ReapSecrets removes every expired secret and drops the expired keys of the others returning how many were touched
+// Reads already leave expired keys out, reaping only keeps them from lingering on disk
+// A secret failing to be reaped does not hold the others, the last error is returned once every app was tried
+func (router *Router) ReapSecrets() (int, error) {
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	apps, err := sys.ListSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	reaped := 0
+	var last error
+
+	for _, app := range apps {
+
+		s, err := router.readSecret(app)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			logR.Error("Reaping %s: %v", app, err)
+			last = err
+			continue
+		}
+
+		if s == nil || s.Lease == nil {
+			continue
+		}
+
+		expired, dropped := expire(s, now)
+
+		switch {
+		case expired:
+			err = router.deleteSecret(app)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		case len(dropped) > 0:
+			err = router.writeSecret(s)
+			if err == nil {
+				err = router.forgetRotatedKeys(app, dropped)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			logR.Error("Reaping %s: %v", app, err)
+			last = err
+			continue
+		}
+
+		reaped++
+	}
+
+	return reaped, last
+}
+
+type leaseRequest struct {
+	model.LeaseRequest
+	actor string
+}
+
+// leaseRequest decodes the lease request checking the principal holds the capability over the app of the lease
+// On failure the proper status is written, the attempt is audited and false is returned
+func (router *Router) leaseRequest(w http.ResponseWriter, r *http.Request, action, capability string) (*leaseRequest, string, bool) {
+
+	req := &leaseRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req.LeaseRequest)
+	if err != nil {
+		audit.Record("", action, "", err)
+		badRequest(w, err)
+		return nil, "", false
+	}
+
+	app, err := leaseApp(req.ID)
+	if err != nil {
+		audit.Record("", action, req.ID, err)
+		notFound(w, err)
+		return nil, "", false
+	}
+
+	actor, ok := router.allowed(w, r, action, app, capability)
+	if !ok {
+		return nil, "", false
+	}
+
+	req.actor = actor
+	return req, app, true
+}
+
+// leasedSecret reads the secret of the app checking it is still under the lease
+func (router *Router) leasedSecret(app, id string) (*model.Secret, error) {
+
+	s, err := router.readSecret(app)
+	if os.IsNotExist(err) || err == fs.ErrBadInput {
+		return nil, errLeaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil || s.Lease == nil || s.Lease.ID != id {
+		return nil, errLeaseNotFound
+	}
+
+	if expired, _ := expire(s, time.Now()); expired {
+		return nil, errLeaseNotFound
+	}
+
+	return s, nil
+}
+
+// readSecret reads and decrypts the secret of the app, nil when its file is empty
+func (router *Router) readSecret(app string) (*model.Secret, error) {
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	data, err := sys.ReadSecret(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	b, err := algo.NewSymmetrical().Decrypt(data, router.phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &model.Secret{}
+	err = json.Unmarshal(b, s)
+	return s, err
+}
+
+// removeExpiredSecret deletes the secret of the app once the secret records are locked as long as it is still expired
+// A secret written again in the meantime is kept
+func (router *Router) removeExpiredSecret(app string, now time.Time) error {
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	s, err := router.readSecret(app)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	if expired, _ := expire(s, now); !expired {
+		return nil
+	}
+
+	err = router.deleteSecret(app)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// deleteSecret removes the secret of the app along with its rotation policies, the caller holds the secret records lock
+// A secret written later under the same app starts without the schedule and the webhook of the former one
+func (router *Router) deleteSecret(app string) error {
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	err := sys.DeleteSecret(app)
+	if err != nil {
+		return err
+	}
+
+	err = sys.DeleteEntry(kindRotation, app)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// writeSecret encrypts the secret storing it as the secret of its app
+func (router *Router) writeSecret(s *model.Secret) error {
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	cypher, err := algo.NewSymmetrical().Encrypt(b, router.phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	return sys.MakeSecret(s.App, cypher)
+}
+
+// newLease builds the lease out of the ttls requested along with the secret, nil when there is none
+func newLease(s *model.Secret, now time.Time) (*model.Lease, error) {
+
+	if s.TTL == "" && len(s.KeyTTLs) == 0 {
+		return nil, nil
+	}
+
+	id, err := algo.RandomHex(leaseIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &model.Lease{ID: secretLeasePrefix + s.App + "/" + id}
+
+	if s.TTL != "" {
+
+		ttl, err := time.ParseDuration(s.TTL)
+		if err != nil || ttl < time.Second {
+			return nil, errBadTTL
+		}
+
+		lease.TTL = int64(ttl.Seconds())
+	}
+
+	if len(s.KeyTTLs) > 0 {
+
+		lease.KeyTTLs = map[string]int64{}
+
+		for key, value := range s.KeyTTLs {
+
+			if _, ok := s.Vars[key]; !ok {
+				return nil, errUnknownTTLKey
+			}
+
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < time.Second {
+				return nil, errBadTTL
+			}
+
+			lease.KeyTTLs[key] = int64(ttl.Seconds())
+		}
+	}
+
+	renew(lease, 0, now)
+	return lease, nil
+}
+
+// renew restarts every expiry of the lease from now, by the increment when set or by its own ttl otherwise
+func renew(lease *model.Lease, increment time.Duration, now time.Time) {
+
+	ttl := func(own int64) int64 {
+		if increment > 0 {
+			return int64(increment.Seconds())
+		}
+		return own
+	}
+
+	if lease.TTL > 0 {
+		lease.ExpiresAt = now.Unix() + ttl(lease.TTL)
+	}
+
+	if len(lease.KeyTTLs) > 0 {
+		lease.KeyExpiresAt = map[string]int64{}
+		for key, own := range lease.KeyTTLs {
+			lease.KeyExpiresAt[key] = now.Unix() + ttl(own)
+		}
+	}
+}
+
+// expire drops the expired keys of the secret, telling whether the whole secret expired and which keys were dropped
+func expire(s *model.Secret, now time.Time) (bool, []string) {
+
+	if s.Lease == nil {
+		return false, nil
+	}
+
+	if s.Lease.ExpiresAt > 0 && s.Lease.ExpiresAt <= now.Unix() {
+		return true, nil
+	}
+
+	dropped := []string{}
+	for key, at := range s.Lease.KeyExpiresAt {
+
+		if at > now.Unix() {
+			continue
+		}
+
+		delete(s.Vars, key)
+		delete(s.Lease.KeyExpiresAt, key)
+		delete(s.Lease.KeyTTLs, key)
+		dropped = append(dropped, key)
+	}
+
+	return len(s.Vars) == 0, dropped
+}
+
+// leaseApp returns the app of a lease id, <app> for secret/<app>/<id> and database/<role> for database/<role>/<id>
+func leaseApp(id string) (string, error) {
+
+	if role, ok := auth.DatabaseLeaseRole(id); ok {
+		return auth.DatabaseLeasePrefix + role, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(id, secretLeasePrefix), "/")
+	if !strings.HasPrefix(id, secretLeasePrefix) || len(parts) != 2 || parts[0] == "" {
+		return "", errLeaseNotFound
+	}
+
+	return parts[0], nil
+}
+
+// leaseError maps the lease errors into the proper response status
+func leaseError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case errLeaseNotFound:
+		notFound(w, err)
+	case errBadTTL, errUnknownTTLKey, fs.ErrBadInput:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldLeaseSecret(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "leased-app", "vars": {"TOKEN": "xyz", "PASS": "abc"}, "ttl": "1h", "key_ttls": {"TOKEN": "30m"}}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=leased-app", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	secret, err := decodeSecret(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease := secret.Lease
+	if lease == nil || !strings.HasPrefix(lease.ID, "secret/leased-app/") || lease.TTL != 3600 || lease.KeyTTLs["TOKEN"] != 1800 {
+		t.Fatalf("Bad lease! Got %+v", lease)
+	}
+
+	now := time.Now().Unix()
+	if lease.ExpiresAt < now+3590 || lease.KeyExpiresAt["TOKEN"] < now+1790 {
+		t.Errorf("Bad lease expiry! Got %+v", lease)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/sys/leases/renew", adminToken, `{"lease_id": "`+lease.ID+`", "increment": "2h"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(res.Body.String(), lease.ID) {
+		t.Errorf("Bad renewed lease! Got %s", res.Body.String())
+	}
+
+	s, err := router.readSecret("leased-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Lease.ExpiresAt < now+7190 || s.Lease.KeyExpiresAt["TOKEN"] < now+7190 {
+		t.Errorf("Lease not renewed! Got %+v", s.Lease)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/sys/leases/renew", adminToken, `{"lease_id": "secret/leased-app/unknown"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/rotation/leased-app", adminToken, `{"keys": {"PASS": {"interval": "1h", "generator": {"type": "uuid"}}}}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/sys/leases/revoke", adminToken, `{"lease_id": "`+lease.ID+`"}`)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	// the rotation policies go along with the secret
+	res = serve(router, http.MethodGet, "/v1/rotation/leased-app", adminToken, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Rotation kept after the lease revocation! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/sys/leases/revoke", adminToken, `{"lease_id": "`+lease.ID+`"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Revoked twice! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotLeaseSecretWithBadTTL(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	for _, body := range []string{
+		`{"app": "leased-app", "vars": {"TOKEN": "xyz"}, "ttl": "forever"}`,
+		`{"app": "leased-app", "vars": {"TOKEN": "xyz"}, "ttl": "-1h"}`,
+		`{"app": "leased-app", "vars": {"TOKEN": "xyz"}, "key_ttls": {"PASS": "1h"}}`,
+	} {
+		res := serve(router, http.MethodPost, "/v1/secrets", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldExpireSecrets(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+	past := time.Now().Add(-time.Minute).Unix()
+	future := time.Now().Add(time.Hour).Unix()
+
+	expiredKey := &model.Secret{
+		App:  "partly-expired",
+		Vars: map[string]string{"TOKEN": "xyz", "PASS": "abc"},
+		Lease: &model.Lease{
+			ID:           "secret/partly-expired/1",
+			KeyTTLs:      map[string]int64{"TOKEN": 60, "PASS": 3600},
+			KeyExpiresAt: map[string]int64{"TOKEN": past, "PASS": future},
+		},
+	}
+
+	expired := &model.Secret{
+		App:   "expired",
+		Vars:  map[string]string{"TOKEN": "xyz"},
+		Lease: &model.Lease{ID: "secret/expired/1", TTL: 60, ExpiresAt: past},
+	}
+
+	for _, s := range []*model.Secret{expiredKey, expired, {App: "kept", Vars: map[string]string{"TOKEN": "xyz"}}} {
+		err = router.writeSecret(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = router.writeRotation(&model.Rotation{App: "expired", Keys: map[string]*model.KeyRotation{
+		"TOKEN": {Interval: "1h", Generator: model.Generator{Type: model.GeneratorUUID}, NextRotation: future},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(router, http.MethodGet, "/v1/secrets?app=partly-expired", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	secret, err := decodeSecret(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(secret.Vars, map[string]string{"PASS": "abc"}) {
+		t.Errorf("Expired key disclosed! Got %+v", secret.Vars)
+	}
+
+	// listed ahead of the others, a secret that can not be read does not hold them back
+	sys := fs.NewFileSystem(dataSecrets)
+
+	err = sys.MakeSecret("0-corrupt", []byte("not encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reaped, err := router.ReapSecrets()
+	if err == nil {
+		t.Errorf("Unreadable secret not reported!")
+	}
+
+	if reaped != 2 {
+		t.Errorf("Bad reaped count! Got %v expected %v", reaped, 2)
+	}
+
+	err = sys.DeleteSecret("0-corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=expired", adminToken, "")
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Expired secret still around! Got %v expected %v", res.Code, http.StatusInternalServerError)
+	}
+
+	if _, err = router.readRotation("expired"); err != errRotationNotFound {
+		t.Errorf("Rotation kept after reaping the secret! Got %v", err)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=kept", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	testLockedUser   = "kripto_locked"
+	testLockedPasswd = "locked"
+)
+
+func TestShouldBackOffAndLockAfterFailedLogins(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &model.Credentials{Username: testLockedUser, Password: testLockedPasswd, TokenExpiresIn: time.Hour}
+
+	err = auth.NewLogin(c).AddCredentials(testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	for i := 0; i <= auth.UserFreeAttempts; i++ {
+		if res := loginFrom(router, "192.0.2.10", testLockedUser, "guess"); res.Code != http.StatusUnauthorized {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// past the free attempts even the right password waits, whatever the client
+	res := loginFrom(router, "192.0.2.11", testLockedUser, testLockedPasswd)
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "1" {
+		t.Errorf("Not backing off! Got %v Retry-After %s", res.Code, res.Header().Get("Retry-After"))
+	}
+
+	// the lock is kept in the user record, so a restart or another instance keeps it
+	router = NewRouter(testPassphrase)
+
+	res = loginFrom(router, "192.0.2.10", testLockedUser, "guess")
+	if res.Code != http.StatusTooManyRequests {
+		t.Errorf("User not locked! Got %v expected %v", res.Code, http.StatusTooManyRequests)
+	}
+
+	router = NewRouter(testPassphrase)
+
+	res = loginFrom(router, "192.0.2.10", testLockedUser, testLockedPasswd)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("Locked user logged in! Got %v expected %v", res.Code, http.StatusTooManyRequests)
+	}
+
+	retry, err := strconv.Atoi(res.Header().Get("Retry-After"))
+	if err != nil || retry < 60 || retry > int(auth.LockoutDuration.Seconds()) {
+		t.Errorf("Bad Retry-After! Got %v", res.Header().Get("Retry-After"))
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testLockedUser+"/unlock", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	info := model.UserInfo{}
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.LockedUntil != 0 {
+		t.Errorf("User still locked! Got %+v", info)
+	}
+
+	if res := loginFrom(router, "192.0.2.10", testLockedUser, testLockedPasswd); res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldBackOffPerClient(t *testing.T) {
+
+	c := &model.Credentials{Username: testLockedUser, Password: testLockedPasswd, TokenExpiresIn: time.Hour}
+
+	err := auth.NewLogin(c).AddCredentials(testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	free := auth.ClientFreeAttempts
+	auth.ClientFreeAttempts = 2
+	defer func() { auth.ClientFreeAttempts = free }()
+
+	router := NewRouter(testPassphrase)
+
+	// guessing a different username every time still counts against the client
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if res := loginFrom(router, "198.51.100.7", username, "guess"); res.Code == http.StatusCreated {
+			t.Errorf("Bad status! Got %v", res.Code)
+		}
+	}
+
+	if res := loginFrom(router, "198.51.100.7", testLockedUser, testLockedPasswd); res.Code != http.StatusTooManyRequests {
+		t.Errorf("Client not backing off! Got %v expected %v", res.Code, http.StatusTooManyRequests)
+	}
+
+	if res := loginFrom(router, "198.51.100.8", testLockedUser, testLockedPasswd); res.Code != http.StatusCreated {
+		t.Errorf("Another client throttled! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loginFrom sends the credentials to /v1/authenticate from the client ip
+func loginFrom(router *Router, ip, username, password string) *httptest.ResponseRecorder {
+
+	jc, _ := json.Marshal(&model.Credentials{Username: username, Password: password})
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/authenticate", bytes.NewReader(jc))
+	req.RemoteAddr = ip + ":40000"
+
+	router.Routes().ServeHTTP(res, req)
+	return res
+}
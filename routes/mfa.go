@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var errNotSelf = errors.New("mfa: users only manage their own enrollment")
+
+// EnrollMFA generates a totp secret and recovery codes for the user, shown only once
+// Users enroll themselves and the codes are only asked for on login after ConfirmMFA
+func (router *Router) EnrollMFA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	username := p.ByName("username")
+
+	if !router.self(w, r, "user.mfa.enroll", username) {
+		return
+	}
+
+	enrollment, err := auth.EnrollTOTP(username, router.phrase)
+	audit.Record(username, "user.mfa.enroll", username, err)
+	if err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, enrollment)
+}
+
+// ConfirmMFA enables the enrollment once the user sends a code of the authenticator app
+func (router *Router) ConfirmMFA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	username := p.ByName("username")
+
+	if !router.self(w, r, "user.mfa.confirm", username) {
+		return
+	}
+
+	req := model.TOTPRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(username, "user.mfa.confirm", username, err)
+		badRequest(w, err)
+		return
+	}
+
+	err = auth.ConfirmTOTP(username, req.Code, router.phrase)
+	audit.Record(username, "user.mfa.confirm", username, err)
+	if err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// DisableMFA drops the enrollment of the user
+// Users send a current code to drop their own while admins may drop anyone's, e.g. after a lost device
+func (router *Router) DisableMFA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	username := p.ByName("username")
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		audit.Record("", "user.mfa.disable", username, errNoPrincipal)
+		unauthorized(w, errNoPrincipal)
+		return
+	}
+
+	actor := principal.Username
+
+	if principal.Admin {
+
+		err := auth.DisableTOTP(username, router.phrase)
+		audit.Record(actor, "user.mfa.disable", username, err)
+		if err != nil {
+			mfaError(w, err)
+			return
+		}
+
+		responseHeader(w, http.StatusNoContent)
+		return
+	}
+
+	if !router.self(w, r, "user.mfa.disable", username) {
+		return
+	}
+
+	req := model.TOTPRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "user.mfa.disable", username, err)
+		badRequest(w, err)
+		return
+	}
+
+	err = auth.VerifyMFA(username, req.Code, router.phrase)
+	if err == nil {
+		err = auth.DisableTOTP(username, router.phrase)
+	}
+
+	audit.Record(actor, "user.mfa.disable", username, err)
+	if err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// self checks whether the authenticated principal is the very user logged in with its password
+// On failure the proper status is written, the attempt is audited and false is returned
+func (router *Router) self(w http.ResponseWriter, r *http.Request, action, username string) bool {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		audit.Record("", action, username, errNoPrincipal)
+		unauthorized(w, errNoPrincipal)
+		return false
+	}
+
+	if principal.Method != "" || principal.Username != username {
+		audit.Record(principal.Username, action, username, errNotSelf)
+		forbidden(w, errNotSelf)
+		return false
+	}
+
+	return true
+}
+
+func mfaError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrInvalidMFACode, auth.ErrMFARequired:
+		unauthorized(w, err)
+	case auth.ErrMFAEnabled:
+		conflict(w, err)
+	case auth.ErrMFANotEnrolled:
+		notFound(w, err)
+	default:
+		userError(w, err)
+	}
+}
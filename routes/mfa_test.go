@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	testMFAUser   = "kripto_mfa"
+	testMFAPasswd = "mfa"
+)
+
+func TestShouldRequireTOTPOnceEnrolled(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &model.Credentials{Username: testMFAUser, Password: testMFAPasswd, TokenExpiresIn: time.Hour}
+
+	err = auth.NewLogin(c).AddCredentials(testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users/"+testMFAUser+"/mfa", adminToken, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Admin enrolled another user! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testMFAUser+"/mfa", userToken, "")
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	enrollment := model.TOTPEnrollment{}
+	err = json.NewDecoder(res.Body).Decode(&enrollment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(enrollment.RecoveryCodes) != 10 || enrollment.URI == "" {
+		t.Fatalf("Bad enrollment! Got %+v", enrollment)
+	}
+
+	// nothing changes on login until the enrollment is confirmed
+	if res := loginWithCode(router, testMFAUser, testMFAPasswd, ""); res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	totp := algo.NewTOTP(enrollment.Secret)
+	now := time.Now()
+
+	current, err := totp.Code(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := totp.Code(now.Add(algo.TOTPPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testMFAUser+"/mfa/confirm", userToken, `{"code": "000000x"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testMFAUser+"/mfa/confirm", userToken, `{"code": "`+current+`"}`)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = loginWithCode(router, testMFAUser, testMFAPasswd, "")
+	if res.Code != http.StatusUnauthorized || res.Header().Get("X-Kripto-MFA") != "required" {
+		t.Errorf("Code not required! Got %v %v", res.Code, res.Header())
+	}
+
+	refused := map[string]string{
+		"a wrong code":         "123456x",
+		"an already used code": current,
+	}
+
+	for name, code := range refused {
+		if res := loginWithCode(router, testMFAUser, testMFAPasswd, code); res.Code != http.StatusUnauthorized {
+			t.Errorf("Login with %s accepted! Got %v expected %v", name, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if res := loginWithCode(router, testMFAUser, "guess", next); res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad password accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	if res := loginWithCode(router, testMFAUser, testMFAPasswd, next); res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	recovery := enrollment.RecoveryCodes[0]
+
+	if res := loginWithCode(router, testMFAUser, testMFAPasswd, recovery); res.Code != http.StatusCreated {
+		t.Errorf("Recovery code refused! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	if res := loginWithCode(router, testMFAUser, testMFAPasswd, recovery); res.Code != http.StatusUnauthorized {
+		t.Errorf("Recovery code used twice! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/users/"+testMFAUser, adminToken, "")
+	info := model.UserInfo{}
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.MFA {
+		t.Errorf("Enrollment not shown! Got %+v", info)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testMFAUser+"/mfa", userToken, "")
+	if res.Code != http.StatusConflict {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusConflict)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/users/"+testMFAUser+"/mfa", userToken, `{"code": "123456x"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Enrollment dropped without a code! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/users/"+testMFAUser+"/mfa", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	if res := loginWithCode(router, testMFAUser, testMFAPasswd, ""); res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loginWithCode(router *Router, username, password, code string) *httptest.ResponseRecorder {
+
+	jc, _ := json.Marshal(&model.Credentials{Username: username, Password: password, Code: code})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/authenticate", bytes.NewReader(jc))
+
+	router.Authenticate(res, req, nil)
+	return res
+}
@@ -0,0 +1,168 @@
+package routes
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type contextKey string
+
+const (
+	principalKey contextKey = "principal"
+	bearerScheme            = "bearer"
+)
+
+var (
+	errMissingToken  = errors.New("auth: missing bearer token")
+	errNoPrincipal   = errors.New("auth: no authenticated principal")
+	errUnknownMethod = errors.New("auth: unknown auth method")
+	errNoCertificate = errors.New("auth: no verified client certificate")
+)
+
+// Authenticated is the middleware protecting every non public route
+// It parses the bearer token, rejects anything not strictly valid and puts the principal into the request context
+func (router *Router) Authenticated(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+		principal, err := router.authenticate(r)
+		if err != nil {
+			audit.Record("", "auth.token", r.URL.Path, err)
+			unauthorized(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		h(w, r.WithContext(ctx), p)
+	}
+}
+
+// PrincipalFromContext returns the principal put into the request context by the Authenticated middleware
+func PrincipalFromContext(ctx context.Context) (*model.Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*model.Principal)
+	return principal, ok && principal != nil
+}
+
+// authenticate validates the bearer token and resolves the enabled user owning it
+// Requests sending an api key are authenticated by it instead of a token
+// Requests without a token are authenticated by their verified client certificate, when there is one
+func (router *Router) authenticate(r *http.Request) (*model.Principal, error) {
+
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return router.apiKeyPrincipal(r, key)
+	}
+
+	authorization := r.Header.Get("Authorization")
+
+	cert := clientCertificate(r)
+	if authorization == "" && cert != nil {
+
+		role, err := auth.MatchCertRole(cert, router.phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		return auth.CertRolePrincipal(role), nil
+	}
+
+	tokenString, err := bearerToken(authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := auth.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return router.tokenPrincipal(claims)
+}
+
+// tokenPrincipal resolves the principal of already validated claims
+// Tokens of users are refused once the user is disabled or removed
+func (router *Router) tokenPrincipal(claims *model.CustomClaims) (*model.Principal, error) {
+
+	if claims.Method != "" {
+		return router.machinePrincipal(claims)
+	}
+
+	u, err := router.readUser(claims.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Disabled {
+		return nil, errUserDisabled
+	}
+
+	principal := u.Principal()
+	principal.TokenID = claims.Id
+	principal.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+
+	return principal, nil
+}
+
+// machinePrincipal builds the principal of a token issued to a machine out of its claims
+// The token is refused once the machine identity behind it is gone
+func (router *Router) machinePrincipal(claims *model.CustomClaims) (*model.Principal, error) {
+
+	switch claims.Method {
+	case model.MethodAppRole:
+		name := strings.TrimPrefix(claims.Username, auth.AppRolePrincipal(""))
+
+		_, err := auth.ReadAppRole(name, router.phrase)
+		if err != nil {
+			return nil, err
+		}
+	case model.MethodCert:
+		name := strings.TrimPrefix(claims.Username, auth.CertPrincipal(""))
+
+		_, err := auth.ReadCertRole(name, router.phrase)
+		if err != nil {
+			return nil, err
+		}
+	case model.MethodOIDC, model.MethodLDAP:
+		// the roles are resolved on every request, removing them is enough to cut access
+	default:
+		return nil, errUnknownMethod
+	}
+
+	return &model.Principal{
+		Username:  claims.Username,
+		Roles:     claims.Roles,
+		Policies:  claims.Policies,
+		Method:    claims.Method,
+		TokenID:   claims.Id,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// clientCertificate returns the client certificate verified against the client CA, nil when there is none
+func clientCertificate(r *http.Request) *x509.Certificate {
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// bearerToken extracts the token from an Authorization header in the Bearer <token> form
+func bearerToken(authorization string) (string, error) {
+
+	parts := strings.Fields(authorization)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != bearerScheme {
+		return "", errMissingToken
+	}
+
+	return parts[1], nil
+}
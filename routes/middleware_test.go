@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ffhenkes/kripto/auth"
+)
+
+func TestShouldRejectBadTokens(t *testing.T) {
+
+	err := before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"Username": testUser}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"missing":   "",
+		"no scheme": userToken,
+		"basic":     "Basic " + userToken,
+		"malformed": "Bearer not.a.token",
+		"garbage":   "Bearer ###",
+		"unsigned":  "Bearer " + unsigned,
+		"tampered":  "Bearer " + userToken + "x",
+	}
+
+	router := NewRouter(testPassphrase)
+
+	for name, authorization := range cases {
+
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/apps", nil)
+		req.Header.Add("Authorization", authorization)
+
+		router.Routes().ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Bad status for %s token! Got %v expected %v", name, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	res := serve(router, http.MethodGet, "/v1/apps", userToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRejectTokensOfDisabledUsers(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users/"+testUser+"/disable", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", userToken, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// OIDCLogin trades an id token of the configured identity provider for a token carrying the mapped roles
+func (router *Router) OIDCLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	login := model.OIDCLogin{}
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	session, err := auth.LoginOIDC(login.IDToken, router.phrase)
+	audit.Record("", "auth.oidc.login", "", err)
+
+	switch err {
+	case nil:
+		responseJSON(w, http.StatusCreated, session)
+	case auth.ErrInvalidIDToken, auth.ErrNoRoles:
+		unauthorized(w, err)
+	case auth.ErrOIDCNotConfigured:
+		notFound(w, err)
+	default:
+		serverError(w, err)
+	}
+}
+
+// WriteOIDCConfig replaces the identity provider configuration, only admins are allowed to do so
+func (router *Router) WriteOIDCConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "oidc.write", "")
+	if !ok {
+		return
+	}
+
+	config := model.OIDCConfig{}
+
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		audit.Record(actor, "oidc.write", "", err)
+		badRequest(w, err)
+		return
+	}
+
+	err = auth.WriteOIDCConfig(&config, router.phrase)
+	audit.Record(actor, "oidc.write", config.Issuer, err)
+	if err == auth.ErrBadOIDCConfig {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, config)
+}
+
+// GetOIDCConfig returns the identity provider configuration, only admins are allowed to do so
+func (router *Router) GetOIDCConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "oidc.read", "")
+	if !ok {
+		return
+	}
+
+	config, err := auth.ReadOIDCConfig(router.phrase)
+	audit.Record(actor, "oidc.read", "", err)
+	if err == auth.ErrOIDCNotConfigured {
+		notFound(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, config)
+}
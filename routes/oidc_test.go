@@ -0,0 +1,181 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const testIDPKey = "idp-1"
+
+func TestShouldLoginWithOIDC(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := mockIssuer(t, key)
+	defer issuer.Close()
+
+	router := NewRouter(testPassphrase)
+
+	config := `{"issuer": "` + issuer.URL + `", "audience": "kripto-cli", "username_claim": "email", "group_roles": {"devs": ["developers"]}}`
+
+	res := serve(router, http.MethodPut, "/v1/auth/oidc/config", adminToken, config)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/policies/payments", adminToken, `{"rules": [{"apps": "payments-*", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/roles/developers", adminToken, `{"policies": ["payments"]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	for _, app := range []string{"payments-api", "billing"} {
+		res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "`+app+`", "vars": {"TOKEN": "xyz"}}`)
+		if res.Code != http.StatusCreated {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+		}
+	}
+
+	now := time.Now()
+	valid := jwt.MapClaims{
+		"iss":    issuer.URL,
+		"aud":    []string{"kripto-cli", "other"},
+		"sub":    "00u1",
+		"email":  "dev@example.com",
+		"groups": []string{"devs", "everyone"},
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/oidc/login", "", oidcLogin(t, key, testIDPKey, valid))
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	session := decodeSession(t, res.Body)
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=payments-api", session.Token, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Mapped role not granted! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=billing", session.Token, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	rogue, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused := map[string]string{
+		"another audience": oidcLogin(t, key, testIDPKey, with(valid, "aud", "someone-else")),
+		"another issuer":   oidcLogin(t, key, testIDPKey, with(valid, "iss", "https://evil.example.com")),
+		"expired":          oidcLogin(t, key, testIDPKey, with(valid, "exp", now.Add(-time.Hour).Unix())),
+		"no mapped group":  oidcLogin(t, key, testIDPKey, with(valid, "groups", []string{"everyone"})),
+		"another key":      oidcLogin(t, rogue, testIDPKey, valid),
+		"unknown key":      oidcLogin(t, rogue, "idp-2", valid),
+	}
+
+	for name, login := range refused {
+		res = serve(router, http.MethodPost, "/v1/auth/oidc/login", "", login)
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Id token of %s accepted! Got %v expected %v", name, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	for _, app := range []string{"payments-api", "billing"} {
+		err = sys.DeleteSecret(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mockIssuer serves the discovery document and the jwks of an identity provider holding the key
+func mockIssuer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, model.JWKS{Keys: []model.JWK{{
+			Kty: "RSA",
+			Kid: testIDPKey,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	return server
+}
+
+// oidcLogin returns the login payload of an id token signed by the key
+func oidcLogin(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return `{"id_token": "` + idToken + `"}`
+}
+
+// with returns a copy of the claims overriding one of them
+func with(claims jwt.MapClaims, name string, value interface{}) jwt.MapClaims {
+
+	copied := jwt.MapClaims{}
+	for k, v := range claims {
+		copied[k] = v
+	}
+
+	copied[name] = value
+	return copied
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		t.Error(err)
+	}
+}
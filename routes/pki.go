@@ -0,0 +1,296 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	pkiAppPrefix   = "pki/"
+	pemContentType = "application/x-pem-file"
+	crlContentType = "application/pkix-crl"
+)
+
+// GenerateRootCA creates the self signed kripto CA, only admins are allowed to do so
+func (router *Router) GenerateRootCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	router.writeCA(w, r, "pki.root.generate", auth.GenerateRootCA)
+}
+
+// GenerateIntermediateCA creates the key of an intermediate CA returning the csr to be signed elsewhere, only admins are allowed to do so
+func (router *Router) GenerateIntermediateCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	router.writeCA(w, r, "pki.intermediate.generate", auth.GenerateIntermediateCSR)
+}
+
+// SetSignedIntermediateCA installs the signed certificate of the intermediate CA, only admins are allowed to do so
+func (router *Router) SetSignedIntermediateCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	router.writeCA(w, r, "pki.intermediate.set", auth.SetSignedIntermediate)
+}
+
+// ImportCA installs a CA out of the pem bundle holding its certificate and private key, only admins are allowed to do so
+func (router *Router) ImportCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	router.writeCA(w, r, "pki.ca.import", auth.ImportCA)
+}
+
+// GetCA returns the certificate of the kripto CA along with the chain up to the root, it is public
+func (router *Router) GetCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	ca, err := auth.ReadCA(router.phrase)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, ca)
+}
+
+// GetCAPEM returns the certificate of the kripto CA followed by the chain up to the root pem encoded, ready to be trusted
+func (router *Router) GetCAPEM(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	ca, err := auth.ReadCA(router.phrase)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseRaw(w, http.StatusOK, pemContentType, []byte(ca.Certificate+strings.Join(ca.CAChain, "")))
+}
+
+// RemoveCA drops the kripto CA along with the records of the certificates it issued, only admins are allowed to do so
+func (router *Router) RemoveCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "pki.ca.delete", "")
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveCA()
+	audit.Record(actor, "pki.ca.delete", "", err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// WritePKIRole creates or replaces the pki role named in the path, only admins are allowed to do so
+func (router *Router) WritePKIRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "pki.role.write", name)
+	if !ok {
+		return
+	}
+
+	role := model.PKIRole{}
+
+	err := json.NewDecoder(r.Body).Decode(&role)
+	if err != nil {
+		audit.Record(actor, "pki.role.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	role.Name = name
+
+	err = auth.WritePKIRole(&role, router.phrase)
+	audit.Record(actor, "pki.role.write", name, err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// GetPKIRole returns the pki role named in the path, only admins are allowed to do so
+func (router *Router) GetPKIRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "pki.role.read", name)
+	if !ok {
+		return
+	}
+
+	role, err := auth.ReadPKIRole(name, router.phrase)
+	audit.Record(actor, "pki.role.read", name, err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// RemovePKIRole drops the pki role named in the path, only admins are allowed to do so
+func (router *Router) RemovePKIRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "pki.role.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemovePKIRole(name)
+	audit.Record(actor, "pki.role.delete", name, err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// IssueCertificate signs a new leaf certificate, along with its private key, through the role named in the path
+// Whoever may write the pki/<role> app is allowed to do so, every name asked for must be allowed by the role
+func (router *Router) IssueCertificate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("role")
+	app := pkiAppPrefix + name
+
+	actor, ok := router.allowed(w, r, "pki.issue", app, model.CapabilityWrite)
+	if !ok {
+		return
+	}
+
+	req := model.IssueRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "pki.issue", app, err)
+		badRequest(w, err)
+		return
+	}
+
+	issued, err := auth.IssueCertificate(name, &req, router.phrase)
+	if err != nil {
+		audit.Record(actor, "pki.issue", app, err)
+		pkiError(w, err)
+		return
+	}
+
+	audit.Record(actor, "pki.issue", app+"/"+issued.SerialNumber, nil)
+	responseJSON(w, http.StatusOK, issued)
+}
+
+// RevokeCertificate revokes the certificate with the serial number of the body, only admins are allowed to do so
+func (router *Router) RevokeCertificate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "pki.revoke", "")
+	if !ok {
+		return
+	}
+
+	req := model.CertRevokeRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "pki.revoke", "", err)
+		badRequest(w, err)
+		return
+	}
+
+	revoked, err := auth.RevokeCertificate(req.SerialNumber, router.phrase)
+	audit.Record(actor, "pki.revoke", req.SerialNumber, err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, revoked)
+}
+
+// GetCRL returns the der encoded revocation list of the kripto CA, it is public
+func (router *Router) GetCRL(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	crl, err := auth.ReadCRL(router.phrase)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseRaw(w, http.StatusOK, crlContentType, crl)
+}
+
+// GetCRLPEM returns the pem encoded revocation list of the kripto CA, it is public
+func (router *Router) GetCRLPEM(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	crl, err := auth.ReadCRL(router.phrase)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseRaw(w, http.StatusOK, pemContentType, auth.EncodeCRL(crl))
+}
+
+// RotateCRL signs a new revocation list at once, only admins are allowed to do so
+func (router *Router) RotateCRL(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "pki.crl.rotate", "")
+	if !ok {
+		return
+	}
+
+	_, err := auth.RotateCRL(router.phrase)
+	audit.Record(actor, "pki.crl.rotate", "", err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// writeCA decodes the CA request handing it to the CA operation, only admins are allowed to do so
+func (router *Router) writeCA(w http.ResponseWriter, r *http.Request, action string, operation func(*model.CARequest, string) (*model.CA, error)) {
+
+	actor, ok := router.administrator(w, r, action, "")
+	if !ok {
+		return
+	}
+
+	req := model.CARequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, action, "", err)
+		badRequest(w, err)
+		return
+	}
+
+	ca, err := operation(&req, router.phrase)
+	audit.Record(actor, action, req.CommonName, err)
+	if err != nil {
+		pkiError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, ca)
+}
+
+// pkiError maps the pki engine errors into the proper response status
+func pkiError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrCANotFound, auth.ErrPKIRoleNotFound, auth.ErrCertificateNotFound:
+		notFound(w, err)
+	case auth.ErrBadCA, auth.ErrBadPKIRole, auth.ErrBadIssueRequest, auth.ErrNameNotAllowed, fs.ErrBadInput:
+		badRequest(w, err)
+	case auth.ErrCAExists:
+		conflict(w, err)
+	default:
+		serverError(w, err)
+	}
+}
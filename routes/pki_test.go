@@ -0,0 +1,397 @@
+package routes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldIssueCertificates(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodGet, "/v1/pki/ca", "", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	for _, body := range []string{`{}`, `{"common_name": "Kripto Root", "key_type": "dsa"}`, `{"common_name": "Kripto Root", "ttl": "forever"}`} {
+		res = serve(router, http.MethodPost, "/v1/pki/root/generate", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/root/generate", adminToken, `{"common_name": "Kripto Root", "key_type": "ed25519"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if strings.Contains(res.Body.String(), "PRIVATE KEY") {
+		t.Errorf("CA private key disclosed! %s", res.Body.String())
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/root/generate", adminToken, `{"common_name": "Another Root"}`)
+	if res.Code != http.StatusConflict {
+		t.Errorf("CA replaced! Got %v expected %v", res.Code, http.StatusConflict)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/pki/ca/pem", "", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	ca := parseCertificate(t, res.Body.Bytes())
+	if !ca.IsCA || ca.Subject.CommonName != "Kripto Root" {
+		t.Errorf("Bad CA! Got %+v", ca.Subject)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	for _, body := range []string{`{"allowed_domains": []}`, `{"allowed_domains": ["*.svc.example.com"], "key_type": "dsa"}`,
+		`{"allowed_domains": ["*.svc.example.com"], "ttl": "48h", "max_ttl": "24h"}`} {
+		res = serve(router, http.MethodPut, "/v1/pki/roles/web", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	for _, put := range [][2]string{
+		{"/v1/pki/roles/web", `{"allowed_domains": ["*.svc.example.com"], "server_flag": true, "client_flag": true, "ttl": "1h", "max_ttl": "24h"}`},
+		{"/v1/policies/web-certs", `{"rules": [{"apps": "pki/web", "capabilities": ["write"]}]}`},
+	} {
+		res = serve(router, http.MethodPut, put[0], adminToken, put[1])
+		if res.Code != http.StatusOK {
+			t.Fatalf("Bad status for %s! Got %v expected %v", put[0], res.Code, http.StatusOK)
+		}
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := `{"common_name": "api.svc.example.com", "alt_names": ["api-v2.svc.example.com"], "ttl": "48h"}`
+
+	res = serve(router, http.MethodPost, "/v1/pki/issue/web", userToken, issue)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPatch, "/v1/users/"+testUser, adminToken, `{"policies": ["web-certs"]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	for _, body := range []string{`{"common_name": "api.example.org"}`, `{"common_name": "api.svc.example.com", "ip_sans": ["10.0.0.1"]}`,
+		`{"common_name": ""}`, `{"common_name": "api.svc.example.com", "ttl": "soon"}`,
+		`{"common_name": "*.svc.example.com"}`, `{"common_name": "a.b.svc.example.com"}`, `{"common_name": "x y.svc.example.com"}`,
+		`{"common_name": "api.svc.example.com", "alt_names": ["-api.svc.example.com"]}`, `{"common_name": "api*.svc.example.com"}`} {
+		res = serve(router, http.MethodPost, "/v1/pki/issue/web", userToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/issue/web", userToken, issue)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	// wildcard names are issued once the role opts in
+	wildcard := serve(router, http.MethodPut, "/v1/pki/roles/wildcard", adminToken, `{"allowed_domains": ["*.svc.example.com"], "allow_wildcards": true}`)
+	if wildcard.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", wildcard.Code, http.StatusOK)
+	}
+
+	wildcard = serve(router, http.MethodPost, "/v1/pki/issue/wildcard", adminToken, `{"common_name": "*.svc.example.com"}`)
+	if wildcard.Code != http.StatusOK {
+		t.Errorf("Wildcard name refused! Got %v expected %v", wildcard.Code, http.StatusOK)
+	}
+
+	wildcard = serve(router, http.MethodPost, "/v1/pki/issue/wildcard", adminToken, `{"common_name": "*.*.svc.example.com"}`)
+	if wildcard.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", wildcard.Code, http.StatusBadRequest)
+	}
+
+	issued := model.IssuedCertificate{}
+	err = json.NewDecoder(res.Body).Decode(&issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tls.X509KeyPair([]byte(issued.Certificate), []byte(issued.PrivateKey))
+	if err != nil {
+		t.Errorf("Private key does not match the certificate! %v", err)
+	}
+
+	leaf := parseCertificate(t, []byte(issued.Certificate))
+
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "api-v2.svc.example.com", KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("Bad leaf certificate! %v", err)
+	}
+
+	if lifetime := leaf.NotAfter.Sub(leaf.NotBefore); lifetime > 24*time.Hour+auth.ClockSkew {
+		t.Errorf("TTL above the role max! Got %v", lifetime)
+	}
+
+	if len(issued.CAChain) != 1 || issued.IssuingCA != issued.CAChain[0] {
+		t.Errorf("Bad CA chain! Got %v", issued.CAChain)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/revoke", userToken, `{"serial_number": "`+issued.SerialNumber+`"}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/revoke", adminToken, `{"serial_number": "abcdef"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/revoke", adminToken, `{"serial_number": "`+strings.ToUpper(issued.SerialNumber)+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/pki/crl", "", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	crl, err := x509.ParseRevocationList(res.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = crl.CheckSignatureFrom(ca)
+	if err != nil {
+		t.Errorf("Bad CRL signature! %v", err)
+	}
+
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("Revoked certificate left out of the CRL! Got %+v", crl.RevokedCertificateEntries)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/pki/crl/pem", "", "")
+	if res.Code != http.StatusOK || !strings.HasPrefix(res.Body.String(), "-----BEGIN X509 CRL-----") {
+		t.Errorf("Bad pem CRL! Got %v %s", res.Code, res.Body.String())
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldInstallIntermediateCA(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	// the root lives outside of kripto
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Offline Root"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	root := parseCertificate(t, []byte(rootPEM))
+
+	res := serve(router, http.MethodPost, "/v1/pki/intermediate/set-signed", adminToken, `{"certificate": "`+jsonEscape(rootPEM)+`"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/intermediate/generate", adminToken, `{"common_name": "Kripto Intermediate", "key_type": "rsa"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	pending := model.CA{}
+	err = json.NewDecoder(res.Body).Decode(&pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode([]byte(pending.CSR))
+	if block == nil {
+		t.Fatalf("Bad csr! Got %s", pending.CSR)
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               csr.Subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(12 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, root, csr.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	res = serve(router, http.MethodPost, "/v1/pki/intermediate/set-signed", adminToken, `{"certificate": "`+jsonEscape(rootPEM)+`"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Certificate of another key installed! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/intermediate/set-signed", adminToken, `{"certificate": "`+jsonEscape(signed+rootPEM)+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/pki/roles/internal", adminToken, `{"allowed_domains": ["*.internal"], "allow_ip_sans": true, "key_type": "rsa"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/issue/internal", adminToken, `{"common_name": "db.internal", "ip_sans": ["10.0.0.7"], "ttl": "720h"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	issued := model.IssuedCertificate{}
+	err = json.NewDecoder(res.Body).Decode(&issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issued.CAChain) != 2 {
+		t.Fatalf("Bad CA chain! Got %v", issued.CAChain)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	intermediate := parseCertificate(t, []byte(issued.IssuingCA))
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+
+	leaf := parseCertificate(t, []byte(issued.Certificate))
+
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "10.0.0.7"})
+	if err != nil {
+		t.Errorf("Bad leaf certificate! %v", err)
+	}
+
+	if leaf.NotAfter.After(intermediate.NotAfter) {
+		t.Errorf("Leaf outlives its CA! Got %v", leaf.NotAfter)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/pki/ca", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	// the offline root may be imported as is along with its key
+	keyDER, err := x509.MarshalECPrivateKey(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	res = serve(router, http.MethodPost, "/v1/pki/ca/import", adminToken, `{"pem_bundle": "`+jsonEscape(signed+keyPEM)+`"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Certificate of another key imported! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/ca/import", adminToken, `{"pem_bundle": "`+jsonEscape(rootPEM+keyPEM)+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/pki/issue/internal", adminToken, `{"common_name": "cache.internal"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parseCertificate(t, []byte(issued.Certificate)).Verify(x509.VerifyOptions{Roots: roots, DNSName: "cache.internal"})
+	if err != nil {
+		t.Errorf("Bad leaf certificate! %v", err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func parseCertificate(t *testing.T, data []byte) *x509.Certificate {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("Bad pem certificate! Got %s", data)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
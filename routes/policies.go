@@ -0,0 +1,341 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	errNotAllowed  = errors.New("policy: capability not granted")
+	errAppMismatch = errors.New("policy: query and body apps differ")
+	errMissingApp  = errors.New("policy: app required")
+)
+
+// Authorize is a middleware that only lets the request through when the authenticated principal
+// holds the capability over the requested app, the app is taken from the query or the json body
+func (router *Router) Authorize(capability string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+		app, err := appFromRequest(r)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+
+		// an empty app would be granted by any * rule and name no secret at all
+		if app == "" {
+			badRequest(w, errMissingApp)
+			return
+		}
+
+		if _, ok := router.allowed(w, r, "secret."+capability, app, capability); !ok {
+			return
+		}
+
+		h(w, r, p)
+	}
+}
+
+// allowed checks whether the authenticated principal holds the capability over the app
+// On failure the proper status is written, the attempt is audited and false is returned
+func (router *Router) allowed(w http.ResponseWriter, r *http.Request, action, app, capability string) (string, bool) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		audit.Record("", action, app, errNoPrincipal)
+		unauthorized(w, errNoPrincipal)
+		return "", false
+	}
+
+	ok, err := auth.Allowed(principal, app, capability, router.phrase)
+	if err != nil {
+		serverError(w, err)
+		return "", false
+	}
+
+	if !ok {
+		audit.Record(principal.Username, action, app, errNotAllowed)
+		forbidden(w, errNotAllowed)
+		return "", false
+	}
+
+	return principal.Username, true
+}
+
+// ListApps returns the apps holding secrets that the authenticated principal is allowed to list
+func (router *Router) ListApps(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		unauthorized(w, errNoPrincipal)
+		return
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	apps, err := sys.ListSecrets()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	policies := []*model.Policy{}
+	if !principal.Admin {
+		policies, err = auth.EffectivePolicies(principal, router.phrase)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+
+	allowed := []string{}
+	for _, app := range apps {
+		if principal.Admin || auth.Grants(policies, app, model.CapabilityList) {
+			allowed = append(allowed, app)
+		}
+	}
+
+	responseJSON(w, http.StatusOK, allowed)
+}
+
+// WritePolicy creates or replaces the policy named in the path, only admins are allowed to do so
+func (router *Router) WritePolicy(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "policy.write", name)
+	if !ok {
+		return
+	}
+
+	policy := model.Policy{}
+
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		audit.Record(actor, "policy.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	policy.Name = name
+
+	err = auth.ValidatePolicy(&policy)
+	if err != nil {
+		audit.Record(actor, "policy.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	err = auth.WritePolicy(&policy, router.phrase)
+	audit.Record(actor, "policy.write", name, err)
+	if err != nil {
+		policyError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, policy)
+}
+
+// GetPolicy returns the policy named in the path, only admins are allowed to do so
+func (router *Router) GetPolicy(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "policy.read", name)
+	if !ok {
+		return
+	}
+
+	policy, err := auth.ReadPolicy(name, router.phrase)
+	audit.Record(actor, "policy.read", name, err)
+	if err != nil {
+		policyError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, policy)
+}
+
+// ListPolicies returns every policy, only admins are allowed to do so
+func (router *Router) ListPolicies(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "policy.list", "")
+	if !ok {
+		return
+	}
+
+	policies, err := auth.ListPolicies(router.phrase)
+	audit.Record(actor, "policy.list", "", err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, policies)
+}
+
+// RemovePolicy drops the policy named in the path, only admins are allowed to do so
+func (router *Router) RemovePolicy(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "policy.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemovePolicy(name)
+	audit.Record(actor, "policy.delete", name, err)
+	if err != nil {
+		policyError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// WriteRole creates or replaces the role named in the path, only admins are allowed to do so
+func (router *Router) WriteRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "role.write", name)
+	if !ok {
+		return
+	}
+
+	role := model.Role{}
+
+	err := json.NewDecoder(r.Body).Decode(&role)
+	if err != nil {
+		audit.Record(actor, "role.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	role.Name = name
+
+	err = auth.WriteRole(&role, router.phrase)
+	audit.Record(actor, "role.write", name, err)
+	if err != nil {
+		policyError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// GetRole returns the role named in the path, only admins are allowed to do so
+func (router *Router) GetRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "role.read", name)
+	if !ok {
+		return
+	}
+
+	role, err := auth.ReadRole(name, router.phrase)
+	audit.Record(actor, "role.read", name, err)
+	if err != nil {
+		policyError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// ListRoles returns every role, only admins are allowed to do so
+func (router *Router) ListRoles(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "role.list", "")
+	if !ok {
+		return
+	}
+
+	roles, err := auth.ListRoles(router.phrase)
+	audit.Record(actor, "role.list", "", err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, roles)
+}
+
+// RemoveRole drops the role named in the path, only admins are allowed to do so
+func (router *Router) RemoveRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "role.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveRole(name)
+	audit.Record(actor, "role.delete", name, err)
+	if err != nil {
+		policyError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// appFromRequest looks for the app into the query string and the json body, both must agree when both are set
+// so the app authorized is the one the handler acts on, the body is restored so the next handler is still able to decode it
+func appFromRequest(r *http.Request) (string, error) {
+
+	app := r.URL.Query().Get("app")
+	if r.Body == nil {
+		return app, nil
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	secret := model.Secret{}
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &secret)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case secret.App == "":
+		return app, nil
+	case app != "" && app != secret.App:
+		return "", errAppMismatch
+	}
+
+	return secret.App, nil
+}
+
+// policyError maps the policy storage errors into the proper response status
+func policyError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrPolicyNotFound, auth.ErrRoleNotFound:
+		notFound(w, err)
+	case fs.ErrBadInput:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+)
+
+func TestShouldEnforcePolicies(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/payments", adminToken, `{"rules": [{"apps": "payments-*", "capabilities": ["read", "list"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/roles/developers", adminToken, `{"policies": ["payments"]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPatch, "/v1/users/"+testUser, adminToken, `{"roles": ["developers"]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := `{"app": "%s", "vars": {"SAMPLE_PASSWD": "onesamplepassword"}}`
+
+	res = serve(router, http.MethodPost, "/v1/secrets", userToken, fmt.Sprintf(secret, "payments-api"))
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	for _, app := range []string{"payments-api", "billing"} {
+		res = serve(router, http.MethodPost, "/v1/secrets", adminToken, fmt.Sprintf(secret, app))
+		if res.Code != http.StatusCreated {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+		}
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=payments-api", userToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=billing", userToken, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", userToken, "")
+	apps := []string{}
+	err = json.NewDecoder(res.Body).Decode(&apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apps) != 1 || apps[0] != "payments-api" {
+		t.Errorf("Bad app list! Got %v expected %v", apps, []string{"payments-api"})
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	for _, app := range []string{"payments-api", "billing"} {
+		err = sys.DeleteSecret(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldAuthorizeTheWrittenApp(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/mine", adminToken, `{"rules": [{"apps": "mine", "capabilities": ["write"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPatch, "/v1/users/"+testUser, adminToken, `{"policies": ["mine"]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	err = sys.DeleteSecret("victim")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	// the query app must not vouch for another app in the body
+	res = serve(router, http.MethodPost, "/v1/secrets?app=mine", userToken, `{"app": "victim", "vars": {"SAMPLE_PASSWD": "hijacked"}}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	_, err = sys.ReadSecret("victim")
+	if !os.IsNotExist(err) {
+		t.Errorf("Secret written to an app not authorized! Got %v", err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/secrets?app=mine", userToken, `{"app": "mine", "vars": {"SAMPLE_PASSWD": "mine"}}`)
+	if res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	// an empty app names no secret, even for whoever is granted every app
+	for _, req := range [][3]string{
+		{http.MethodPost, "/v1/secrets", `{"vars": {"SAMPLE_PASSWD": "nameless"}}`},
+		{http.MethodGet, "/v1/secrets?app=", ""},
+		{http.MethodDelete, "/v1/secrets", ""},
+	} {
+		res = serve(router, req[0], req[1], adminToken, req[2])
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s %s! Got %v expected %v", req[0], req[1], res.Code, http.StatusBadRequest)
+		}
+	}
+
+	_, err = sys.ReadSecret("")
+	if !os.IsNotExist(err) && err != fs.ErrBadInput {
+		t.Errorf("Nameless secret written! Got %v", err)
+	}
+
+	err = sys.DeleteSecret("mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotWriteBadPolicy(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/bad", adminToken, `{"rules": [{"apps": "*", "capabilities": ["sudo"]}]}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldMatchPatterns(t *testing.T) {
+
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"payments-*", "payments-api", true},
+		{"payments-*", "payments", false},
+		{"*", "anything", true},
+		{"*-api", "billing-api", true},
+		{"billing", "billing", true},
+		{"bill.ng", "billing", false},
+	}
+
+	for _, tc := range cases {
+		if auth.MatchPattern(tc.pattern, tc.name) != tc.match {
+			t.Errorf("Bad match! %s over %s expected %t", tc.pattern, tc.name, tc.match)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	kindRotation       = "rotation"
+	minRotation        = time.Minute
+	rotationActor      = "kripto"
+	webhookContentType = "application/json"
+)
+
+var (
+	errBadRotation      = errors.New("rotation: keys with an interval of at least 1m required")
+	errBadWebhook       = errors.New("rotation: webhook must be an http or https url")
+	errRotationNotFound = errors.New("rotation: not found")
+)
+
+// secretRecords serializes every write and removal of secret records, and of their rotation policies, against each other
+var secretRecords sync.Mutex
+
+// webhookClient posts the rotation events, a slow consumer does not hold the scheduler for long
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// WriteRotation creates or replaces the rotation policies of the app named in the path
+// Whoever may write the secrets of the app is allowed to do so, keys keep their schedule unless their interval changes
+func (router *Router) WriteRotation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	app := p.ByName("app")
+
+	actor, ok := router.allowed(w, r, "rotation.write", app, model.CapabilityWrite)
+	if !ok {
+		return
+	}
+
+	rotation := model.Rotation{}
+
+	err := json.NewDecoder(r.Body).Decode(&rotation)
+	if err != nil {
+		audit.Record(actor, "rotation.write", app, err)
+		badRequest(w, err)
+		return
+	}
+
+	rotation.App = app
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	existing, err := router.readRotation(app)
+	if err != nil && err != errRotationNotFound {
+		audit.Record(actor, "rotation.write", app, err)
+		rotationError(w, err)
+		return
+	}
+
+	err = scheduleRotation(&rotation, existing, time.Now())
+	if err == nil {
+		err = router.writeRotation(&rotation)
+	}
+
+	audit.Record(actor, "rotation.write", app, err)
+	if err != nil {
+		rotationError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, rotation)
+}
+
+// GetRotation returns the rotation policies of the app named in the path, whoever may read the secrets of the app is allowed to do so
+func (router *Router) GetRotation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	app := p.ByName("app")
+
+	actor, ok := router.allowed(w, r, "rotation.read", app, model.CapabilityRead)
+	if !ok {
+		return
+	}
+
+	rotation, err := router.readRotation(app)
+	audit.Record(actor, "rotation.read", app, err)
+	if err != nil {
+		rotationError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, rotation)
+}
+
+// RemoveRotation stops rotating the keys of the app named in the path, whoever may delete the secrets of the app is allowed to do so
+func (router *Router) RemoveRotation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	app := p.ByName("app")
+
+	actor, ok := router.allowed(w, r, "rotation.delete", app, model.CapabilityDelete)
+	if !ok {
+		return
+	}
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	err := sys.DeleteEntry(kindRotation, app)
+	if os.IsNotExist(err) {
+		err = errRotationNotFound
+	}
+
+	audit.Record(actor, "rotation.delete", app, err)
+	if err != nil {
+		rotationError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// RotateSecrets rewrites every key due for rotation with a freshly generated value returning how many apps were rotated
+// Each rotated secret becomes a new version and its webhook, when set, is told which keys changed
+// An app failing to rotate does not hold the others, the last error is returned once every app was tried
+func (router *Router) RotateSecrets() (int, error) {
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	apps, err := sys.ListEntries(kindRotation)
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	var last error
+
+	for _, app := range apps {
+
+		event, webhook, err := router.rotate(app, time.Now())
+		if err != nil {
+			logR.Error("Rotating %s: %v", app, err)
+			audit.Record(rotationActor, "rotation.rotate", app, err)
+			last = err
+			continue
+		}
+
+		if event == nil {
+			continue
+		}
+
+		audit.Record(rotationActor, "rotation.rotate", app, nil)
+		rotated++
+
+		if webhook != "" {
+			notify(webhook, event)
+		}
+	}
+
+	return rotated, last
+}
+
+// rotate generates the keys of the app due for rotation returning the event along with the webhook to tell, nil when none was due
+func (router *Router) rotate(app string, now time.Time) (*model.RotationEvent, string, error) {
+
+	secretRecords.Lock()
+	defer secretRecords.Unlock()
+
+	rotation, err := router.readRotation(app)
+	if err == errRotationNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// a removed or expired secret is not brought back by its rotation
+	s, err := router.readSecret(app)
+	if os.IsNotExist(err) || (err == nil && s == nil) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	expired, dropped := expire(s, now)
+	if expired {
+		return nil, "", nil
+	}
+
+	// nor are the keys whose own lease ended
+	for _, key := range dropped {
+		delete(rotation.Keys, key)
+	}
+
+	values := map[string]string{}
+
+	for key, policy := range rotation.Keys {
+
+		if policy.NextRotation > now.Unix() {
+			continue
+		}
+
+		values[key], err = generate(&policy.Generator)
+		if err != nil {
+			return nil, "", err
+		}
+
+		interval, _ := time.ParseDuration(policy.Interval)
+		policy.LastRotated = now.Unix()
+		policy.NextRotation = now.Add(interval).Unix()
+	}
+
+	if len(values) == 0 {
+		if len(dropped) == 0 {
+			return nil, "", nil
+		}
+
+		err = router.writeSecret(s)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return nil, "", router.writeRotation(rotation)
+	}
+
+	if s.Vars == nil {
+		s.Vars = map[string]string{}
+	}
+
+	event := &model.RotationEvent{App: app, RotatedAt: now.Unix()}
+
+	for key, value := range values {
+		s.Vars[key] = value
+		event.Keys = append(event.Keys, key)
+	}
+
+	sort.Strings(event.Keys)
+
+	s.Version++
+	event.Version = s.Version
+
+	err = router.writeSecret(s)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = router.writeRotation(rotation)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return event, rotation.Webhook, nil
+}
+
+// carryForward sets the version of the secret about to be written to the current one
+// Rotated keys left out of the secret keep their current value until their next rotation, unless their lease ended
+func (router *Router) carryForward(s *model.Secret) error {
+
+	s.Version = 0
+
+	current, err := router.readSecret(s.App)
+	if os.IsNotExist(err) || (err == nil && current == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// an expired secret is gone for good, even when not reaped yet
+	if expired, _ := expire(current, time.Now()); expired {
+		return nil
+	}
+
+	s.Version = current.Version
+
+	rotation, err := router.readRotation(s.App)
+	if err == errRotationNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for key := range rotation.Keys {
+
+		value, ok := current.Vars[key]
+		if _, sent := s.Vars[key]; sent || !ok {
+			continue
+		}
+
+		if s.Vars == nil {
+			s.Vars = map[string]string{}
+		}
+
+		s.Vars[key] = value
+	}
+
+	return nil
+}
+
+// forgetRotatedKeys stops rotating the keys of the app whose lease ended, so the next rotation does not bring them back
+func (router *Router) forgetRotatedKeys(app string, keys []string) error {
+
+	rotation, err := router.readRotation(app)
+	if err == errRotationNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rotated := len(rotation.Keys)
+	for _, key := range keys {
+		delete(rotation.Keys, key)
+	}
+
+	if len(rotation.Keys) == rotated {
+		return nil
+	}
+
+	return router.writeRotation(rotation)
+}
+
+// readRotation reads and decrypts the rotation policies of the app
+func (router *Router) readRotation(app string) (*model.Rotation, error) {
+
+	sys := fs.NewFileSystem(dataSecrets)
+
+	data, err := sys.ReadEntry(kindRotation, app)
+	if os.IsNotExist(err) {
+		return nil, errRotationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := algo.NewSymmetrical().Decrypt(data, router.phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &model.Rotation{}
+	err = json.Unmarshal(b, rotation)
+	return rotation, err
+}
+
+// writeRotation encrypts the rotation policies storing them along with the secrets of the app
+func (router *Router) writeRotation(rotation *model.Rotation) error {
+
+	b, err := json.Marshal(rotation)
+	if err != nil {
+		return err
+	}
+
+	cypher, err := algo.NewSymmetrical().Encrypt(b, router.phrase)
+	if err != nil {
+		return err
+	}
+
+	sys := fs.NewFileSystem(dataSecrets)
+	return sys.MakeEntry(kindRotation, rotation.App, cypher)
+}
+
+// scheduleRotation validates the rotation policies setting when each key rotates next
+// Keys already scheduled with the same interval keep their schedule, the others rotate one interval from now
+func scheduleRotation(rotation, existing *model.Rotation, now time.Time) error {
+
+	if len(rotation.Keys) == 0 {
+		return errBadRotation
+	}
+
+	if rotation.Webhook != "" {
+		u, err := url.Parse(rotation.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errBadWebhook
+		}
+	}
+
+	for key, policy := range rotation.Keys {
+
+		if policy == nil {
+			return errBadRotation
+		}
+
+		interval, err := time.ParseDuration(policy.Interval)
+		if err != nil || interval < minRotation {
+			return errBadRotation
+		}
+
+		// a dry run tells a bad generator right away
+		_, err = generate(&policy.Generator)
+		if err != nil {
+			return err
+		}
+
+		policy.LastRotated, policy.NextRotation = 0, now.Add(interval).Unix()
+
+		if existing == nil {
+			continue
+		}
+
+		if current, ok := existing.Keys[key]; ok && current.Interval == policy.Interval {
+			policy.LastRotated, policy.NextRotation = current.LastRotated, current.NextRotation
+		}
+	}
+
+	return nil
+}
+
+// notify posts the rotation event to the webhook, failures are only logged since the rotation already took place
+func notify(webhook string, event *model.RotationEvent) {
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		logR.Error("Rotation webhook %s: %v", event.App, err)
+		return
+	}
+
+	res, err := webhookClient.Post(webhook, webhookContentType, bytes.NewReader(b))
+	if err != nil {
+		logR.Error("Rotation webhook %s: %v", event.App, err)
+		return
+	}
+
+	err = res.Body.Close()
+	if err != nil {
+		logR.Warn("Rotation webhook %s: %v", event.App, err)
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		logR.Error("Rotation webhook %s: %s", event.App, res.Status)
+	}
+}
+
+// rotationError maps the rotation errors into the proper response status
+func rotationError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case errRotationNotFound:
+		notFound(w, err)
+	case errBadRotation, errBadWebhook, fs.ErrBadInput:
+		badRequest(w, err)
+	default:
+		generateError(w, err)
+	}
+}
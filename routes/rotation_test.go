@@ -0,0 +1,381 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldRotateSecrets(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan model.RotationEvent, 1)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := model.RotationEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		events <- event
+	}))
+	defer webhook.Close()
+
+	router := NewRouter(testPassphrase)
+
+	err = fs.NewFileSystem(dataSecrets).DeleteSecret("rotated-app")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	res := serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "rotated-app", "vars": {"DB_USER": "orders", "DB_PASSWD": "static"}}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	rotation := `{"webhook": "` + webhook.URL + `", "keys": {
+		"DB_PASSWD": {"interval": "24h", "generator": {"type": "password", "length": 24, "classes": ["lower", "upper", "digit"]}},
+		"API_TOKEN": {"interval": "1h", "generator": {"type": "bytes", "length": 32, "encoding": "hex"}},
+		"INSTANCE": {"interval": "720h", "generator": {"type": "uuid"}}}}`
+
+	res = serve(router, http.MethodPut, "/v1/rotation/rotated-app", adminToken, rotation)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	rotated, err := router.RotateSecrets()
+	if err != nil || rotated != 0 {
+		t.Errorf("Rotated ahead of schedule! Got %v %v", rotated, err)
+	}
+
+	// every key falls due
+	r, err := router.readRotation("rotated-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range r.Keys {
+		policy.NextRotation = time.Now().Add(-time.Second).Unix()
+	}
+
+	err = router.writeRotation(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err = router.RotateSecrets()
+	if err != nil || rotated != 1 {
+		t.Fatalf("Bad rotation! Got %v %v", rotated, err)
+	}
+
+	select {
+	case event := <-events:
+		if event.App != "rotated-app" || event.Version != 1 || strings.Join(event.Keys, ",") != "API_TOKEN,DB_PASSWD,INSTANCE" {
+			t.Errorf("Bad rotation event! Got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("Webhook not told about the rotation!")
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=rotated-app", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	secret, err := decodeSecret(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwd := secret.Vars["DB_PASSWD"]
+	if len(passwd) != 24 || !regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString(passwd) || passwd == "static" {
+		t.Errorf("Bad rotated password! Got %s", passwd)
+	}
+
+	if !regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(secret.Vars["API_TOKEN"]) {
+		t.Errorf("Bad rotated token! Got %s", secret.Vars["API_TOKEN"])
+	}
+
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(secret.Vars["INSTANCE"]) {
+		t.Errorf("Bad rotated uuid! Got %s", secret.Vars["INSTANCE"])
+	}
+
+	if secret.Vars["DB_USER"] != "orders" || secret.Version != 1 {
+		t.Errorf("Bad rotated secret! Got %+v", secret)
+	}
+
+	rotated, err = router.RotateSecrets()
+	if err != nil || rotated != 0 {
+		t.Errorf("Rotated twice! Got %v %v", rotated, err)
+	}
+
+	// a write keeps the version and the rotated keys it leaves out
+	res = serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "rotated-app", "vars": {"DB_USER": "billing", "API_TOKEN": "manual"}, "version": 0}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/secrets?app=rotated-app", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	written, err := decodeSecret(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if written.Version != 1 || written.Vars["DB_PASSWD"] != passwd || written.Vars["INSTANCE"] != secret.Vars["INSTANCE"] ||
+		written.Vars["API_TOKEN"] != "manual" || written.Vars["DB_USER"] != "billing" {
+		t.Errorf("Bad written secret! Got %+v", written)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/rotation/rotated-app", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/rotation/rotated-app", adminToken, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	// removing the secret drops its rotation, which never brings it back
+	res = serve(router, http.MethodPut, "/v1/rotation/rotated-app", adminToken, rotation)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	r, err = router.readRotation("rotated-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/secrets?app=rotated-app", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/rotation/rotated-app", adminToken, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Rotation kept along a removed secret! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	for _, policy := range r.Keys {
+		policy.NextRotation = time.Now().Add(-time.Second).Unix()
+	}
+
+	err = router.writeRotation(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err = router.RotateSecrets()
+	if err != nil || rotated != 0 {
+		t.Errorf("Removed secret rotated! Got %v %v", rotated, err)
+	}
+
+	_, err = fs.NewFileSystem(dataSecrets).ReadSecret("rotated-app")
+	if !os.IsNotExist(err) {
+		t.Errorf("Removed secret brought back! Got %v", err)
+	}
+
+	err = fs.NewFileSystem(dataSecrets).DeleteEntry(kindRotation, "rotated-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRotatePastBrokenApps(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+	sys := fs.NewFileSystem(dataSecrets)
+
+	// listed ahead of the healthy app
+	err = sys.MakeEntry(kindRotation, "broken-app", []byte("not encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "rotated-app", "vars": {"DB_PASSWD": "static"}}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	err = router.writeRotation(&model.Rotation{App: "rotated-app", Keys: map[string]*model.KeyRotation{
+		"DB_PASSWD": {Interval: "1h", Generator: model.Generator{Type: model.GeneratorUUID}, NextRotation: time.Now().Add(-time.Second).Unix()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := router.RotateSecrets()
+	if err == nil || rotated != 1 {
+		t.Errorf("Broken app held the rotation! Got %v %v", rotated, err)
+	}
+
+	for _, app := range []string{"broken-app", "rotated-app"} {
+		err = sys.DeleteEntry(kindRotation, app)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = sys.DeleteSecret("rotated-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotRotateExpiredKeys(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+	sys := fs.NewFileSystem(dataSecrets)
+
+	// SESSION_KEY lease ended, the reaper did not run yet
+	expired := func() {
+		err := router.writeSecret(&model.Secret{App: "rotated-app", Vars: map[string]string{"DB_PASSWD": "static", "SESSION_KEY": "old"},
+			Lease: &model.Lease{ID: "secret/rotated-app/1", KeyTTLs: map[string]int64{"SESSION_KEY": 60},
+				KeyExpiresAt: map[string]int64{"SESSION_KEY": time.Now().Add(-time.Second).Unix()}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = router.writeRotation(&model.Rotation{App: "rotated-app", Keys: map[string]*model.KeyRotation{
+			"SESSION_KEY": {Interval: "1h", Generator: model.Generator{Type: model.GeneratorUUID}, NextRotation: time.Now().Add(-time.Second).Unix()},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotatedKeys := func() map[string]*model.KeyRotation {
+		r, err := router.readRotation("rotated-app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Keys
+	}
+
+	expired()
+
+	// a write leaving the key out does not carry it forward
+	res := serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "rotated-app", "vars": {"DB_PASSWD": "changed"}}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	s, err := router.readSecret("rotated-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Vars["SESSION_KEY"]; ok {
+		t.Errorf("Expired key carried forward! Got %v", s.Vars)
+	}
+
+	// nor does the rotation generate it again
+	expired()
+
+	rotated, err := router.RotateSecrets()
+	if err != nil || rotated != 0 {
+		t.Errorf("Expired key rotated! Got %v %v", rotated, err)
+	}
+
+	s, err = router.readSecret("rotated-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Vars["SESSION_KEY"]; ok || len(rotatedKeys()) != 0 {
+		t.Errorf("Expired key rotated! Got %v %v", s.Vars, rotatedKeys())
+	}
+
+	// once reaped, the key is not rotated either
+	expired()
+
+	_, err = router.ReapSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rotatedKeys()) != 0 {
+		t.Errorf("Reaped key still rotated! Got %v", rotatedKeys())
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/secrets?app=rotated-app", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	_, err = sys.ReadEntry(kindRotation, "rotated-app")
+	if !os.IsNotExist(err) {
+		t.Errorf("Rotation kept along with the removed secret! Got %v", err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotWriteBadRotation(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	for _, body := range []string{
+		`{"keys": {}}`,
+		`{"keys": {"PASSWD": {"interval": "10s", "generator": {"type": "uuid"}}}}`,
+		`{"keys": {"PASSWD": {"interval": "1h", "generator": {"type": "dice"}}}}`,
+		`{"keys": {"PASSWD": {"interval": "1h", "generator": {"type": "password", "length": 2}}}}`,
+		`{"keys": {"PASSWD": {"interval": "1h", "generator": {"type": "password", "length": 16, "classes": ["emoji"]}}}}`,
+		`{"keys": {"PASSWD": {"interval": "1h", "generator": {"type": "bytes", "length": 16, "encoding": "base32"}}}}`,
+		`{"webhook": "ftp://hooks.example.com", "keys": {"PASSWD": {"interval": "1h", "generator": {"type": "uuid"}}}}`,
+	} {
+		res := serve(router, http.MethodPut, "/v1/rotation/rotated-app", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Routes returns an http router holding every kripto route
+// All routes but the public ones are wrapped by the Authenticated middleware, which also accepts client certificates
+func (router *Router) Routes() *httprouter.Router {
+
+	r := httprouter.New()
+	protected := router.Authenticated
+
+	// public
+	r.GET("/v1/health", router.Health)
+	r.POST("/v1/authenticate", router.Authenticate)
+	r.POST("/v1/auth/refresh", router.RefreshToken)
+	r.POST("/v1/auth/approle/login", router.AppRoleLogin)
+	r.POST("/v1/auth/cert/login", router.CertLogin)
+	r.POST("/v1/auth/oidc/login", router.OIDCLogin)
+	r.POST("/v1/auth/ldap/login", router.LDAPLogin)
+	r.GET("/.well-known/jwks.json", router.JWKS)
+	r.POST("/v1/sys/unwrap", router.Unwrap)
+	r.GET("/v1/pki/ca", router.GetCA)
+	r.GET("/v1/pki/ca/pem", router.GetCAPEM)
+	r.GET("/v1/pki/crl", router.GetCRL)
+	r.GET("/v1/pki/crl/pem", router.GetCRLPEM)
+	r.GET("/v1/ssh/ca", router.GetSSHCA)
+	r.GET("/v1/ssh/ca/public_key", router.GetSSHCAPublicKey)
+
+	// tokens
+	r.POST("/v1/auth/logout", protected(router.Logout))
+	r.POST("/v1/auth/revoke", protected(router.Revoke))
+	r.POST("/v1/auth/token", protected(router.ScopedToken))
+	r.GET("/v1/auth/whoami", protected(router.WhoAmI))
+	r.POST("/v1/auth/introspect", protected(router.Introspect))
+
+	// api keys
+	r.GET("/v1/auth/apikeys", protected(router.ListAPIKeys))
+	r.POST("/v1/auth/apikeys", protected(router.Wrappable(router.CreateAPIKey)))
+	r.DELETE("/v1/auth/apikeys/:id", protected(router.RemoveAPIKey))
+
+	// secrets
+	r.POST("/v1/secrets", protected(router.Authorize(model.CapabilityWrite, router.CreateSecret)))
+	r.GET("/v1/secrets", protected(router.Authorize(model.CapabilityRead, router.Wrappable(router.GetSecretsByApp))))
+	r.DELETE("/v1/secrets", protected(router.Authorize(model.CapabilityDelete, router.RemoveSecretsByApp)))
+	r.GET("/v1/apps", protected(router.ListApps))
+
+	// leases
+	r.PUT("/v1/sys/leases/renew", protected(router.RenewLease))
+	r.PUT("/v1/sys/leases/revoke", protected(router.RevokeLease))
+
+	// generation
+	r.POST("/v1/sys/generate/password", protected(router.Wrappable(router.GeneratePassword)))
+	r.POST("/v1/sys/generate/bytes", protected(router.Wrappable(router.GenerateBytes)))
+
+	// rotation
+	r.GET("/v1/rotation/:app", protected(router.GetRotation))
+	r.PUT("/v1/rotation/:app", protected(router.WriteRotation))
+	r.DELETE("/v1/rotation/:app", protected(router.RemoveRotation))
+
+	// database credentials
+	r.GET("/v1/database/config/:name", protected(router.GetDatabaseConfig))
+	r.PUT("/v1/database/config/:name", protected(router.WriteDatabaseConfig))
+	r.DELETE("/v1/database/config/:name", protected(router.RemoveDatabaseConfig))
+	r.GET("/v1/database/roles/:name", protected(router.GetDatabaseRole))
+	r.PUT("/v1/database/roles/:name", protected(router.WriteDatabaseRole))
+	r.DELETE("/v1/database/roles/:name", protected(router.RemoveDatabaseRole))
+	r.GET("/v1/database/creds/:role", protected(router.Wrappable(router.IssueDatabaseCredentials)))
+
+	// pki
+	r.POST("/v1/pki/root/generate", protected(router.GenerateRootCA))
+	r.POST("/v1/pki/intermediate/generate", protected(router.GenerateIntermediateCA))
+	r.POST("/v1/pki/intermediate/set-signed", protected(router.SetSignedIntermediateCA))
+	r.POST("/v1/pki/ca/import", protected(router.ImportCA))
+	r.DELETE("/v1/pki/ca", protected(router.RemoveCA))
+	r.GET("/v1/pki/roles/:name", protected(router.GetPKIRole))
+	r.PUT("/v1/pki/roles/:name", protected(router.WritePKIRole))
+	r.DELETE("/v1/pki/roles/:name", protected(router.RemovePKIRole))
+	r.POST("/v1/pki/issue/:role", protected(router.Wrappable(router.IssueCertificate)))
+	r.POST("/v1/pki/revoke", protected(router.RevokeCertificate))
+	r.POST("/v1/pki/crl/rotate", protected(router.RotateCRL))
+
+	// ssh
+	r.POST("/v1/ssh/ca", protected(router.GenerateSSHCA))
+	r.DELETE("/v1/ssh/ca", protected(router.RemoveSSHCA))
+	r.GET("/v1/ssh/roles/:name", protected(router.GetSSHRole))
+	r.PUT("/v1/ssh/roles/:name", protected(router.WriteSSHRole))
+	r.DELETE("/v1/ssh/roles/:name", protected(router.RemoveSSHRole))
+	r.POST("/v1/ssh/sign/:role", protected(router.SignSSHKey))
+
+	// totp
+	r.GET("/v1/totp/keys/:name", protected(router.GetTOTPKey))
+	r.PUT("/v1/totp/keys/:name", protected(router.ImportTOTPKey))
+	r.DELETE("/v1/totp/keys/:name", protected(router.RemoveTOTPKey))
+	r.GET("/v1/totp/code/:name", protected(router.Wrappable(router.GetTOTPCode)))
+
+	// user management
+	r.POST("/v1/users", protected(router.CreateUser))
+	r.GET("/v1/users", protected(router.ListUsers))
+	r.GET("/v1/users/:username", protected(router.GetUser))
+	r.PATCH("/v1/users/:username", protected(router.UpdateUser))
+	r.DELETE("/v1/users/:username", protected(router.RemoveUser))
+	r.PUT("/v1/users/:username/password", protected(router.ChangePassword))
+	r.PUT("/v1/users/:username/ttl", protected(router.SetTokenTTL))
+	r.POST("/v1/users/:username/enable", protected(router.EnableUser))
+	r.POST("/v1/users/:username/disable", protected(router.DisableUser))
+	r.POST("/v1/users/:username/unlock", protected(router.UnlockUser))
+	r.POST("/v1/users/:username/mfa", protected(router.EnrollMFA))
+	r.POST("/v1/users/:username/mfa/confirm", protected(router.ConfirmMFA))
+	r.DELETE("/v1/users/:username/mfa", protected(router.DisableMFA))
+
+	// policies and roles
+	r.GET("/v1/policies", protected(router.ListPolicies))
+	r.GET("/v1/policies/:name", protected(router.GetPolicy))
+	r.PUT("/v1/policies/:name", protected(router.WritePolicy))
+	r.DELETE("/v1/policies/:name", protected(router.RemovePolicy))
+	r.GET("/v1/roles", protected(router.ListRoles))
+	r.GET("/v1/roles/:name", protected(router.GetRole))
+	r.PUT("/v1/roles/:name", protected(router.WriteRole))
+	r.DELETE("/v1/roles/:name", protected(router.RemoveRole))
+
+	// app roles
+	r.GET("/v1/approles", protected(router.ListAppRoles))
+	r.GET("/v1/approles/:name", protected(router.GetAppRole))
+	r.PUT("/v1/approles/:name", protected(router.WriteAppRole))
+	r.DELETE("/v1/approles/:name", protected(router.RemoveAppRole))
+	r.POST("/v1/approles/:name/secret-id", protected(router.Wrappable(router.IssueSecretID)))
+
+	// cert roles
+	r.GET("/v1/certroles", protected(router.ListCertRoles))
+	r.GET("/v1/certroles/:name", protected(router.GetCertRole))
+	r.PUT("/v1/certroles/:name", protected(router.WriteCertRole))
+	r.DELETE("/v1/certroles/:name", protected(router.RemoveCertRole))
+
+	// identity provider
+	r.GET("/v1/auth/oidc/config", protected(router.GetOIDCConfig))
+	r.PUT("/v1/auth/oidc/config", protected(router.WriteOIDCConfig))
+
+	// directory
+	r.GET("/v1/auth/ldap/config", protected(router.GetLDAPConfig))
+	r.PUT("/v1/auth/ldap/config", protected(router.WriteLDAPConfig))
+
+	// signing keys
+	r.GET("/v1/sys/keys", protected(router.ListKeys))
+	r.POST("/v1/sys/keys/rotate", protected(router.RotateKey))
+	r.DELETE("/v1/sys/keys/:kid", protected(router.RemoveKey))
+
+	return r
+}
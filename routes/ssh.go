@@ -0,0 +1,196 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	sshAppPrefix    = "ssh/"
+	textContentType = "text/plain; charset=utf-8"
+)
+
+// GenerateSSHCA creates the ed25519 key of the kripto SSH CA, only admins are allowed to do so
+func (router *Router) GenerateSSHCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "ssh.ca.generate", "")
+	if !ok {
+		return
+	}
+
+	ca, err := auth.GenerateSSHCA(router.phrase)
+	audit.Record(actor, "ssh.ca.generate", "", err)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, ca)
+}
+
+// GetSSHCA returns the public key of the kripto SSH CA, it is public
+func (router *Router) GetSSHCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	ca, err := auth.ReadSSHCA(router.phrase)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, ca)
+}
+
+// GetSSHCAPublicKey returns the public key of the kripto SSH CA as a single authorized_keys line, ready for TrustedUserCAKeys
+func (router *Router) GetSSHCAPublicKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	ca, err := auth.ReadSSHCA(router.phrase)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseRaw(w, http.StatusOK, textContentType, []byte(ca.PublicKey))
+}
+
+// RemoveSSHCA drops the kripto SSH CA, only admins are allowed to do so
+func (router *Router) RemoveSSHCA(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "ssh.ca.delete", "")
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveSSHCA()
+	audit.Record(actor, "ssh.ca.delete", "", err)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// WriteSSHRole creates or replaces the ssh role named in the path, only admins are allowed to do so
+func (router *Router) WriteSSHRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "ssh.role.write", name)
+	if !ok {
+		return
+	}
+
+	role := model.SSHRole{}
+
+	err := json.NewDecoder(r.Body).Decode(&role)
+	if err != nil {
+		audit.Record(actor, "ssh.role.write", name, err)
+		badRequest(w, err)
+		return
+	}
+
+	role.Name = name
+
+	err = auth.WriteSSHRole(&role, router.phrase)
+	audit.Record(actor, "ssh.role.write", name, err)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// GetSSHRole returns the ssh role named in the path, only admins are allowed to do so
+func (router *Router) GetSSHRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "ssh.role.read", name)
+	if !ok {
+		return
+	}
+
+	role, err := auth.ReadSSHRole(name, router.phrase)
+	audit.Record(actor, "ssh.role.read", name, err)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, role)
+}
+
+// RemoveSSHRole drops the ssh role named in the path, only admins are allowed to do so
+func (router *Router) RemoveSSHRole(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+
+	actor, ok := router.administrator(w, r, "ssh.role.delete", name)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveSSHRole(name)
+	audit.Record(actor, "ssh.role.delete", name, err)
+	if err != nil {
+		sshError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// SignSSHKey signs the public key of the body through the role named in the path
+// Whoever may write the ssh/<role> app is allowed to do so, the principals and options asked for must be allowed by the role
+func (router *Router) SignSSHKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("role")
+	app := sshAppPrefix + name
+
+	actor, ok := router.allowed(w, r, "ssh.sign", app, model.CapabilityWrite)
+	if !ok {
+		return
+	}
+
+	req := model.SSHSignRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "ssh.sign", app, err)
+		badRequest(w, err)
+		return
+	}
+
+	signed, err := auth.SignSSHKey(name, &req, actor, router.phrase)
+	if err != nil {
+		audit.Record(actor, "ssh.sign", app, err)
+		sshError(w, err)
+		return
+	}
+
+	audit.Record(actor, "ssh.sign", app+"/"+signed.SerialNumber, nil)
+	responseJSON(w, http.StatusOK, signed)
+}
+
+// sshError maps the ssh engine errors into the proper response status
+func sshError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrSSHCANotFound, auth.ErrSSHRoleNotFound:
+		notFound(w, err)
+	case auth.ErrBadSSHRole, auth.ErrBadSignRequest, auth.ErrSSHNotAllowed, fs.ErrBadInput:
+		badRequest(w, err)
+	case auth.ErrSSHCAExists:
+		conflict(w, err)
+	default:
+		serverError(w, err)
+	}
+}
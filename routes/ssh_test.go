@@ -0,0 +1,213 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestShouldSignSSHKeys(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodGet, "/v1/ssh/ca/public_key", "", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/ssh/ca", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/ssh/ca", adminToken, "")
+	if res.Code != http.StatusConflict {
+		t.Errorf("SSH CA replaced! Got %v expected %v", res.Code, http.StatusConflict)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/ssh/ca/public_key", "", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	ca, _, _, _, err := ssh.ParseAuthorizedKey(res.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ca.Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("Bad SSH CA key type! Got %s", ca.Type())
+	}
+
+	for _, body := range []string{
+		`{"allowed_principals": []}`,
+		`{"allowed_principals": ["deploy"], "cert_type": "both"}`,
+		`{"allowed_principals": ["*.example.com"], "cert_type": "host", "allowed_extensions": ["permit-pty"]}`,
+		`{"allowed_principals": ["deploy"], "default_extensions": {"permit-pty": ""}}`,
+		`{"allowed_principals": ["deploy"], "ttl": "4h", "max_ttl": "2h"}`,
+	} {
+		res = serve(router, http.MethodPut, "/v1/ssh/roles/dev", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	for _, put := range [][2]string{
+		{"/v1/ssh/roles/dev", `{"allowed_principals": ["{{username}}", "deploy"], "default_principals": ["{{username}}"],
+			"allowed_critical_options": ["source-address"], "allowed_extensions": ["permit-pty", "permit-port-forwarding"],
+			"default_extensions": {"permit-pty": ""}, "ttl": "30m", "max_ttl": "2h"}`},
+		{"/v1/ssh/roles/hosts", `{"cert_type": "host", "allowed_principals": ["*.example.com"]}`},
+		{"/v1/policies/dev-ssh", `{"rules": [{"apps": "ssh/dev", "capabilities": ["write"]}]}`},
+	} {
+		res = serve(router, http.MethodPut, put[0], adminToken, put[1])
+		if res.Code != http.StatusOK {
+			t.Fatalf("Bad status for %s! Got %v expected %v", put[0], res.Code, http.StatusOK)
+		}
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorized, err := json.Marshal(string(ssh.MarshalAuthorizedKey(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/ssh/sign/dev", userToken, `{"public_key": `+string(authorized)+`}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPatch, "/v1/users/"+testUser, adminToken, `{"policies": ["dev-ssh"]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	for _, body := range []string{
+		`{"public_key": "ssh-ed25519 garbage"}`,
+		`{"public_key": ` + string(authorized) + `, "valid_principals": ["root"]}`,
+		`{"public_key": ` + string(authorized) + `, "extensions": {"permit-X11-forwarding": ""}}`,
+		`{"public_key": ` + string(authorized) + `, "critical_options": {"force-command": "/bin/true"}}`,
+		`{"public_key": ` + string(authorized) + `, "ttl": "later"}`,
+	} {
+		res = serve(router, http.MethodPost, "/v1/ssh/sign/dev", userToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/ssh/sign/dev", userToken, `{"public_key": `+string(authorized)+`, "ttl": "10h"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	cert := decodeSSHCertificate(t, res.Body.Bytes())
+
+	if !bytes.Equal(cert.SignatureKey.Marshal(), ca.Marshal()) || !bytes.Equal(cert.Key.Marshal(), key.Marshal()) {
+		t.Errorf("Certificate not signed by the SSH CA over the key! Got %s", cert.KeyId)
+	}
+
+	checker := &ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool { return bytes.Equal(auth.Marshal(), ca.Marshal()) }}
+
+	err = checker.CheckCert(testUser, cert)
+	if err != nil {
+		t.Errorf("Bad user certificate! %v", err)
+	}
+
+	if _, ok := cert.Extensions["permit-pty"]; !ok || len(cert.Extensions) != 1 || cert.CertType != ssh.UserCert {
+		t.Errorf("Bad default extensions! Got %v", cert.Extensions)
+	}
+
+	if validity := time.Until(time.Unix(int64(cert.ValidBefore), 0)); validity > 2*time.Hour {
+		t.Errorf("TTL above the role max! Got %v", validity)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/ssh/sign/dev", userToken, `{"public_key": `+string(authorized)+`, "valid_principals": ["deploy"],
+		"critical_options": {"source-address": "10.0.0.0/8"}, "extensions": {"permit-port-forwarding": ""}}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	cert = decodeSSHCertificate(t, res.Body.Bytes())
+
+	if cert.CriticalOptions["source-address"] != "10.0.0.0/8" || len(cert.ValidPrincipals) != 1 || cert.ValidPrincipals[0] != "deploy" {
+		t.Errorf("Bad certificate options! Got %v %v", cert.ValidPrincipals, cert.CriticalOptions)
+	}
+
+	// a username carrying * is taken literally, never as a pattern over the principals of others
+	for principal, allowed := range map[string]bool{"oidc:a*": true, "oidc:admin": false} {
+
+		_, err = auth.SignSSHKey("dev", &model.SSHSignRequest{PublicKey: string(ssh.MarshalAuthorizedKey(key)), ValidPrincipals: []string{principal}},
+			"oidc:a*", testPassphrase)
+		if (err == nil) != allowed {
+			t.Errorf("Bad principal check for %s! Got %v", principal, err)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/ssh/sign/hosts", adminToken, `{"public_key": `+string(authorized)+`, "valid_principals": ["web.example.com"]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if cert = decodeSSHCertificate(t, res.Body.Bytes()); cert.CertType != ssh.HostCert {
+		t.Errorf("Bad certificate type! Got %v", cert.CertType)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeSSHCertificate(t *testing.T, body []byte) *ssh.Certificate {
+
+	signed := model.SSHSignedKey{}
+
+	err := json.Unmarshal(body, &signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed.SignedKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("Signed key is no certificate! Got %s", signed.SignedKey)
+	}
+
+	return cert
+}
@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	errMissingRevoke   = errors.New("revoke: jti or username required")
+	errMissingAudience = errors.New("token: audience required")
+	errNoToken         = errors.New("token: request not authenticated by a token")
+
+	errMissingIntrospectToken = errors.New("introspect: token required")
+)
+
+// Logout revokes the very token used to authenticate the request
+// When a refresh token is sent its whole family is revoked as well
+func (router *Router) Logout(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		unauthorized(w, errNoPrincipal)
+		return
+	}
+
+	if principal.TokenID == "" {
+		badRequest(w, errNoToken)
+		return
+	}
+
+	req := model.RefreshRequest{}
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+	}
+
+	err := auth.RevokeToken(principal.TokenID, principal.ExpiresAt)
+	audit.Record(principal.Username, "auth.logout", principal.TokenID, err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if req.RefreshToken != "" {
+
+		err = auth.RevokeRefresh(req.RefreshToken, principal.Username, router.phrase)
+		audit.Record(principal.Username, "auth.logout.refresh", "", err)
+		if err == auth.ErrInvalidRefresh {
+			badRequest(w, err)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// RefreshToken rotates the refresh token returning a new access token along with a new refresh token
+// Reusing an already rotated refresh token revokes every token of its family
+func (router *Router) RefreshToken(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	req := model.RefreshRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	session, err := auth.Refresh(req.RefreshToken, router.phrase)
+	if err == auth.ErrInvalidRefresh || err == auth.ErrRefreshReused {
+		audit.Record("", "auth.refresh", "", err)
+		unauthorized(w, err)
+		return
+	}
+
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, session)
+}
+
+// ScopedToken mints a short lived token for another service on behalf of the principal
+// The token carries that service as its audience so it is refused by kripto and by any other service
+func (router *Router) ScopedToken(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		unauthorized(w, errNoPrincipal)
+		return
+	}
+
+	req := model.ScopedTokenRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(principal.Username, "auth.token.scoped", "", err)
+		badRequest(w, err)
+		return
+	}
+
+	if req.Audience == "" {
+		audit.Record(principal.Username, "auth.token.scoped", "", errMissingAudience)
+		badRequest(w, errMissingAudience)
+		return
+	}
+
+	ttl := auth.AccessTokenTTL
+	if req.TokenExpiresIn != "" {
+
+		requested, err := time.ParseDuration(req.TokenExpiresIn)
+		if err != nil || requested <= 0 {
+			audit.Record(principal.Username, "auth.token.scoped", req.Audience, errBadTokenTTL)
+			badRequest(w, errBadTokenTTL)
+			return
+		}
+
+		if requested < ttl {
+			ttl = requested
+		}
+	}
+
+	tokenString, claims, err := auth.NewJwtAuth(&model.Credentials{
+		Username:       principal.Username,
+		TokenExpiresIn: ttl,
+		Audience:       req.Audience,
+	}).IssueToken()
+	audit.Record(principal.Username, "auth.token.scoped", req.Audience, err)
+	if err == auth.ErrUnknownAudience {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, &model.TokenResponse{
+		Token:     tokenString,
+		ExpiresAt: claims.ExpiresAt,
+	})
+}
+
+// Revoke revokes a single token by its id or every token issued to a user, only admins are allowed to do so
+func (router *Router) Revoke(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "auth.revoke", "")
+	if !ok {
+		return
+	}
+
+	req := model.RevokeRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "auth.revoke", "", err)
+		badRequest(w, err)
+		return
+	}
+
+	if req.TokenID == "" && req.Username == "" {
+		audit.Record(actor, "auth.revoke", "", errMissingRevoke)
+		badRequest(w, errMissingRevoke)
+		return
+	}
+
+	if req.TokenID != "" {
+
+		err = router.revokeTokenID(req.TokenID)
+		audit.Record(actor, "auth.revoke.token", req.TokenID, err)
+		if err != nil {
+			userError(w, err)
+			return
+		}
+	}
+
+	if req.Username != "" {
+
+		err = auth.RevokeUser(req.Username, router.phrase)
+		audit.Record(actor, "auth.revoke.user", req.Username, err)
+		if err != nil {
+			userError(w, err)
+			return
+		}
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// revokeTokenID revokes a token known only by its id, keeping it as long as any token may live
+func (router *Router) revokeTokenID(jti string) error {
+
+	lifetime, err := auth.MaxTokenLifetime(router.phrase)
+	if err != nil {
+		return err
+	}
+
+	return auth.RevokeToken(jti, time.Now().Add(lifetime))
+}
@@ -0,0 +1,423 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldLogout(t *testing.T) {
+
+	err := before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/auth/logout", userToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", userToken, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Logged out token still valid! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRevokeEveryUserToken(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/auth/revoke", userToken, `{"username": "kripto_admin"}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/revoke", adminToken, `{"username": "ffhenkes"}`)
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", userToken, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Revoked token still valid! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	// a token issued right after the revocation is valid, even within the same second
+	newToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", newToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Token issued after the revocation refused! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldPruneExpiredRevocations(t *testing.T) {
+
+	err := auth.RevokeToken("expired", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = auth.RevokeToken("alive", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := auth.PruneRevocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pruned != 1 {
+		t.Errorf("Bad prune! Got %d expected %d", pruned, 1)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRotateRefreshTokens(t *testing.T) {
+
+	err := before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/authenticate", "", `{"username": "ffhenkes", "password": "test"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	first := decodeSession(t, res.Body)
+	if first.Token == "" || first.RefreshToken == "" {
+		t.Fatalf("Missing tokens! Got %+v", first)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	second := decodeSession(t, res.Body)
+	if second.RefreshToken == first.RefreshToken {
+		t.Errorf("Refresh token not rotated!")
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", second.Token, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	// reusing the first refresh token revokes the whole family
+	res = serve(router, http.MethodPost, "/v1/auth/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Reused refresh token accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/refresh", "", `{"refresh_token": "`+second.RefreshToken+`"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Refresh token of a revoked family accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", second.Token, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Access token of a revoked family accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRevokeRefreshTokensWithTheUser(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	sessions := []*model.TokenResponse{}
+	for i := 0; i < 2; i++ {
+
+		res := serve(router, http.MethodPost, "/v1/authenticate", "", `{"username": "ffhenkes", "password": "test"}`)
+		if res.Code != http.StatusCreated {
+			t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+		}
+
+		sessions = append(sessions, decodeSession(t, res.Body))
+	}
+
+	res := serve(router, http.MethodPost, "/v1/auth/revoke", adminToken, `{"username": "ffhenkes"}`)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	for _, session := range sessions {
+		res = serve(router, http.MethodPost, "/v1/auth/refresh", "", `{"refresh_token": "`+session.RefreshToken+`"}`)
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Refresh token of a revoked user accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRevokeFederatedPrincipals(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	for principal, method := range map[string]string{auth.LDAPPrincipal("john.doe"): model.MethodLDAP, auth.OIDCPrincipal("auth0|123"): model.MethodOIDC} {
+
+		token, _, err := auth.NewFederatedJwtAuth(&model.Credentials{Username: principal, TokenExpiresIn: time.Hour},
+			method, []string{"developers"}).IssueToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := serve(router, http.MethodGet, "/v1/auth/whoami", token, "")
+		if res.Code != http.StatusOK {
+			t.Fatalf("Bad status for %s! Got %v expected %v", principal, res.Code, http.StatusOK)
+		}
+
+		res = serve(router, http.MethodPost, "/v1/auth/revoke", adminToken, `{"username": "`+principal+`"}`)
+		if res.Code != http.StatusNoContent {
+			t.Fatalf("Bad status for %s! Got %v expected %v", principal, res.Code, http.StatusNoContent)
+		}
+
+		res = serve(router, http.MethodGet, "/v1/auth/whoami", token, "")
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Token of revoked %s accepted! Got %v expected %v", principal, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotRefreshForRecreatedUser(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users", adminToken, `{"username": "kripto_user", "password": "secret"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/authenticate", "", `{"username": "kripto_user", "password": "secret"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	old := decodeSession(t, res.Body)
+
+	res = serve(router, http.MethodDelete, "/v1/users/kripto_user", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users", adminToken, `{"username": "kripto_user", "password": "other"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/refresh", "", `{"refresh_token": "`+old.RefreshToken+`"}`)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Refresh token of a removed user accepted! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldMintScopedToken(t *testing.T) {
+
+	err := before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth.Audiences = []string{"billing"}
+	defer func() { auth.Audiences = []string{} }()
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/auth/token", userToken, `{"audience": "payments"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Token minted for an unknown audience! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/token", userToken, `{"audience": "billing", "token_expires_in": "5m"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	scoped := decodeSession(t, res.Body)
+
+	claims, err := auth.ValidateTokenFor(scoped.Token, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != testUser || claims.Issuer != auth.Issuer || claims.NotBefore == 0 || claims.Id == "" {
+		t.Errorf("Missing standard claims! %+v", claims.StandardClaims)
+	}
+
+	_, err = auth.ValidateTokenFor(scoped.Token, "payments")
+	if err != auth.ErrBadAudience {
+		t.Errorf("Token replayed against another audience! Got %v expected %v", err, auth.ErrBadAudience)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/apps", scoped.Token, "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Scoped token accepted by kripto! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldValidateIssuerAndClockSkew(t *testing.T) {
+
+	err := before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expired ten seconds ago, still within the default skew
+	expired, err := auth.NewJwtAuth(&model.Credentials{
+		Username:       testUser,
+		TokenExpiresIn: -10 * time.Second,
+	}).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = auth.ValidateToken(expired)
+	if err != nil {
+		t.Errorf("Token within the clock skew refused! %v", err)
+	}
+
+	skew := auth.ClockSkew
+	auth.ClockSkew = 0
+
+	_, err = auth.ValidateToken(expired)
+	if err != auth.ErrTokenExpired {
+		t.Errorf("Expired token accepted! Got %v expected %v", err, auth.ErrTokenExpired)
+	}
+
+	auth.ClockSkew = skew
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := auth.Issuer
+	auth.Issuer = "someone-else"
+
+	_, err = auth.ValidateToken(userToken)
+	if err != auth.ErrBadIssuer {
+		t.Errorf("Token of another issuer accepted! Got %v expected %v", err, auth.ErrBadIssuer)
+	}
+
+	auth.Issuer = issuer
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeSession(t *testing.T, r io.Reader) *model.TokenResponse {
+
+	session := &model.TokenResponse{}
+
+	err := json.NewDecoder(r).Decode(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
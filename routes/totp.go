@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const totpAppPrefix = "totp/"
+
+// ImportTOTPKey imports the seed of the body under the name in the path, whoever may write the totp/<name> app is allowed to do so
+func (router *Router) ImportTOTPKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+	app := totpAppPrefix + name
+
+	actor, ok := router.allowed(w, r, "totp.import", app, model.CapabilityWrite)
+	if !ok {
+		return
+	}
+
+	req := model.TOTPKeyRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "totp.import", app, err)
+		badRequest(w, err)
+		return
+	}
+
+	key, err := auth.ImportTOTPKey(name, &req, router.phrase)
+	audit.Record(actor, "totp.import", app, err)
+	if err != nil {
+		totpError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, key)
+}
+
+// GetTOTPKey returns what is shown of the seed named in the path, whoever may read the totp/<name> app is allowed to do so
+func (router *Router) GetTOTPKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+	app := totpAppPrefix + name
+
+	actor, ok := router.allowed(w, r, "totp.read", app, model.CapabilityRead)
+	if !ok {
+		return
+	}
+
+	key, err := auth.ReadTOTPKey(name, router.phrase)
+	audit.Record(actor, "totp.read", app, err)
+	if err != nil {
+		totpError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, key)
+}
+
+// RemoveTOTPKey drops the seed named in the path, whoever may delete the totp/<name> app is allowed to do so
+func (router *Router) RemoveTOTPKey(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+	app := totpAppPrefix + name
+
+	actor, ok := router.allowed(w, r, "totp.delete", app, model.CapabilityDelete)
+	if !ok {
+		return
+	}
+
+	err := auth.RemoveTOTPKey(name)
+	audit.Record(actor, "totp.delete", app, err)
+	if err != nil {
+		totpError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// GetTOTPCode returns the current code of the seed named in the path, whoever may read the totp/<name> app is allowed to do so
+func (router *Router) GetTOTPCode(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	name := p.ByName("name")
+	app := totpAppPrefix + name
+
+	actor, ok := router.allowed(w, r, "totp.code", app, model.CapabilityRead)
+	if !ok {
+		return
+	}
+
+	code, err := auth.GenerateTOTPCode(name, router.phrase)
+	audit.Record(actor, "totp.code", app, err)
+	if err != nil {
+		totpError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, code)
+}
+
+// totpError maps the totp engine errors into the proper response status
+func totpError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrTOTPKeyNotFound:
+		notFound(w, err)
+	case auth.ErrBadTOTPKey, algo.ErrBadTOTPURI, algo.ErrBadTOTPSecret, algo.ErrBadTOTPAlgorithm, algo.ErrBadTOTPParameters, fs.ErrBadInput:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,159 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/algo"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldGenerateTOTPCodes(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	secret, err := algo.GenerateTOTPSecret(algo.TOTPSecretSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := algo.NewTOTP(secret).URI("Acme Cloud", "ops@example.com")
+
+	for _, body := range []string{
+		`{}`,
+		`{"url": "` + uri + `", "key": "` + secret + `"}`,
+		`{"url": "otpauth://hotp/Acme?secret=` + secret + `&counter=1"}`,
+		`{"url": "otpauth://totp/Acme?secret=not-base32!"}`,
+		`{"url": "otpauth://totp/Acme?secret=` + secret + `&digits=12"}`,
+		`{"key": "` + secret + `", "algorithm": "MD5"}`,
+	} {
+		res := serve(router, http.MethodPut, "/v1/totp/keys/acme", adminToken, body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Bad status for %s! Got %v expected %v", body, res.Code, http.StatusBadRequest)
+		}
+	}
+
+	res := serve(router, http.MethodPut, "/v1/totp/keys/acme", adminToken, `{"url": "`+uri+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	key := model.TOTPKey{}
+	err = json.NewDecoder(res.Body).Decode(&key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.Issuer != "Acme Cloud" || key.AccountName != "ops@example.com" || key.Digits != 6 || key.Period != 30 {
+		t.Errorf("Bad imported key! Got %+v", key)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/policies/acme-codes", adminToken, `{"rules": [{"apps": "totp/acme", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/totp/code/acme", userToken, "")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodPatch, "/v1/users/"+testUser, adminToken, `{"policies": ["acme-codes"]}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/totp/code/acme", userToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	code := model.TOTPCode{}
+	err = json.NewDecoder(res.Body).Decode(&code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err := algo.NewTOTP(secret).Validate(code.Code, time.Now(), 1)
+	if err != nil || !ok {
+		t.Errorf("Bad code! Got %s", code.Code)
+	}
+
+	if code.ExpiresAt < time.Now().Unix() || code.ExpiresAt > time.Now().Add(30*time.Second).Unix() {
+		t.Errorf("Bad code expiry! Got %v", code.ExpiresAt)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/totp/keys/acme", userToken, `{"key": "`+secret+`"}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	// the seed never shows up again
+	for _, path := range []string{"/v1/totp/keys/acme", "/v1/totp/code/acme"} {
+		res = serve(router, http.MethodGet, path, userToken, "")
+		if res.Code != http.StatusOK || strings.Contains(res.Body.String(), secret) {
+			t.Errorf("Seed disclosed by %s! Got %v %s", path, res.Code, res.Body.String())
+		}
+	}
+
+	res = serve(router, http.MethodPut, "/v1/totp/keys/vendor", adminToken, `{"key": "`+strings.ToLower(secret)+`", "issuer": "Vendor",
+		"algorithm": "sha256", "digits": 8, "period": 60}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if strings.Contains(res.Body.String(), secret) {
+		t.Errorf("Seed disclosed on import! %s", res.Body.String())
+	}
+
+	res = serve(router, http.MethodGet, "/v1/totp/code/vendor", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vendor := &algo.TOTP{Secret: secret, Digits: 8, Period: time.Minute, Algorithm: algo.TOTPSHA256}
+
+	_, ok, err = vendor.Validate(code.Code, time.Now(), 1)
+	if err != nil || !ok || len(code.Code) != 8 {
+		t.Errorf("Bad code! Got %s", code.Code)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/totp/keys/vendor", adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/totp/code/vendor", adminToken, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,366 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultTokenExpiresIn = 24 * time.Hour
+)
+
+var (
+	errNotAdmin     = errors.New("user: admin rights required")
+	errEmptyPasswd  = errors.New("user: password must not be empty")
+	errBadTokenTTL  = errors.New("user: bad token expiration time")
+	errMissingField = errors.New("user: missing required field")
+	errUserDisabled = errors.New("user: disabled")
+)
+
+// CreateUser adds a new user to the authdb, only admins are allowed to do so
+func (router *Router) CreateUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.create", "")
+	if !ok {
+		return
+	}
+
+	req := model.UserRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "user.create", req.Username, err)
+		badRequest(w, err)
+		return
+	}
+
+	if req.Username == "" {
+		audit.Record(actor, "user.create", req.Username, errMissingField)
+		badRequest(w, errMissingField)
+		return
+	}
+
+	if req.Password == nil || *req.Password == "" {
+		audit.Record(actor, "user.create", req.Username, errEmptyPasswd)
+		badRequest(w, errEmptyPasswd)
+		return
+	}
+
+	u := &model.User{
+		Username:       req.Username,
+		TokenExpiresIn: defaultTokenExpiresIn,
+	}
+
+	err = applyUserRequest(u, &req)
+	if err != nil {
+		audit.Record(actor, "user.create", req.Username, err)
+		badRequest(w, err)
+		return
+	}
+
+	err = auth.CreateUser(u, router.phrase)
+	audit.Record(actor, "user.create", req.Username, err)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, u.Info())
+}
+
+// ListUsers returns the public view of every user, only admins are allowed to do so
+func (router *Router) ListUsers(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.list", "")
+	if !ok {
+		return
+	}
+
+	users, err := auth.ListUsers(router.phrase)
+	audit.Record(actor, "user.list", "", err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	infos := []*model.UserInfo{}
+	for _, u := range users {
+		infos = append(infos, u.Info())
+	}
+
+	responseJSON(w, http.StatusOK, infos)
+}
+
+// GetUser returns the public view of a single user, only admins are allowed to do so
+func (router *Router) GetUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.read", p.ByName("username"))
+	if !ok {
+		return
+	}
+
+	username := p.ByName("username")
+
+	u, err := router.readUser(username)
+	audit.Record(actor, "user.read", username, err)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, u.Info())
+}
+
+// UpdateUser partially updates a user with the fields sent, only admins are allowed to do so
+func (router *Router) UpdateUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.update", p.ByName("username"))
+	if !ok {
+		return
+	}
+
+	req := model.UserRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "user.update", p.ByName("username"), err)
+		badRequest(w, err)
+		return
+	}
+
+	router.updateUser(w, actor, "user.update", p.ByName("username"), &req)
+}
+
+// RemoveUser drops the user from the authdb, only admins are allowed to do so
+func (router *Router) RemoveUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.delete", p.ByName("username"))
+	if !ok {
+		return
+	}
+
+	username := p.ByName("username")
+
+	err := auth.RemoveUser(username)
+	audit.Record(actor, "user.delete", username, err)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+
+	// a user created later under the same name must not inherit the old tokens
+	err = auth.RevokeUser(username, router.phrase)
+	audit.Record(actor, "auth.revoke.user", username, err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// nor the old api keys
+	err = auth.RemoveAPIKeys(username, router.phrase)
+	audit.Record(actor, "apikey.remove", username, err)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	responseHeader(w, http.StatusNoContent)
+}
+
+// ChangePassword replaces the user password, users may change their own password while admins may change anyone's
+func (router *Router) ChangePassword(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	username := p.ByName("username")
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		audit.Record("", "user.password", username, errNoPrincipal)
+		unauthorized(w, errNoPrincipal)
+		return
+	}
+
+	actor := principal.Username
+	if actor != username {
+		if _, ok := router.administrator(w, r, "user.password", username); !ok {
+			return
+		}
+	}
+
+	req := model.UserRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "user.password", username, err)
+		badRequest(w, err)
+		return
+	}
+
+	if req.Password == nil {
+		audit.Record(actor, "user.password", username, errMissingField)
+		badRequest(w, errMissingField)
+		return
+	}
+
+	router.updateUser(w, actor, "user.password", username, &model.UserRequest{Password: req.Password})
+}
+
+// EnableUser allows a disabled user to login again, only admins are allowed to do so
+func (router *Router) EnableUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.enable", p.ByName("username"))
+	if !ok {
+		return
+	}
+
+	disabled := false
+	router.updateUser(w, actor, "user.enable", p.ByName("username"), &model.UserRequest{Disabled: &disabled})
+}
+
+// DisableUser forbids the user to login without removing it, only admins are allowed to do so
+func (router *Router) DisableUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.disable", p.ByName("username"))
+	if !ok {
+		return
+	}
+
+	disabled := true
+	router.updateUser(w, actor, "user.disable", p.ByName("username"), &model.UserRequest{Disabled: &disabled})
+}
+
+// SetTokenTTL changes the expiration time of the tokens issued to the user, only admins are allowed to do so
+func (router *Router) SetTokenTTL(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	actor, ok := router.administrator(w, r, "user.ttl", p.ByName("username"))
+	if !ok {
+		return
+	}
+
+	req := model.UserRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		audit.Record(actor, "user.ttl", p.ByName("username"), err)
+		badRequest(w, err)
+		return
+	}
+
+	if req.TokenExpiresIn == nil {
+		audit.Record(actor, "user.ttl", p.ByName("username"), errMissingField)
+		badRequest(w, errMissingField)
+		return
+	}
+
+	router.updateUser(w, actor, "user.ttl", p.ByName("username"), &model.UserRequest{TokenExpiresIn: req.TokenExpiresIn})
+}
+
+// updateUser applies the requested changes to the user record
+func (router *Router) updateUser(w http.ResponseWriter, actor, action, username string, req *model.UserRequest) {
+
+	u, err := auth.UpdateUser(username, func(u *model.User) error {
+		return applyUserRequest(u, req)
+	}, router.phrase)
+	audit.Record(actor, action, username, err)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, u.Info())
+}
+
+// administrator checks whether the authenticated principal is an admin
+// On failure the proper status is written, the attempt is audited and false is returned
+func (router *Router) administrator(w http.ResponseWriter, r *http.Request, action, target string) (string, bool) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		audit.Record("", action, target, errNoPrincipal)
+		unauthorized(w, errNoPrincipal)
+		return "", false
+	}
+
+	if !principal.Admin {
+		audit.Record(principal.Username, action, target, errNotAdmin)
+		forbidden(w, errNotAdmin)
+		return "", false
+	}
+
+	return principal.Username, true
+}
+
+// readUser reads the user record treating an empty record as a missing one
+func (router *Router) readUser(username string) (*model.User, error) {
+
+	u, err := auth.ReadUser(username, router.phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		return nil, auth.ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+// applyUserRequest copies the sent fields of the request into the user record
+func applyUserRequest(u *model.User, req *model.UserRequest) error {
+
+	if req.Password != nil {
+		if *req.Password == "" {
+			return errEmptyPasswd
+		}
+		u.Password = auth.HashPassword(*req.Password)
+	}
+
+	if req.TokenExpiresIn != nil {
+		ttl, err := time.ParseDuration(*req.TokenExpiresIn)
+		if err != nil || ttl <= 0 {
+			return errBadTokenTTL
+		}
+		u.TokenExpiresIn = ttl
+	}
+
+	if req.Admin != nil {
+		u.Admin = *req.Admin
+	}
+
+	if req.Disabled != nil {
+		u.Disabled = *req.Disabled
+	}
+
+	if req.Roles != nil {
+		u.Roles = *req.Roles
+	}
+
+	if req.Policies != nil {
+		u.Policies = *req.Policies
+	}
+
+	return nil
+}
+
+// userError maps the user storage errors into the proper response status
+func userError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case auth.ErrUserNotFound:
+		notFound(w, err)
+	case auth.ErrUserExists:
+		conflict(w, err)
+	case fs.ErrBadInput, errEmptyPasswd, errBadTokenTTL:
+		badRequest(w, err)
+	default:
+		serverError(w, err)
+	}
+}
@@ -0,0 +1,267 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const (
+	testAdmin       = "kripto_admin"
+	testAdminPasswd = "admin"
+	testNewUser     = "kripto_user"
+)
+
+func TestShouldManageUsers(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users", adminToken, `{"username": "", "password": "secret"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Nameless user created! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users", adminToken, `{"username": "kripto_user", "password": "secret", "token_expires_in": "30m"}`)
+	if res.Code != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/users/"+testNewUser, adminToken, "")
+	info := model.UserInfo{}
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Username != testNewUser || info.TokenExpiresIn != "30m0s" || info.Admin || info.Disabled {
+		t.Errorf("Bad user returned! Got %+v", info)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/users/"+testNewUser+"/ttl", adminToken, `{"token_expires_in": "1h"}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testNewUser+"/disable", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if status := login(router, testNewUser, "secret"); status != http.StatusUnauthorized {
+		t.Errorf("Disabled user logged in! Got %v expected %v", status, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/users/"+testNewUser+"/enable", adminToken, "")
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/users/"+testNewUser+"/password", adminToken, `{"password": "changed"}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if status := login(router, testNewUser, "changed"); status != http.StatusCreated {
+		t.Errorf("Bad status! Got %v expected %v", status, http.StatusCreated)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/users", adminToken, "")
+	infos := []model.UserInfo{}
+	err = json.NewDecoder(res.Body).Decode(&infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 2 {
+		t.Errorf("Bad user list! Got %d users expected %d", len(infos), 2)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/users/"+testNewUser, adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/users/"+testNewUser, adminToken, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotCreateDuplicatedUser(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users", adminToken, `{"username": "kripto_admin", "password": "other"}`)
+	if res.Code != http.StatusConflict {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusConflict)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldKeepAdminChangesRacingFailedLogins(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users", adminToken, `{"username": "kripto_user", "password": "secret"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, _ = auth.FailLogin(testNewUser, testPassphrase)
+				_ = auth.SucceedLogin(testNewUser, testPassphrase)
+			}
+		}
+	}()
+
+	// a failed login never writes back the record it read before the admin change
+	for i := 0; i < 100; i++ {
+		action, disabled := "/disable", true
+		if i%2 == 1 {
+			action, disabled = "/enable", false
+		}
+
+		res = serve(router, http.MethodPost, "/v1/users/"+testNewUser+action, adminToken, "")
+		if res.Code != http.StatusOK {
+			t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+		}
+
+		// an update changing nothing reads the record under the same lock the logins take
+		u, err := auth.UpdateUser(testNewUser, func(*model.User) error { return nil }, testPassphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Disabled != disabled {
+			t.Errorf("Admin change lost to a failed login! Got disabled %v expected %v", u.Disabled, disabled)
+			break
+		}
+	}
+
+	close(stop)
+	<-done
+
+	res = serve(router, http.MethodDelete, "/v1/users/"+testNewUser, adminToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldNotManageUsersWithoutAdmin(t *testing.T) {
+
+	err := before()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/users", userToken, `{"username": "kripto_user", "password": "secret"}`)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(router, http.MethodDelete, "/v1/users/"+testUser, "not a token", "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/users/"+testUser+"/password", userToken, `{"password": "mine"}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Users must change their own password! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func beforeAdmin() (string, error) {
+
+	ac := &model.Credentials{
+		Username:       testAdmin,
+		Password:       testAdminPasswd,
+		TokenExpiresIn: defaultTokenExpiresIn,
+	}
+
+	l := auth.NewLogin(ac)
+	u := l.User()
+	u.Admin = true
+
+	err := auth.WriteUser(u, testPassphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return auth.NewJwtAuth(ac).GenerateToken()
+}
+
+// serve sends the request through every kripto route and middleware using the token as bearer
+func serve(router *Router, method, url, token, body string) *httptest.ResponseRecorder {
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	router.Routes().ServeHTTP(res, req)
+	return res
+}
+
+func login(router *Router, username, password string) int {
+
+	jc, _ := json.Marshal(&model.Credentials{Username: username, Password: password})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/authenticate", bytes.NewReader(jc))
+
+	router.Authenticate(res, req, nil)
+	return res.Code
+}
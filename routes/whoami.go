@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const introspectTokenType = "Bearer"
+
+// WhoAmI returns the authenticated principal, every policy it holds and when its credentials expire
+func (router *Router) WhoAmI(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		unauthorized(w, errNoPrincipal)
+		return
+	}
+
+	policies, err := auth.EffectivePolicies(principal, router.phrase)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	whoami := &model.WhoAmI{Principal: principal, EffectivePolicies: []string{}}
+
+	for _, policy := range policies {
+		whoami.EffectivePolicies = append(whoami.EffectivePolicies, policy.Name)
+	}
+
+	if !principal.ExpiresAt.IsZero() {
+		whoami.ExpiresIn = int64(time.Until(principal.ExpiresAt).Seconds())
+	}
+
+	responseJSON(w, http.StatusOK, whoami)
+}
+
+// Introspect tells services whether a token is active the way RFC 7662 does, checking its signature, claims and revocation
+// The token is sent as the token form parameter, or as json, along with the audience it was minted for when it is not kripto
+// Any failure answers 200 with active false so nothing is told about why
+func (router *Router) Introspect(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	req, err := introspectionRequest(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	inactive := &model.Introspection{Active: false}
+
+	audience := req.Audience
+	if audience == "" {
+		audience = auth.Audience
+	}
+
+	claims, err := auth.ValidateTokenFor(req.Token, audience)
+	if err != nil {
+		responseJSON(w, http.StatusOK, inactive)
+		return
+	}
+
+	principal, err := router.tokenPrincipal(claims)
+	if err != nil {
+		responseJSON(w, http.StatusOK, inactive)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, &model.Introspection{
+		Active:    true,
+		Scope:     strings.Join(principal.Policies, " "),
+		Username:  claims.Username,
+		TokenType: introspectTokenType,
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Nbf:       claims.NotBefore,
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+		Method:    claims.Method,
+		Roles:     principal.Roles,
+	})
+}
+
+// introspectionRequest reads the token and audience from the form, as RFC 7662 asks, or from a json body
+func introspectionRequest(r *http.Request) (*model.IntrospectionRequest, error) {
+
+	req := &model.IntrospectionRequest{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := r.ParseForm()
+		if err != nil {
+			return nil, err
+		}
+		req.Token = r.PostForm.Get("token")
+		req.Audience = r.PostForm.Get("audience")
+	}
+
+	if req.Token == "" {
+		return nil, errMissingIntrospectToken
+	}
+
+	return req, nil
+}
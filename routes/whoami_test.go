@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+)
+
+const testWhoAmI = "kripto_whoami"
+
+func TestShouldTellWhoAmI(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPut, "/v1/policies/payments", adminToken, `{"rules": [{"apps": "payments-*", "capabilities": ["read"]}]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	res = serve(router, http.MethodPut, "/v1/roles/developers", adminToken, `{"policies": ["payments"]}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	userToken := whoAmIUser(t)
+
+	res = serve(router, http.MethodGet, "/v1/auth/whoami", userToken, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	whoami := model.WhoAmI{}
+	err = json.NewDecoder(res.Body).Decode(&whoami)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if whoami.Principal == nil || whoami.Username != testWhoAmI || whoami.Admin || whoami.TokenID == "" {
+		t.Errorf("Bad principal! Got %+v", whoami.Principal)
+	}
+
+	if len(whoami.Roles) != 1 || len(whoami.EffectivePolicies) != 1 || whoami.EffectivePolicies[0] != "payments" {
+		t.Errorf("Bad roles or policies! Got %+v %v", whoami.Roles, whoami.EffectivePolicies)
+	}
+
+	if whoami.ExpiresIn <= 0 || whoami.ExpiresIn > int64(time.Hour.Seconds()) {
+		t.Errorf("Bad expiry! Got %v", whoami.ExpiresIn)
+	}
+
+	res = serve(router, http.MethodGet, "/v1/auth/whoami", "", "")
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusUnauthorized)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldIntrospectTokens(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	userToken := whoAmIUser(t)
+
+	introspection := introspect(t, router, adminToken, url.Values{"token": {userToken}})
+	if !introspection.Active || introspection.Username != testWhoAmI || introspection.Jti == "" || introspection.Exp == 0 || introspection.TokenType != "Bearer" {
+		t.Errorf("Bad introspection! Got %+v", introspection)
+	}
+
+	auth.Audiences = []string{"billing"}
+	defer func() { auth.Audiences = []string{} }()
+
+	res := serve(router, http.MethodPost, "/v1/auth/token", userToken, `{"audience": "billing"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	scoped := decodeSession(t, res.Body).Token
+
+	if introspection := introspect(t, router, adminToken, url.Values{"token": {scoped}}); introspection.Active {
+		t.Errorf("Token of another audience active for kripto! Got %+v", introspection)
+	}
+
+	if introspection := introspect(t, router, adminToken, url.Values{"token": {scoped}, "audience": {"billing"}}); !introspection.Active || introspection.Aud != "billing" {
+		t.Errorf("Bad introspection! Got %+v", introspection)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/logout", userToken, "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusNoContent)
+	}
+
+	inactive := map[string]string{
+		"a revoked token": userToken,
+		"garbage":         "not-a-token",
+	}
+
+	for name, token := range inactive {
+		introspection := introspect(t, router, adminToken, url.Values{"token": {token}})
+		if introspection.Active || introspection.Username != "" {
+			t.Errorf("Introspection of %s active! Got %+v", name, introspection)
+		}
+	}
+
+	res = serve(router, http.MethodPost, "/v1/auth/introspect", adminToken, `{"token": ""}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// whoAmIUser adds a user holding the developers role and returns a token of it
+func whoAmIUser(t *testing.T) string {
+
+	c := &model.Credentials{Username: testWhoAmI, Password: "whoami", TokenExpiresIn: time.Hour}
+
+	l := auth.NewLogin(c)
+	u := l.User()
+	u.Roles = []string{"developers"}
+
+	err := auth.WriteUser(u, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.NewJwtAuth(c).GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// introspect posts the form to the introspection endpoint as the caller
+func introspect(t *testing.T, router *Router, token string, form url.Values) *model.Introspection {
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	router.Routes().ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	introspection := &model.Introspection{}
+
+	err := json.NewDecoder(res.Body).Decode(introspection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return introspection
+}
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffhenkes/kripto/audit"
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/model"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const wrapHeader = "X-Kripto-Wrap-TTL"
+
+type (
+	// wrapRecorder keeps the response of the wrapped handler instead of sending it
+	wrapRecorder struct {
+		header http.Header
+		status int
+		body   bytes.Buffer
+	}
+)
+
+// Wrappable is a middleware that, when the request asks for it through the X-Kripto-Wrap-TTL header,
+// keeps a successful response aside and returns a single use wrapping token in its place
+func (router *Router) Wrappable(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+		value := r.Header.Get(wrapHeader)
+		if value == "" {
+			h(w, r, p)
+			return
+		}
+
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			unauthorized(w, errNoPrincipal)
+			return
+		}
+
+		ttl, err := auth.ParseWrapTTL(value)
+		if err != nil {
+			audit.Record(principal.Username, "sys.wrap", r.URL.Path, err)
+			badRequest(w, err)
+			return
+		}
+
+		rec := &wrapRecorder{header: http.Header{}}
+		h(rec, r, p)
+
+		// failures are not worth wrapping
+		if rec.status != http.StatusOK && rec.status != http.StatusCreated {
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+			return
+		}
+
+		info, err := auth.Wrap(principal.Username, r.URL.Path, rec.status, rec.body.Bytes(), ttl, router.phrase)
+		audit.Record(principal.Username, "sys.wrap", r.URL.Path, err)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		responseJSON(w, http.StatusOK, info)
+	}
+}
+
+// Unwrap returns the wrapped response exactly once, the wrapping token is all it takes
+func (router *Router) Unwrap(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	req := model.UnwrapRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	wrapped, err := auth.Unwrap(req.Token, router.phrase)
+	if err == auth.ErrInvalidWrap {
+		audit.Record("", "sys.unwrap", "", err)
+		notFound(w, err)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	audit.Record("", "sys.unwrap", wrapped.CreationPath, nil)
+
+	responseHeader(w, wrapped.Status)
+	_, err = w.Write(wrapped.Body)
+	if err != nil {
+		logR.Error("Bad output: %v", err)
+	}
+}
+
+func (rec *wrapRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *wrapRecorder) WriteHeader(status int) {
+
+	// only the first status counts, as for any response
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *wrapRecorder) Write(b []byte) (int, error) {
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	return rec.body.Write(b)
+}
@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffhenkes/kripto/auth"
+	"github.com/ffhenkes/kripto/fs"
+	"github.com/ffhenkes/kripto/model"
+)
+
+func TestShouldWrapSecretsOnce(t *testing.T) {
+
+	adminToken, err := beforeAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(testPassphrase)
+
+	res := serve(router, http.MethodPost, "/v1/secrets", adminToken, `{"app": "wrapped-app", "vars": {"TOKEN": "xyz"}}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusCreated)
+	}
+
+	res = serveWrapped(router, http.MethodGet, "/v1/secrets?app=wrapped-app", adminToken, "48h")
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusBadRequest)
+	}
+
+	res = serveWrapped(router, http.MethodGet, "/v1/secrets?app=wrapped-app", adminToken, "5m")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	if strings.Contains(res.Body.String(), "xyz") {
+		t.Errorf("Wrapped secret disclosed! %s", res.Body.String())
+	}
+
+	info := model.WrapInfo{}
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(info.Token, "krw_") || info.CreationPath != "/v1/secrets" || info.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("Bad wrapping token! Got %+v", info)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/sys/unwrap", "", `{"wrap_token": "`+info.Token+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Bad status! Got %v expected %v", res.Code, http.StatusOK)
+	}
+
+	secret, err := decodeSecret(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &model.Secret{App: "wrapped-app", Vars: map[string]string{"TOKEN": "xyz"}}
+	if !reflect.DeepEqual(secret, expected) {
+		t.Errorf("Bad unwrapped secret! Got %+v expected %+v", secret, expected)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/sys/unwrap", "", `{"wrap_token": "`+info.Token+`"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Unwrapped twice! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	// failures are answered as they are
+	res = serveWrapped(router, http.MethodGet, "/v1/secrets?app=missing-app", adminToken, "5m")
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Bad status! Got %v expected %v", res.Code, http.StatusInternalServerError)
+	}
+
+	expired, err := auth.Wrap(testAdmin, "/v1/secrets", http.StatusOK, []byte(`{}`), -time.Minute, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = serve(router, http.MethodPost, "/v1/sys/unwrap", "", `{"wrap_token": "`+expired.Token+`"}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expired response unwrapped! Got %v expected %v", res.Code, http.StatusNotFound)
+	}
+
+	_, err = auth.Wrap(testAdmin, "/v1/secrets", http.StatusOK, []byte(`{}`), -time.Minute, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := auth.PruneWrapped(testPassphrase)
+	if err != nil || pruned != 1 {
+		t.Errorf("Bad prune! Got %v %v expected 1", pruned, err)
+	}
+
+	err = fs.NewFileSystem(dataSecrets).DeleteSecret("wrapped-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tearDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveWrapped sends the request asking for its response to be wrapped for the ttl
+func serveWrapped(router *Router, method, url, token, ttl string) *httptest.ResponseRecorder {
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, bytes.NewReader(nil))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("X-Kripto-Wrap-TTL", ttl)
+
+	router.Routes().ServeHTTP(res, req)
+	return res
+}